@@ -0,0 +1,313 @@
+// Package client decrypts E2E payloads for their recipients, so services can
+// consume a handover directly rather than shelling out to decrypt.
+//
+//	key, err := client.ParsePrivateKey(keyPem)
+//	...
+//	var config Config
+//	headers, err := client.Unmarshal(os.Stdin, key, &config)
+//
+// The E2E armour and binary container (all payload versions) and JWE payloads
+// are supported. Payloads are authenticated by their content cipher, with the
+// headers as additional data, so tampered payloads fail to decrypt. As anyone
+// can encrypt to a recipient's public key, the plugin also signs payloads on
+// request with its signing key, published at <mount>/signingkey. A Client with a
+// SigningKey requires payloads to be signed by it, and verifies the signature
+// as the payload is read. Payloads past their EXPIRES time are refused. Leased
+// payloads are checked against the plugin's revocation list, which lists
+// their ids once their lease is revoked or expires.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"filippo.io/age/armor"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+// Payload formats, as detected by DetectFormat
+const (
+	FormatArmour          = "armour"
+	FormatContainer       = "container"
+	FormatContainerBase64 = "container-base64"
+	FormatJWE             = "jwe"
+	FormatAge             = "age"
+	FormatAgeArmour       = "age-armour"
+	FormatPGP             = "pgp"
+)
+
+// ErrUnsupportedFormat is returned for age and PGP payloads, which are
+// decrypted with age identities and OpenPGP keys rather than a crypto.Decrypter
+var ErrUnsupportedFormat = errors.New("payload format is not decrypted with a crypto.Decrypter")
+
+// containerMagicBase64 starts a base64 encoded binary container
+var containerMagicBase64 = base64.StdEncoding.EncodeToString([]byte(envelope.ContainerMagic))[:4]
+
+// Headers describe a decrypted payload
+type Headers struct {
+	envelope.Headers
+	// Format is the payload format, as detected by DetectFormat. JWE
+	// payloads only carry PayloadID, Expires, SigningKey and Signature, PGP
+	// payloads PayloadID, and age payloads no headers.
+	Format string `json:"format"`
+}
+
+// Client decrypts payloads, its zero value checks no revocation list and
+// limits decompressed payloads to envelope.DefaultMaxDecompressedSize
+type Client struct {
+	// MaxSize is the maximum size in bytes of a decompressed payload
+	MaxSize int64
+	// Revocations is the revocation list file or url (e.g.
+	// https://vault:8200/v1/e2e/revocations) leased payloads are checked
+	// against, they are not checked if it is empty
+	Revocations string
+	// SigningKey is the plugin's signing key file or url (e.g.
+	// https://vault:8200/v1/e2e/signingkey) payloads must be signed by,
+	// signatures are not verified if it is empty
+	SigningKey string
+}
+
+// Decrypt reads and decrypts a payload with the recipient's private key
+func Decrypt(r io.Reader, key crypto.Decrypter) ([]byte, Headers, error) {
+	return (&Client{}).Decrypt(r, key)
+}
+
+// Unmarshal decrypts a payload and unmarshals its JSON into v
+func Unmarshal(r io.Reader, key crypto.Decrypter, v interface{}) (Headers, error) {
+	return (&Client{}).Unmarshal(r, key, v)
+}
+
+// Decrypt reads and decrypts a payload with the recipient's private key
+func (c *Client) Decrypt(r io.Reader, key crypto.Decrypter) ([]byte, Headers, error) {
+	plaintext, headers, err := c.NewReader(r, key)
+	if err != nil {
+		return nil, headers, err
+	}
+	decrypted, err := ioutil.ReadAll(plaintext)
+	if err != nil {
+		return nil, headers, err
+	}
+	return decrypted, headers, nil
+}
+
+// Unmarshal decrypts a payload and unmarshals its JSON into v
+func (c *Client) Unmarshal(r io.Reader, key crypto.Decrypter, v interface{}) (Headers, error) {
+	plaintext, headers, err := c.Decrypt(r, key)
+	if err != nil {
+		return headers, err
+	}
+	return headers, json.Unmarshal(plaintext, v)
+}
+
+// NewReader reads a payload's headers and returns a reader of its plaintext.
+// STREAM payloads are decrypted a chunk at a time as they are read, each chunk
+// is authenticated before it is returned, so a reader error means the payload
+// was truncated or tampered with, or its signature does not verify, and what
+// was read should be discarded.
+func (c *Client) NewReader(r io.Reader, key crypto.Decrypter) (io.Reader, Headers, error) {
+	input := bufio.NewReader(r)
+	format := DetectFormat(input)
+	headers := Headers{Format: format}
+	privateKey := unwrapKey(key)
+
+	var h *envelope.Headers
+	var payload io.Reader
+	var err error
+	switch format {
+	case FormatJWE:
+		return c.jweReader(input, privateKey)
+	case FormatContainer:
+		h, payload, err = envelope.ReadContainer(input)
+	case FormatContainerBase64:
+		h, payload, err = envelope.ReadContainer(base64.NewDecoder(base64.StdEncoding, input))
+	case FormatArmour:
+		h, payload, err = envelope.DecodeArmour(input)
+	default:
+		return nil, headers, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, headers, err
+	}
+	headers.Headers = *h
+
+	// check leased payloads have not been revoked
+	if err := c.CheckRevoked(h.PayloadID); err != nil {
+		return nil, headers, err
+	}
+	if err := checkExpires(h); err != nil {
+		return nil, headers, err
+	}
+	if err := checkKey(h, privateKey); err != nil {
+		return nil, headers, err
+	}
+
+	// the signature is verified as the body is read, STREAM payloads failing
+	// before their last chunk is returned
+	verifyKey, keyID, err := c.verifyKey()
+	if err != nil {
+		return nil, headers, err
+	}
+	if verifyKey != nil {
+		if payload, err = envelope.NewVerifyingReader(verifyKey, keyID, h, payload); err != nil {
+			return nil, headers, err
+		}
+	}
+
+	// version 2.1 and later payloads authenticate their headers
+	additionalData := h.AdditionalData()
+
+	aead, nonce, err := envelope.Decapsulate(privateKey, h.Cipher, payload)
+	if err != nil {
+		return nil, headers, err
+	}
+
+	var plaintext io.Reader
+	if h.ChunkSize != 0 {
+		plaintext, err = envelope.NewStreamReader(payload, aead, nonce, additionalData, h.ChunkSize)
+		if err != nil {
+			return nil, headers, err
+		}
+	} else {
+		ciphertext, err := ioutil.ReadAll(payload)
+		if err != nil {
+			return nil, headers, err
+		}
+		opened, err := aead.Open(nil, nonce, ciphertext, additionalData)
+		if err != nil {
+			return nil, headers, err
+		}
+		plaintext = bytes.NewReader(opened)
+	}
+
+	// decompress, failing rather than expanding past MaxSize
+	plaintext, err = envelope.NewDecompressor(h.Compression, plaintext, c.maxSize())
+	if err != nil {
+		return nil, headers, err
+	}
+	return plaintext, headers, nil
+}
+
+// jweReader decrypts a JWE payload, which is read whole
+func (c *Client) jweReader(input io.Reader, key crypto.PrivateKey) (io.Reader, Headers, error) {
+	headers := Headers{Format: FormatJWE}
+	serialised, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, headers, err
+	}
+	jwe, err := envelope.ParseJWE(serialised)
+	if err != nil {
+		return nil, headers, err
+	}
+	h, err := jwe.Headers()
+	if err != nil {
+		return nil, headers, err
+	}
+	headers.Headers = *h
+
+	verifyKey, keyID, err := c.verifyKey()
+	if err != nil {
+		return nil, headers, err
+	}
+	if verifyKey != nil {
+		if err := jwe.Verify(verifyKey, keyID); err != nil {
+			return nil, headers, err
+		}
+	}
+	plaintext, err := jwe.Decrypt(key, c.maxSize())
+	if err != nil {
+		return nil, headers, err
+	}
+
+	// the protected header is authenticated by the decryption
+	if err := checkExpires(h); err != nil {
+		return nil, headers, err
+	}
+	if err := c.CheckRevoked(headers.PayloadID); err != nil {
+		return nil, headers, err
+	}
+	return bytes.NewReader(plaintext), headers, nil
+}
+
+func (c *Client) maxSize() int64 {
+	if c.MaxSize == 0 {
+		return envelope.DefaultMaxDecompressedSize
+	}
+	return c.MaxSize
+}
+
+// checkKey checks the private key is of the type the payload was encrypted
+// for
+func checkKey(h *envelope.Headers, key crypto.PrivateKey) error {
+	switch h.Version {
+	case envelope.Version40:
+		if _, ok := key.(*envelope.HybridPrivateKey); !ok {
+			return errors.New("payload requires an " + envelope.HybridKeyType + " private key")
+		}
+	case envelope.Version30:
+		ecKey, err := envelope.ECDHPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		if curve := fmt.Sprint(ecKey.Curve()); curve != h.KeyAgreement {
+			return errors.New("payload key agreement " + h.KeyAgreement + " does not match " + curve + " private key")
+		}
+	default:
+		if _, ok := envelope.IsRSADecrypter(key); !ok {
+			return errors.New("payload requires an RSA private key")
+		}
+	}
+	return nil
+}
+
+// DetectFormat peeks at the input, skipping leading white space, to detect
+// the payload format. A JWE starts with "{" (JSON serialisation) or "eyJ" (a
+// compact serialisation's protected header), an age file with its version
+// line or armour header, a PGP MESSAGE with its armour header, the binary
+// container with its magic (or its base64 encoding), and anything else is
+// read as the E2E armour.
+func DetectFormat(input *bufio.Reader) string {
+	for {
+		b, err := input.Peek(1)
+		if err != nil {
+			return FormatArmour
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			input.ReadByte()
+		case '{', 'e':
+			return FormatJWE
+		default:
+			if b, _ := input.Peek(len(envelope.AgeHeader)); string(b) == envelope.AgeHeader {
+				return FormatAge
+			}
+			if b, _ := input.Peek(len(armor.Header)); string(b) == armor.Header {
+				return FormatAgeArmour
+			}
+			if b, _ := input.Peek(len(envelope.OpenPGPMessageHeader)); string(b) == envelope.OpenPGPMessageHeader {
+				return FormatPGP
+			}
+			if b, _ := input.Peek(len(envelope.ContainerMagic)); string(b) == envelope.ContainerMagic {
+				return FormatContainer
+			}
+			if b, _ := input.Peek(len(containerMagicBase64)); string(b) == containerMagicBase64 {
+				return FormatContainerBase64
+			}
+			return FormatArmour
+		}
+	}
+}
+
+// unwrapKey returns the key an AgreementKey adapts, or the key itself
+func unwrapKey(key crypto.Decrypter) crypto.PrivateKey {
+	if agreementKey, ok := key.(*AgreementKey); ok {
+		return agreementKey.Key
+	}
+	return key
+}
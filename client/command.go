@@ -0,0 +1,72 @@
+package client
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// CommandKey is an RSA private key used through an external command, e.g. a
+// token vendor's tool or a script calling an agent. The command is run by
+// /bin/sh with the RSA-OAEP ciphertext on stdin, and must write the decrypted
+// message to stdout. E2E_OAEP_HASH (SHA256) and E2E_OAEP_LABEL (hex, empty for
+// no label) are set in its environment, e.g. with openssl:
+//
+//	openssl pkeyutl -decrypt -inkey key.pem -pkeyopt rsa_padding_mode:oaep \
+//	  -pkeyopt rsa_oaep_md:sha256 ${E2E_OAEP_LABEL:+-pkeyopt rsa_oaep_label:$E2E_OAEP_LABEL}
+type CommandKey struct {
+	Command   string
+	PublicKey *rsa.PublicKey
+}
+
+// Public returns the RSA public key
+func (key *CommandKey) Public() crypto.PublicKey {
+	return key.PublicKey
+}
+
+// Decrypt runs the command to RSA-OAEP (SHA-256) decrypt msg, opts must be
+// *rsa.OAEPOptions
+func (key *CommandKey) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	oaep, ok := opts.(*rsa.OAEPOptions)
+	if !ok || oaep.Hash != crypto.SHA256 {
+		return nil, errors.New("command keys only decrypt RSA-OAEP with SHA-256")
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", key.Command)
+	cmd.Env = append(os.Environ(), "E2E_OAEP_HASH=SHA256", "E2E_OAEP_LABEL="+hex.EncodeToString(oaep.Label))
+	cmd.Stdin = bytes.NewReader(msg)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("decrypt command: %v", err)
+	}
+	return out, nil
+}
+
+// ParseRSAPublicKey decodes a PEM RSA public key, as PKIX or PKCS#1
+func ParseRSAPublicKey(pubPem []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(pubPem)
+	if block == nil {
+		return nil, errors.New("failed to decode PEM block containing public key")
+	}
+	if pub, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return pub, nil
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an RSA public key", pub)
+	}
+	return rsaPub, nil
+}
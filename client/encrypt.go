@@ -0,0 +1,71 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+// ErrNotJSON is returned when encrypting a payload that is not JSON, as
+// payloads from the plugin always are
+var ErrNotJSON = errors.New("payload is not JSON")
+
+// Encrypt encrypts a JSON payload offline to a recipient's public key (PEM,
+// age recipient or OpenPGP key), exactly as the plugin's payload endpoint
+// would for an enrolement of the key with the same options. JWE payloads'
+// kid defaults to the key's fingerprint, as for an enrolement.
+func Encrypt(pubKeyPem []byte, plaintext []byte, opts *envelope.EncryptOptions) ([]byte, error) {
+	if !json.Valid(plaintext) {
+		return nil, ErrNotJSON
+	}
+	pub, keyType, der, err := envelope.ParsePublicKey(string(pubKeyPem))
+	if err != nil {
+		return nil, err
+	}
+	withKeyID := *opts
+	if withKeyID.KeyID == "" {
+		withKeyID.KeyID = envelope.Fingerprint(der)
+	}
+
+	encrypted, err := envelope.Encrypt(pub, keyType, plaintext, &withKeyID)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := encrypted.(string); ok {
+		return []byte(s), nil
+	}
+	// JWE flattened JSON
+	return json.Marshal(encrypted)
+}
+
+// Encrypter is a command line tool's offline encryption to a public key file
+type Encrypter struct {
+	PublicKeyFile string
+	Options       envelope.EncryptOptions
+}
+
+// RegisterFlags adds the -pubkey, -format, -cipher, -compression, -stream and
+// -payload-id flags
+func (e *Encrypter) RegisterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&e.PublicKeyFile, "pubkey", "", "recipient's public key file")
+	flags.StringVar(&e.Options.Format, "format", "", "output format: armour, binary, jwe, jwe-json, age, age-binary or pgp (defaults to pgp for OpenPGP recipients, age for age recipients and armour otherwise)")
+	flags.StringVar(&e.Options.Cipher, "cipher", "", "content cipher, as enroled (default AES-256-GCM)")
+	flags.StringVar(&e.Options.Compression, "compression", "", "compression applied before encryption, as enroled (default none)")
+	flags.BoolVar(&e.Options.Stream, "stream", false, "encrypt the payload as STREAM chunks")
+	flags.StringVar(&e.Options.PayloadID, "payload-id", "", "payload id to carry, as in a leased payload")
+}
+
+// Encrypt encrypts a JSON payload to the public key file
+func (e *Encrypter) Encrypt(plaintext []byte) ([]byte, error) {
+	if e.PublicKeyFile == "" {
+		return nil, errors.New("no public key given, use -pubkey")
+	}
+	pubKeyPem, err := ioutil.ReadFile(e.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return Encrypt(pubKeyPem, plaintext, &e.Options)
+}
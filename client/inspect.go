@@ -0,0 +1,204 @@
+package client
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age/armor"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+// Inspection is what can be learnt of a payload without decrypting it. None
+// of it is authenticated until the payload is decrypted.
+type Inspection struct {
+	Headers
+	// RSABlockLength is the length in bytes of an RSA payload's encrypted
+	// key block, the size of the recipient's RSA key
+	RSABlockLength int `json:"rsa_block_length,omitempty"`
+	// Recipients are the keys the payload is encrypted to, as far as the
+	// format identifies them
+	Recipients []Recipient `json:"recipients,omitempty"`
+	// Signatures are the key ids of OpenPGP signatures outside the
+	// encryption. The plugin's own signature is in the Headers' SigningKey
+	// and Signature.
+	Signatures []string `json:"signatures,omitempty"`
+}
+
+// Recipient identifies a key a payload is encrypted to
+type Recipient struct {
+	// Type is the key type: the JWE alg, the age stanza type, the OpenPGP
+	// public key algorithm, or the E2E key agreement
+	Type string `json:"type"`
+	// KeyID identifies the key, if the format carries it: the JWE kid (the
+	// enrolement fingerprint), the OpenPGP key id, or for age SSH recipients
+	// the first 4 bytes of the SSH key's SHA-256 fingerprint as hex
+	KeyID string `json:"key_id,omitempty"`
+}
+
+// Inspect reads a payload's headers and recipients without decrypting it
+func Inspect(r io.Reader) (*Inspection, error) {
+	input := bufio.NewReader(r)
+	format := DetectFormat(input)
+	inspection := &Inspection{Headers: Headers{Format: format}}
+
+	var h *envelope.Headers
+	var payload io.Reader
+	var err error
+	switch format {
+	case FormatJWE:
+		err = inspectJWE(input, inspection)
+	case FormatAge:
+		err = inspectAge(input, inspection)
+	case FormatAgeArmour:
+		err = inspectAge(armor.NewReader(input), inspection)
+	case FormatPGP:
+		err = inspectPGP(input, inspection)
+	case FormatContainer:
+		h, payload, err = envelope.ReadContainer(input)
+	case FormatContainerBase64:
+		h, payload, err = envelope.ReadContainer(base64.NewDecoder(base64.StdEncoding, input))
+	default:
+		h, payload, err = envelope.DecodeArmour(input)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return inspection, nil
+	}
+	inspection.Headers.Headers = *h
+	if h.Version == envelope.Version30 || h.Version == envelope.Version40 {
+		inspection.Recipients = []Recipient{{Type: h.KeyAgreement}}
+		return inspection, nil
+	}
+
+	// RSA payloads start with the length prefixed RSA-OAEP block
+	var length [2]byte
+	if _, err := io.ReadFull(payload, length[:]); err != nil {
+		return nil, envelope.ErrKeyTruncated
+	}
+	inspection.RSABlockLength = int(binary.LittleEndian.Uint16(length[:]))
+	inspection.Recipients = []Recipient{{Type: fmt.Sprintf("rsa-%d", inspection.RSABlockLength*8)}}
+	return inspection, nil
+}
+
+// inspectJWE records a JWE's payload headers and its alg and kid
+func inspectJWE(input io.Reader, inspection *Inspection) error {
+	serialised, err := ioutil.ReadAll(input)
+	if err != nil {
+		return err
+	}
+	jwe, err := envelope.ParseJWE(serialised)
+	if err != nil {
+		return err
+	}
+	h, err := jwe.Headers()
+	if err != nil {
+		return err
+	}
+	inspection.Headers.Headers = *h
+	header, err := jwe.Header()
+	if err != nil {
+		return err
+	}
+	recipient := Recipient{}
+	recipient.Type, _ = header["alg"].(string)
+	recipient.KeyID, _ = header["kid"].(string)
+	inspection.Recipients = []Recipient{recipient}
+	return nil
+}
+
+// inspectAge records an age file's recipient stanzas
+func inspectAge(input io.Reader, inspection *Inspection) error {
+	lines := bufio.NewReader(input)
+	version, err := lines.ReadString('\n')
+	if err != nil || strings.TrimSpace(version) != strings.TrimSpace(envelope.AgeHeader) {
+		return errors.New("not an age file")
+	}
+	for {
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			return errors.New("age header is truncated")
+		}
+		if strings.HasPrefix(line, "---") {
+			return nil
+		}
+		if !strings.HasPrefix(line, "-> ") {
+			// stanza body
+			continue
+		}
+		args := strings.Fields(strings.TrimPrefix(line, "-> "))
+		if len(args) == 0 {
+			return errors.New("age stanza has no type")
+		}
+		recipient := Recipient{Type: args[0]}
+		if strings.HasPrefix(args[0], "ssh-") && len(args) > 1 {
+			tag, err := base64.RawStdEncoding.DecodeString(args[1])
+			if err == nil {
+				recipient.KeyID = hex.EncodeToString(tag)
+			}
+		}
+		inspection.Recipients = append(inspection.Recipients, recipient)
+	}
+}
+
+// inspectPGP records a PGP MESSAGE's encrypted session key recipients, and
+// any signatures ahead of the encrypted data
+func inspectPGP(input io.Reader, inspection *Inspection) error {
+	block, err := pgparmor.Decode(input)
+	if err != nil {
+		return err
+	}
+	packets := packet.NewReader(block.Body)
+	for {
+		p, err := packets.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch p := p.(type) {
+		case *packet.EncryptedKey:
+			inspection.Recipients = append(inspection.Recipients, Recipient{
+				Type:  pgpAlgorithmName(p.Algo),
+				KeyID: fmt.Sprintf("%016X", p.KeyId),
+			})
+		case *packet.OnePassSignature:
+			inspection.Signatures = append(inspection.Signatures, fmt.Sprintf("%016X", p.KeyId))
+		case *packet.Signature:
+			if p.IssuerKeyId != nil {
+				inspection.Signatures = append(inspection.Signatures, fmt.Sprintf("%016X", *p.IssuerKeyId))
+			}
+		case *packet.SymmetricallyEncrypted, *packet.AEADEncrypted:
+			// the rest is encrypted
+			return nil
+		}
+	}
+}
+
+// pgpAlgorithmName names an OpenPGP encryption algorithm
+func pgpAlgorithmName(algo packet.PublicKeyAlgorithm) string {
+	switch algo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly:
+		return "RSA"
+	case packet.PubKeyAlgoElGamal:
+		return "ElGamal"
+	case packet.PubKeyAlgoECDH:
+		return "ECDH"
+	case packet.PubKeyAlgoX25519:
+		return "X25519"
+	case packet.PubKeyAlgoX448:
+		return "X448"
+	}
+	return fmt.Sprintf("algorithm-%d", algo)
+}
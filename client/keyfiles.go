@@ -0,0 +1,149 @@
+package client
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// KeyFiles is a command line tool's generation of a key pair to
+// <prefix>_<type>.pem and <prefix>_<type>_pub.pem, optionally with the public
+// key as a JWK in <prefix>_<type>_pub.jwk and the enrolement request body in
+// <prefix>_<type>_enrole.json
+type KeyFiles struct {
+	Prefix  string
+	KeyType string
+	Bits    int
+	// Encrypt encrypts the private key with a passphrase, from the
+	// PassphraseFile, $E2E_PASSPHRASE or prompted for
+	Encrypt        bool
+	PassphraseFile string
+	JWK            bool
+	// Enrole is the name of the enrolement to write the request body for,
+	// empty for none
+	Enrole string
+	// Force overwrites existing files, otherwise no file is overwritten
+	Force bool
+}
+
+// RegisterFlags adds the -prefix (defaulting to prefix), -type, -bits,
+// -encrypt, -passphrase-file, -jwk, -enrole and -force flags
+func (kf *KeyFiles) RegisterFlags(flags *flag.FlagSet, prefix string) {
+	flags.StringVar(&kf.Prefix, "prefix", prefix, "folder/file prefix to generate key pair to")
+	flags.StringVar(&kf.KeyType, "type", KeyTypeRSA, "key type to generate: rsa, p256, p384, x25519, mlkem768x25519 or ed25519")
+	flags.IntVar(&kf.Bits, "bits", DefaultRSABits, "rsa key size: 2048, 3072 or 4096")
+	flags.BoolVar(&kf.Encrypt, "encrypt", false, "encrypt the private key with a passphrase, from -passphrase-file, $"+PassphraseEnv+" or prompted for")
+	flags.StringVar(&kf.PassphraseFile, "passphrase-file", "", "file holding the passphrase to -encrypt the private key with")
+	flags.BoolVar(&kf.JWK, "jwk", false, "also write the public key as a JWK")
+	flags.StringVar(&kf.Enrole, "enrole", "", "also write the JSON enrolement request body, for an enrolement of this name")
+	flags.BoolVar(&kf.Force, "force", false, "overwrite existing key files")
+}
+
+// Filenames returns the files Generate writes, the private key first
+func (kf *KeyFiles) Filenames() []string {
+	base := fmt.Sprintf("%s_%s", kf.Prefix, kf.KeyType)
+	filenames := []string{base + ".pem", base + "_pub.pem"}
+	if kf.JWK {
+		filenames = append(filenames, base+"_pub.jwk")
+	}
+	if kf.Enrole != "" {
+		filenames = append(filenames, base+"_enrole.json")
+	}
+	return filenames
+}
+
+// Generate generates the key pair and writes its files, returning their
+// names. Unless Force is set, every file is checked before the key is
+// generated, so an existing file fails without any file being written.
+func (kf *KeyFiles) Generate() ([]string, error) {
+	if kf.KeyType == KeyTypeMLKEM768X25519 && (kf.Encrypt || kf.JWK) {
+		return nil, fmt.Errorf("%s keys have no PKCS#8 or JWK encoding, so cannot be encrypted or written as a JWK", kf.KeyType)
+	}
+	filenames := kf.Filenames()
+	if !kf.Force {
+		for _, filename := range filenames {
+			if _, err := os.Lstat(filename); err == nil {
+				return nil, fmt.Errorf("%s exists, use -force to overwrite it", filename)
+			}
+		}
+	}
+
+	privateKey, pubKey, err := GenerateKey(kf.KeyType, kf.Bits)
+	if err != nil {
+		return nil, err
+	}
+	if kf.Encrypt {
+		keySource := &KeySource{PassphraseFile: kf.PassphraseFile}
+		passphrase, err := keySource.NewPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err = EncryptPrivateKey(privateKey, passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	contents := [][]byte{pem.EncodeToMemory(privateKey), pem.EncodeToMemory(pubKey)}
+	if kf.JWK {
+		publicJWK, err := PublicKeyJWK(pubKey)
+		if err != nil {
+			return nil, err
+		}
+		jwkJSON, err := json.MarshalIndent(publicJWK, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, append(jwkJSON, '\n'))
+	}
+	// the body of a POST to e2e/enrole/<name>, with the PEM JSON escaped
+	if kf.Enrole != "" {
+		body, err := json.Marshal(map[string]string{
+			"name":   kf.Enrole,
+			"pubkey": string(pem.EncodeToMemory(pubKey)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, append(body, '\n'))
+	}
+
+	for i, filename := range filenames {
+		perm := os.FileMode(0644)
+		if i == 0 {
+			perm = 0600
+		}
+		if err := WriteKeyFile(filename, perm, contents[i], kf.Force); err != nil {
+			return nil, err
+		}
+	}
+	return filenames, nil
+}
+
+// WriteKeyFile writes a key file with perm. It refuses to overwrite an
+// existing file unless force is set, when the file is truncated and its
+// permissions reset to perm, so an overwritten private key is never left
+// readable.
+func WriteKeyFile(filename string, perm os.FileMode, data []byte, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(filename, flags, perm)
+	if err != nil {
+		return err
+	}
+	if force {
+		if err := f.Chmod(perm); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
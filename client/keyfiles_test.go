@@ -0,0 +1,87 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteKeyFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "key.pem")
+
+	if err := WriteKeyFile(filename, 0600, []byte("first"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKeyFile(filename, 0600, []byte("second"), false); !os.IsExist(err) {
+		t.Errorf("overwrite without force: %v, want the file to exist", err)
+	}
+
+	// a private key made readable is reset when it is overwritten
+	if err := os.Chmod(filename, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKeyFile(filename, 0600, []byte("2nd"), true); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("overwritten key mode %o, want 600", info.Mode().Perm())
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil || string(data) != "2nd" {
+		t.Errorf("overwritten key %q %v, want it truncated", data, err)
+	}
+}
+
+func TestKeyFilesGenerate(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "key")
+	keyFiles := &KeyFiles{Prefix: prefix, KeyType: KeyTypeP256, JWK: true, Enrole: "myhost"}
+
+	filenames, err := keyFiles.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{prefix + "_p256.pem", prefix + "_p256_pub.pem", prefix + "_p256_pub.jwk", prefix + "_p256_enrole.json"}
+	if strings.Join(filenames, " ") != strings.Join(want, " ") {
+		t.Fatalf("wrote %v, want %v", filenames, want)
+	}
+	for i, filename := range filenames {
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		perm := os.FileMode(0644)
+		if i == 0 {
+			perm = 0600
+		}
+		if info.Mode().Perm() != perm {
+			t.Errorf("%s mode %o, want %o", filename, info.Mode().Perm(), perm)
+		}
+	}
+
+	// every file is checked before any is written
+	if err := os.Remove(filenames[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyFiles.Generate(); err == nil || !strings.Contains(err.Error(), "use -force") {
+		t.Errorf("generate over existing files: %v", err)
+	}
+	if _, err := os.Stat(filenames[0]); !os.IsNotExist(err) {
+		t.Errorf("private key written although the public key exists: %v", err)
+	}
+
+	keyFiles.Force = true
+	if _, err := keyFiles.Generate(); err != nil {
+		t.Errorf("generate with force: %s", err)
+	}
+
+	hybrid := &KeyFiles{Prefix: prefix, KeyType: KeyTypeMLKEM768X25519, JWK: true}
+	if _, err := hybrid.Generate(); err == nil {
+		t.Error("hybrid key written as a JWK")
+	}
+}
@@ -0,0 +1,151 @@
+package client
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+// Key types GenerateKey generates
+const (
+	KeyTypeRSA            = "rsa"
+	KeyTypeP256           = "p256"
+	KeyTypeP384           = "p384"
+	KeyTypeEd25519        = "ed25519"
+	KeyTypeX25519         = "x25519"
+	KeyTypeMLKEM768X25519 = "mlkem768x25519"
+)
+
+// DefaultRSABits is the size of generated RSA keys
+const DefaultRSABits = 2048
+
+// GenerateKey generates a key pair, returning the PKCS#8 private key and PKIX
+// public key PEM blocks (ML-KEM-768+X25519 keys, which have no x509 encoding,
+// use the plugin's own PEM types). bits is the RSA key size, zero for
+// DefaultRSABits. Ed25519 keys only sign, they cannot be sent payloads.
+func GenerateKey(keyType string, bits int) (*pem.Block, *pem.Block, error) {
+	var key crypto.PrivateKey
+	var publicKey crypto.PublicKey
+	switch keyType {
+	case KeyTypeRSA:
+		if bits == 0 {
+			bits = DefaultRSABits
+		}
+		if bits != 2048 && bits != 3072 && bits != 4096 {
+			return nil, nil, fmt.Errorf("rsa keys must be 2048, 3072 or 4096 bits, not %d", bits)
+		}
+		rsaKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, publicKey = rsaKey, &rsaKey.PublicKey
+	case KeyTypeP256, KeyTypeP384:
+		// generated as ECDSA keys so they encode with the usual EC key OIDs
+		curve := elliptic.P256()
+		if keyType == KeyTypeP384 {
+			curve = elliptic.P384()
+		}
+		ecKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, publicKey = ecKey, &ecKey.PublicKey
+	case KeyTypeEd25519:
+		edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, publicKey = edKey, edPub
+	case KeyTypeX25519:
+		ecKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, publicKey = ecKey, ecKey.PublicKey()
+	case KeyTypeMLKEM768X25519:
+		hybridKey, err := envelope.GenerateHybridKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		return &pem.Block{Type: envelope.HybridPrivateKeyPEMType, Bytes: hybridKey.Bytes()},
+			&pem.Block{Type: envelope.HybridPublicKeyPEMType, Bytes: hybridKey.Public().Bytes()}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %q", keyType)
+	}
+
+	pkcs8Bytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	pkixBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Bytes}, &pem.Block{Type: "PUBLIC KEY", Bytes: pkixBytes}, nil
+}
+
+// PublicKeyJWK returns a PKIX PUBLIC KEY block as a public JWK (RFC 7517,
+// RFC 8037 for X25519 and Ed25519), with the key's enrolement fingerprint as
+// the kid, which is the kid of JWE payloads to it
+func PublicKeyJWK(block *pem.Block) (map[string]interface{}, error) {
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s has no JWK encoding", block.Type)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	jwk := map[string]interface{}{
+		"kid": envelope.Fingerprint(block.Bytes),
+		"use": "enc",
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["alg"] = "RSA-OAEP-256"
+		jwk["n"] = b64(pub.N.Bytes())
+		jwk["e"] = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		ecPub, err := pub.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		// uncompressed point, 0x04 | x | y
+		point := ecPub.Bytes()[1:]
+		jwk["kty"] = "EC"
+		jwk["alg"] = "ECDH-ES"
+		jwk["crv"] = pub.Curve.Params().Name
+		jwk["x"] = b64(point[:len(point)/2])
+		jwk["y"] = b64(point[len(point)/2:])
+	case *ecdh.PublicKey:
+		if pub.Curve() != ecdh.X25519() {
+			return nil, fmt.Errorf("unsupported ecdh curve %s", pub.Curve())
+		}
+		jwk["kty"] = "OKP"
+		jwk["alg"] = "ECDH-ES"
+		jwk["crv"] = "X25519"
+		jwk["x"] = b64(pub.Bytes())
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["alg"] = "EdDSA"
+		jwk["use"] = "sig"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = b64(pub)
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return jwk, nil
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+	"golang.org/x/crypto/ssh"
+)
+
+// AgreementKey adapts an ECDH (P-256, P-384 or X25519) or ML-KEM-768+X25519
+// hybrid private key to crypto.Decrypter, so Decrypt takes the same key type
+// for all recipients. Payloads for these keys are decrypted by key agreement,
+// its Decrypt method always fails.
+type AgreementKey struct {
+	Key crypto.PrivateKey
+}
+
+// NewAgreementKey adapts an *ecdh.PrivateKey, *ecdsa.PrivateKey or
+// *envelope.HybridPrivateKey
+func NewAgreementKey(key crypto.PrivateKey) (*AgreementKey, error) {
+	if _, ok := key.(*envelope.HybridPrivateKey); ok {
+		return &AgreementKey{Key: key}, nil
+	}
+	ecKey, err := envelope.ECDHPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := envelope.ECDHPublicKey(ecKey.PublicKey()); err != nil {
+		return nil, err
+	}
+	return &AgreementKey{Key: key}, nil
+}
+
+// Public returns the public key
+func (key *AgreementKey) Public() crypto.PublicKey {
+	if hybridKey, ok := key.Key.(*envelope.HybridPrivateKey); ok {
+		return hybridKey.Public()
+	}
+	ecKey, _ := envelope.ECDHPrivateKey(key.Key)
+	return ecKey.PublicKey()
+}
+
+// Decrypt fails, key agreement keys only decrypt payloads
+func (key *AgreementKey) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return nil, errors.New("key agreement private keys only decrypt payloads")
+}
+
+// Private key passphrase errors
+var (
+	ErrPassphraseRequired  = errors.New("private key is encrypted, a passphrase is required")
+	ErrIncorrectPassphrase = errors.New("incorrect private key passphrase")
+)
+
+// PassphraseFunc returns the passphrase of an encrypted private key, it is
+// only called if the key is encrypted
+type PassphraseFunc func() ([]byte, error)
+
+// ParsePrivateKey decodes an unencrypted private key, see
+// ParsePrivateKeyWithPassphrase
+func ParsePrivateKey(keyPem []byte) (crypto.Decrypter, error) {
+	return ParsePrivateKeyWithPassphrase(keyPem, nil)
+}
+
+// ParsePrivateKeyWithPassphrase decodes a PEM private key, as PKCS#1 (RSA),
+// SEC 1 (EC), PKCS#8 (any supported type), an ML-KEM-768+X25519 hybrid key or
+// an OpenSSH private key (RSA or ECDSA). PKCS#8 keys encrypted with PBES2,
+// legacy encrypted PEM (Proc-Type: 4,ENCRYPTED) and encrypted OpenSSH keys are
+// decrypted with the passphrase, ErrPassphraseRequired is returned if it is
+// nil. RSA keys are returned as *rsa.PrivateKey, other keys as an
+// *AgreementKey.
+func ParsePrivateKeyWithPassphrase(keyPem []byte, passphrase PassphraseFunc) (crypto.Decrypter, error) {
+	block, _ := pem.Decode(keyPem)
+	if block == nil {
+		return nil, errors.New("failed to decode PEM block containing private key")
+	}
+
+	der := block.Bytes
+	switch {
+	case block.Type == envelope.HybridPrivateKeyPEMType:
+		key, err := envelope.ParseHybridPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewAgreementKey(key)
+	case block.Type == "OPENSSH PRIVATE KEY":
+		return parseOpenSSHPrivateKey(keyPem, passphrase)
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		pass, err := getPassphrase(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if der, err = decryptPKCS8(block.Bytes, pass); err != nil {
+			return nil, err
+		}
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, ErrIncorrectPassphrase
+		}
+		return privateKeyDecrypter(key)
+	case x509.IsEncryptedPEMBlock(block):
+		pass, err := getPassphrase(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if der, err = x509.DecryptPEMBlock(block, pass); err != nil {
+			if err == x509.IncorrectPasswordError {
+				return nil, ErrIncorrectPassphrase
+			}
+			return nil, err
+		}
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return NewAgreementKey(key)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return privateKeyDecrypter(key)
+}
+
+// parseOpenSSHPrivateKey decodes an OpenSSH private key, decrypting it with
+// the passphrase if it is encrypted
+func parseOpenSSHPrivateKey(keyPem []byte, passphrase PassphraseFunc) (crypto.Decrypter, error) {
+	key, err := ssh.ParseRawPrivateKey(keyPem)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		pass, passErr := getPassphrase(passphrase)
+		if passErr != nil {
+			return nil, passErr
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(keyPem, pass)
+		if err == x509.IncorrectPasswordError {
+			return nil, ErrIncorrectPassphrase
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return privateKeyDecrypter(key)
+}
+
+// privateKeyDecrypter returns RSA keys as they are, and adapts key agreement
+// keys
+func privateKeyDecrypter(key crypto.PrivateKey) (crypto.Decrypter, error) {
+	if _, ok := envelope.IsRSADecrypter(key); ok {
+		return key.(crypto.Decrypter), nil
+	}
+	agreementKey, err := NewAgreementKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return agreementKey, nil
+}
+
+// getPassphrase calls the PassphraseFunc, if there is one
+func getPassphrase(passphrase PassphraseFunc) ([]byte, error) {
+	if passphrase == nil {
+		return nil, ErrPassphraseRequired
+	}
+	return passphrase()
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+	"golang.org/x/term"
+)
+
+// PassphraseEnv is the environment variable holding the passphrase of an
+// encrypted private key
+const PassphraseEnv = "E2E_PASSPHRASE"
+
+// KeySource is where a command line tool finds the recipient's private key:
+// a key file, a PKCS#11 token, or an external command
+type KeySource struct {
+	PrivateKeyFile string
+	PKCS11URI      string
+	Command        string
+	PublicKeyFile  string
+	PassphraseFile string
+}
+
+// RegisterFlags adds the -privkey, -pkcs11, -command, -pubkey and
+// -passphrase-file flags
+func (ks *KeySource) RegisterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&ks.PrivateKeyFile, "privkey", "", "private key file")
+	flags.StringVar(&ks.PKCS11URI, "pkcs11", "", "pkcs11: uri of an RSA private key in a PKCS#11 token, instead of -privkey (needs a build with -tags pkcs11)")
+	flags.StringVar(&ks.Command, "command", "", "external command to RSA-OAEP decrypt with, instead of -privkey (needs -pubkey)")
+	flags.StringVar(&ks.PublicKeyFile, "pubkey", "", "RSA public key file of the -command key")
+	flags.StringVar(&ks.PassphraseFile, "passphrase-file", "", "file holding the passphrase of an encrypted private key, otherwise it is read from $"+PassphraseEnv+" or prompted for")
+}
+
+// Passphrase returns the passphrase of an encrypted private key from the
+// passphrase file or the environment, or prompts for it on the terminal (as
+// stdin is usually the payload)
+func (ks *KeySource) Passphrase() ([]byte, error) {
+	if ks.PassphraseFile != "" {
+		passphrase, err := ioutil.ReadFile(ks.PassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(passphrase, "\r\n"), nil
+	}
+	if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+		return []byte(passphrase), nil
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, ErrPassphraseRequired
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, "Private key passphrase: ")
+	passphrase, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return passphrase, err
+}
+
+// NewPassphrase returns the passphrase to encrypt a new private key with,
+// from the passphrase file or the environment, or prompts for it twice on
+// the terminal
+func (ks *KeySource) NewPassphrase() ([]byte, error) {
+	if _, ok := os.LookupEnv(PassphraseEnv); ok || ks.PassphraseFile != "" {
+		return ks.Passphrase()
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, ErrPassphraseRequired
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, "New private key passphrase: ")
+	passphrase, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprint(tty, "Repeat passphrase: ")
+	repeated, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, repeated) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// Key loads the private key, a *PKCS11Key must be closed when done with
+func (ks *KeySource) Key() (crypto.Decrypter, error) {
+	switch {
+	case ks.PKCS11URI != "":
+		return OpenPKCS11(ks.PKCS11URI)
+	case ks.Command != "":
+		if ks.PublicKeyFile == "" {
+			return nil, errors.New("-command needs -pubkey")
+		}
+		pubPem, err := ioutil.ReadFile(ks.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := ParseRSAPublicKey(pubPem)
+		if err != nil {
+			return nil, err
+		}
+		return &CommandKey{Command: ks.Command, PublicKey: pub}, nil
+	}
+	keyPem, err := ks.privateKeyData()
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKeyWithPassphrase(keyPem, ks.Passphrase)
+}
+
+func (ks *KeySource) privateKeyData() ([]byte, error) {
+	if ks.PrivateKeyFile == "" {
+		return nil, errors.New("no private key given, use -privkey, -pkcs11 or -command")
+	}
+	return ioutil.ReadFile(ks.PrivateKeyFile)
+}
+
+// NewReaderFromSource is NewReader with the key from a KeySource. Age files
+// are also decrypted, with the age identities or SSH private key in the key
+// file, and PGP messages with the unprotected ASCII armoured OpenPGP private
+// key in the key file (their Headers carry just the Format, and the PayloadID
+// of leased PGP messages). Age files and PGP messages cannot carry the
+// plugin's signature, so a Client with a SigningKey refuses them with
+// envelope.ErrUnsigned.
+func (c *Client) NewReaderFromSource(r io.Reader, ks *KeySource) (io.Reader, Headers, error) {
+	input := bufio.NewReader(r)
+	format := DetectFormat(input)
+	headers := Headers{Format: format}
+	if c.SigningKey != "" && (format == FormatAge || format == FormatAgeArmour || format == FormatPGP) {
+		return nil, headers, envelope.ErrUnsigned
+	}
+	switch format {
+	case FormatAge, FormatAgeArmour:
+		keyData, err := ks.privateKeyData()
+		if err != nil {
+			return nil, headers, err
+		}
+		identities, err := envelope.ParseAgeIdentities(keyData, ks.Passphrase)
+		if err != nil {
+			return nil, headers, err
+		}
+		plaintext, err := envelope.DecryptAge(input, format == FormatAgeArmour, identities...)
+		return plaintext, headers, err
+	case FormatPGP:
+		keyData, err := ks.privateKeyData()
+		if err != nil {
+			return nil, headers, err
+		}
+		plaintext, payloadID, err := envelope.DecryptOpenPGP(input, keyData, c.maxSize())
+		if err != nil {
+			return nil, headers, err
+		}
+
+		// the literal data's file name carries the payload id of leased
+		// payloads
+		headers.PayloadID = payloadID
+		if err := c.CheckRevoked(payloadID); err != nil {
+			return nil, headers, err
+		}
+		return bytes.NewReader(plaintext), headers, nil
+	}
+
+	key, err := ks.Key()
+	if err != nil {
+		return nil, headers, err
+	}
+	// the key is only needed to decapsulate the content key
+	if closer, ok := key.(io.Closer); ok {
+		defer closer.Close()
+	}
+	return c.NewReader(input, key)
+}
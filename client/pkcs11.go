@@ -0,0 +1,156 @@
+//go:build pkcs11
+
+package client
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS11Key is an RSA private key in a PKCS#11 token, decrypting with the
+// token's CKM_RSA_PKCS_OAEP mechanism so the key never leaves the token
+type PKCS11Key struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	object  pkcs11.ObjectHandle
+	public  *rsa.PublicKey
+	// sessions are not safe for concurrent use
+	mu sync.Mutex
+}
+
+// OpenPKCS11 loads the PKCS#11 module, logs in to the token and finds the
+// RSA private key identified by a pkcs11: URI. The key must be closed when
+// done with.
+func OpenPKCS11(uri string) (*PKCS11Key, error) {
+	u, err := ParsePKCS11URI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := pkcs11.New(u.ModulePath)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load pkcs11 module %s", u.ModulePath)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, err
+	}
+	key := &PKCS11Key{ctx: ctx}
+	if err := key.open(u); err != nil {
+		key.Close()
+		return nil, err
+	}
+	return key, nil
+}
+
+// open opens a session on the URI's token and finds its private key
+func (key *PKCS11Key) open(u *PKCS11URI) error {
+	slots, err := key.ctx.GetSlotList(true)
+	if err != nil {
+		return err
+	}
+	slot, found := uint(0), false
+	for _, s := range slots {
+		info, err := key.ctx.GetTokenInfo(s)
+		if err != nil {
+			return err
+		}
+		if (u.Token == "" || info.Label == u.Token) && (u.Serial == "" || info.SerialNumber == u.Serial) {
+			slot, found = s, true
+			break
+		}
+	}
+	if !found {
+		return errors.New("no matching pkcs11 token found")
+	}
+
+	key.session, err = key.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return err
+	}
+	if u.PIN != "" {
+		if err := key.ctx.Login(key.session, pkcs11.CKU_USER, u.PIN); err != nil {
+			return err
+		}
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+	}
+	if u.Object != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, u.Object))
+	}
+	if u.ID != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, u.ID))
+	}
+	if err := key.ctx.FindObjectsInit(key.session, template); err != nil {
+		return err
+	}
+	objects, _, err := key.ctx.FindObjects(key.session, 2)
+	if finalErr := key.ctx.FindObjectsFinal(key.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return err
+	}
+	if len(objects) != 1 {
+		return fmt.Errorf("pkcs11 uri matches %d RSA private keys, not one", len(objects))
+	}
+	key.object = objects[0]
+
+	// the modulus and public exponent of an RSA private key are readable
+	attributes, err := key.ctx.GetAttributeValue(key.session, key.object, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return err
+	}
+	key.public = &rsa.PublicKey{
+		N: new(big.Int).SetBytes(attributes[0].Value),
+		E: int(new(big.Int).SetBytes(attributes[1].Value).Int64()),
+	}
+	return nil
+}
+
+// Public returns the RSA public key
+func (key *PKCS11Key) Public() crypto.PublicKey {
+	return key.public
+}
+
+// Decrypt RSA-OAEP (SHA-256) decrypts msg in the token, opts must be
+// *rsa.OAEPOptions
+func (key *PKCS11Key) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	oaep, ok := opts.(*rsa.OAEPOptions)
+	if !ok || oaep.Hash != crypto.SHA256 {
+		return nil, errors.New("pkcs11 keys only decrypt RSA-OAEP with SHA-256")
+	}
+	params := pkcs11.NewOAEPParams(pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, pkcs11.CKZ_DATA_SPECIFIED, oaep.Label)
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, params)}
+
+	key.mu.Lock()
+	defer key.mu.Unlock()
+	if err := key.ctx.DecryptInit(key.session, mechanism, key.object); err != nil {
+		return nil, err
+	}
+	return key.ctx.Decrypt(key.session, msg)
+}
+
+// Close logs out of the token and unloads the module
+func (key *PKCS11Key) Close() error {
+	if key.session != 0 {
+		key.ctx.Logout(key.session)
+		key.ctx.CloseSession(key.session)
+	}
+	err := key.ctx.Finalize()
+	key.ctx.Destroy()
+	return err
+}
@@ -0,0 +1,40 @@
+//go:build !pkcs11
+
+package client
+
+import (
+	"crypto"
+	"errors"
+	"io"
+)
+
+// errNoPKCS11 is returned when built without the pkcs11 build tag, PKCS#11
+// support needs cgo
+var errNoPKCS11 = errors.New("built without pkcs11 support (go build -tags pkcs11)")
+
+// PKCS11Key is an RSA private key in a PKCS#11 token, this build does not
+// support PKCS#11
+type PKCS11Key struct{}
+
+// OpenPKCS11 fails, this build does not support PKCS#11
+func OpenPKCS11(uri string) (*PKCS11Key, error) {
+	if _, err := ParsePKCS11URI(uri); err != nil {
+		return nil, err
+	}
+	return nil, errNoPKCS11
+}
+
+// Public returns nil
+func (key *PKCS11Key) Public() crypto.PublicKey {
+	return nil
+}
+
+// Decrypt fails
+func (key *PKCS11Key) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return nil, errNoPKCS11
+}
+
+// Close does nothing
+func (key *PKCS11Key) Close() error {
+	return nil
+}
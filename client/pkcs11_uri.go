@@ -0,0 +1,112 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// PKCS11URI identifies an RSA private key in a PKCS#11 token, parsed from an
+// RFC 7512 URI, e.g.
+//
+//	pkcs11:token=e2e;object=recipient?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234
+type PKCS11URI struct {
+	// ModulePath is the PKCS#11 module (shared library) to load
+	ModulePath string
+	// Token and Serial select the token by label and serial number
+	Token  string
+	Serial string
+	// Object and ID select the private key by label and id
+	Object string
+	ID     []byte
+	// PIN is the user PIN, from pin-value or read from a pin-source file
+	PIN string
+}
+
+// ParsePKCS11URI parses a pkcs11: URI. The token, serial, object and id path
+// attributes and the module-path, pin-value and pin-source query attributes
+// are supported, module-path is required.
+func ParsePKCS11URI(uri string) (*PKCS11URI, error) {
+	if !strings.HasPrefix(uri, "pkcs11:") {
+		return nil, errors.New("pkcs11 uri must start with pkcs11:")
+	}
+	path := strings.TrimPrefix(uri, "pkcs11:")
+	query := ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+
+	key := &PKCS11URI{}
+	if err := pkcs11Attributes(path, ";", func(name string, value string) error {
+		switch name {
+		case "token":
+			key.Token = value
+		case "serial":
+			key.Serial = value
+		case "object":
+			key.Object = value
+		case "id":
+			key.ID = []byte(value)
+		case "type":
+			if value != "private" {
+				return fmt.Errorf("pkcs11 uri type %q is not a private key", value)
+			}
+		default:
+			return fmt.Errorf("unsupported pkcs11 uri attribute %q", name)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := pkcs11Attributes(query, "&", func(name string, value string) error {
+		switch name {
+		case "module-path":
+			key.ModulePath = value
+		case "pin-value":
+			key.PIN = value
+		case "pin-source":
+			pin, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+			if err != nil {
+				return err
+			}
+			key.PIN = strings.TrimRight(string(pin), "\r\n")
+		default:
+			return fmt.Errorf("unsupported pkcs11 uri query attribute %q", name)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if key.ModulePath == "" {
+		return nil, errors.New("pkcs11 uri has no module-path")
+	}
+	if key.Object == "" && key.ID == nil {
+		return nil, errors.New("pkcs11 uri has no object or id")
+	}
+	return key, nil
+}
+
+// pkcs11Attributes splits and percent decodes name=value attributes
+func pkcs11Attributes(s string, sep string, set func(name string, value string) error) error {
+	if s == "" {
+		return nil
+	}
+	for _, attribute := range strings.Split(s, sep) {
+		parts := strings.SplitN(attribute, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid pkcs11 uri attribute %q", attribute)
+		}
+		value, err := url.PathUnescape(parts[1])
+		if err != nil {
+			return err
+		}
+		if err := set(parts[0], value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
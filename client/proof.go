@@ -0,0 +1,59 @@
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+// CanSignChallenge reports whether SignChallenge can prove possession of a
+// key of keyType (from envelope.ParsePublicKey), other keys decrypt the
+// challenge with DecryptChallenge instead
+func CanSignChallenge(keyType string) bool {
+	return keyType == "rsa" || keyType == "P-256" || keyType == "P-384"
+}
+
+// SignChallenge signs an enrolement challenge with an RSA (PKCS#1 v1.5) or
+// ECDSA key, over SHA-256, as proof of possession of the key being enroled.
+// X25519 and ML-KEM-768+X25519 keys cannot sign, and return
+// envelope.ErrCannotSign.
+func SignChallenge(key crypto.Decrypter, challenge string) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if agreementKey, isAgreement := key.(*AgreementKey); isAgreement {
+		signer, ok = agreementKey.Key.(*ecdsa.PrivateKey)
+	}
+	if !ok {
+		return "", envelope.ErrCannotSign
+	}
+
+	digest := sha256.Sum256([]byte(challenge))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// DecryptChallenge decrypts an enrolement challenge the plugin encrypted to
+// the key being enroled (the encrypted_challenge from
+// enrole/<name>/challenge, given the pubkey), as proof of possession of keys
+// that cannot sign. The challenge is a payload in the key's default format,
+// so age and OpenPGP keys decrypt it too.
+func DecryptChallenge(encrypted string, ks *KeySource) (string, error) {
+	c := &Client{}
+	r, _, err := c.NewReaderFromSource(strings.NewReader(encrypted), ks)
+	if err != nil {
+		return "", err
+	}
+	challenge, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(challenge), nil
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// RevokedError is returned for a leased payload whose lease has been revoked
+// or has expired
+type RevokedError struct {
+	PayloadID string
+	RevokedAt string
+}
+
+func (e *RevokedError) Error() string {
+	return fmt.Sprintf("payload %s was revoked at %s", e.PayloadID, e.RevokedAt)
+}
+
+// CheckRevoked returns a *RevokedError if a leased payload's id is on the
+// revocation list. Payloads without an id are not leased, and nothing is
+// checked if the Client has no Revocations.
+func (c *Client) CheckRevoked(payloadID string) error {
+	if c.Revocations == "" || payloadID == "" {
+		return nil
+	}
+	revoked, err := LoadRevocations(c.Revocations)
+	if err != nil {
+		return err
+	}
+	if when, ok := revoked[payloadID]; ok {
+		return &RevokedError{PayloadID: payloadID, RevokedAt: when}
+	}
+	return nil
+}
+
+// LoadRevocations reads the published revocation list from a file or url,
+// either as the vault response or just its data
+func LoadRevocations(source string) (map[string]string, error) {
+	body, err := readSource(source, "revocation list")
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Revoked map[string]string `json:"revoked"`
+		Data    struct {
+			Revoked map[string]string `json:"revoked"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+	if list.Data.Revoked != nil {
+		return list.Data.Revoked, nil
+	}
+	if list.Revoked == nil {
+		return nil, errors.New("revocation list has no revoked field")
+	}
+	return list.Revoked, nil
+}
+
+// readSource reads a file, or fetches an http(s) url, published by the plugin
+func readSource(source string, what string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return ioutil.ReadFile(source)
+	}
+	resp, err := http.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", what, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+// ExpiredError is returned for a payload past its EXPIRES time
+type ExpiredError struct {
+	Expires string
+}
+
+func (e *ExpiredError) Error() string {
+	return fmt.Sprintf("payload expired at %s", e.Expires)
+}
+
+// checkExpires returns an *ExpiredError if the payload has expired
+func checkExpires(h *envelope.Headers) error {
+	if h.Expires == "" {
+		return nil
+	}
+	expires, err := time.Parse(time.RFC3339, h.Expires)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(expires) {
+		return &ExpiredError{Expires: h.Expires}
+	}
+	return nil
+}
+
+// verifyKey returns the Client's signing key, or nil if payloads are not
+// verified
+func (c *Client) verifyKey() (ed25519.PublicKey, string, error) {
+	if c.SigningKey == "" {
+		return nil, "", nil
+	}
+	return LoadSigningKey(c.SigningKey)
+}
+
+// LoadSigningKey reads the plugin's published signing key from a file or url,
+// either as the vault response, just its data, or the PEM PUBLIC KEY,
+// returning it and its key id
+func LoadSigningKey(source string) (ed25519.PublicKey, string, error) {
+	body, err := readSource(source, "signing key")
+	if err != nil {
+		return nil, "", err
+	}
+	pubPem := string(body)
+	if !strings.HasPrefix(strings.TrimSpace(pubPem), "-----BEGIN") {
+		var published struct {
+			PublicKey string `json:"public_key"`
+			Data      struct {
+				PublicKey string `json:"public_key"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &published); err != nil {
+			return nil, "", err
+		}
+		pubPem = published.PublicKey
+		if published.Data.PublicKey != "" {
+			pubPem = published.Data.PublicKey
+		}
+	}
+	return envelope.ParseVerifyingKey(pubPem)
+}
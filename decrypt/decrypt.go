@@ -1,105 +1,59 @@
 package main
 
 import (
-	"bufio"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
-	"crypto/x509"
-	"encoding/base64"
-	"encoding/binary"
-	"encoding/pem"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
-	"strings"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/client"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
 )
 
 func main() {
-	privkeyFile := flag.String("privkey", "", "private key file")
+	keySource := &client.KeySource{}
+	keySource.RegisterFlags(flag.CommandLine)
+	revocations := flag.String("revocations", "", "revocation list file or url (e.g. https://vault:8200/v1/e2e/revocations) to check leased payloads against")
+	signingKey := flag.String("signing-key", "", "plugin signing key file or url (e.g. https://vault:8200/v1/e2e/signingkey) payloads must be signed by")
+	maxSize := flag.Int64("maxsize", envelope.DefaultMaxDecompressedSize, "maximum size in bytes of a decompressed payload")
+	inspect := flag.Bool("inspect", false, "print the payload's headers and recipients as JSON, without decrypting it")
 	flag.Parse()
+	c := &client.Client{MaxSize: *maxSize, Revocations: *revocations, SigningKey: *signingKey}
 
-	keyPem, err := ioutil.ReadFile(*privkeyFile)
-	if err != nil {
-		panic(err)
-	}
-	keyPKCS1, _ := pem.Decode(keyPem)
-
-	key, err := x509.ParsePKCS1PrivateKey(keyPKCS1.Bytes)
-	if err != nil {
-		panic(err)
-	}
-
-	// read payload from stdin
-	scanner := bufio.NewScanner(os.Stdin)
-	stage := "looking"
-
-	// break out sections
-	payloadB64 := ""
-	// payloadVersion := ""
-	for scanner.Scan() {
-		line := scanner.Text()
-		switch stage {
-		case "looking":
-			if strings.HasPrefix(line, "-----BEGIN E2E ENCRYPTED PAYLOAD-----") {
-				stage = "started"
-			}
-		case "started":
-			// can catch headers here, until empty line
-
-			if line == "" {
-				stage = "rsa"
-			}
-		case "rsa":
-			if !strings.HasPrefix(line, "-----") {
-				payloadB64 += strings.Trim(line, "")
-			} else if strings.HasPrefix(line, "-----END E2E ENCRYPTED PAYLOAD-----") {
-				break
-			}
-		default:
-			panic("invalid state at line: " + line)
+	// inspection needs no key
+	if *inspect {
+		inspection, err := client.Inspect(os.Stdin)
+		if err != nil {
+			panic(err)
+		}
+		out, err := json.MarshalIndent(inspection, "", "  ")
+		if err != nil {
+			panic(err)
 		}
+		fmt.Println(string(out))
+		return
 	}
 
-	payload, err := base64.StdEncoding.DecodeString(payloadB64)
+	// print decrypted payload, STREAM payloads are decrypted and printed a
+	// chunk at a time
+	plaintext, _, err := c.NewReaderFromSource(os.Stdin, keySource)
 	if err != nil {
+		exitRevoked(err)
 		panic(err)
 	}
-
-	rsaLen := binary.LittleEndian.Uint16(payload[:2])
-	rsaPayload := payload[2 : 2+rsaLen]
-	aesPayload := payload[2+rsaLen:]
-
-	// decrypt RSA part (1)
-	label := []byte("Vault E2E Payload")
-	rng := rand.Reader
-	rsaPlaintext, err := rsa.DecryptOAEP(sha256.New(), rng, key, rsaPayload, label)
-	if err != nil {
+	if _, err := io.Copy(os.Stdout, plaintext); err != nil {
 		panic(err)
 	}
+	fmt.Println()
+}
 
-	// Extract key and nonce
-	aesKey := rsaPlaintext[:32]
-	aesNonce := rsaPlaintext[32:]
-
-	// use from from RSA part (1) to decrypt AES part (2)
-	block, err := aes.NewCipher(aesKey)
-	if err != nil {
-		panic(err.Error())
-	}
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
-		panic(err.Error())
-	}
-
-	plaintext, err := aesgcm.Open(nil, aesNonce, aesPayload, nil)
-	if err != nil {
-		panic(err.Error())
+// exitRevoked exits with status 2 if err is a revoked leased payload, or an
+// expired payload
+func exitRevoked(err error) {
+	switch err.(type) {
+	case *client.RevokedError, *client.ExpiredError:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-
-	// print decrypted payload
-	fmt.Println(string(plaintext))
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/client"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+// enrol enrols a public key with the plugin as NAME, and prints the enrolement
+func enrol(args []string) error {
+	flags := flag.NewFlagSet("enrol", flag.ExitOnError)
+	mount := flags.String("mount", "e2e", "path the plugin is mounted at")
+	pubKeyFile := flags.String("pubkey", "", "public key file to enrol")
+	csrFile := flags.String("csr", "", "certificate request signed by the key, with NAME as its CN or a DNS SAN, as proof of possession (-pubkey may then be omitted)")
+	keySource := &client.KeySource{}
+	flags.StringVar(&keySource.PrivateKeyFile, "privkey", "", "private key file to sign the enrolement challenge with (RSA or ECDSA), or decrypt it with (other keys), as proof of possession")
+	flags.StringVar(&keySource.PassphraseFile, "passphrase-file", "", "file holding the passphrase of an encrypted -privkey, otherwise it is read from $"+client.PassphraseEnv+" or prompted for")
+	cipher := flags.String("cipher", "", "content cipher of the recipient's payloads (default AES-256-GCM)")
+	compression := flags.String("compression", "", "compression of the recipient's payloads (default none)")
+	flags.Parse(args)
+	if flags.NArg() != 1 || (*pubKeyFile == "" && *csrFile == "") {
+		return errors.New("usage: e2ectl enrol -pubkey FILE [-privkey FILE] [-csr FILE] [-cipher ID] [-compression ID] NAME")
+	}
+	name := flags.Arg(0)
+
+	body := map[string]interface{}{
+		"name": name,
+	}
+	if *pubKeyFile != "" {
+		pubKey, err := ioutil.ReadFile(*pubKeyFile)
+		if err != nil {
+			return err
+		}
+		body["pubkey"] = string(pubKey)
+	}
+	if *csrFile != "" {
+		csr, err := ioutil.ReadFile(*csrFile)
+		if err != nil {
+			return err
+		}
+		body["csr"] = string(csr)
+	}
+	if *cipher != "" {
+		body["cipher"] = *cipher
+	}
+	if *compression != "" {
+		body["compression"] = *compression
+	}
+	vault, err := newVaultClient(*mount)
+	if err != nil {
+		return err
+	}
+
+	// prove possession of the key by signing a fresh challenge, or by
+	// decrypting one encrypted to it for keys that cannot sign
+	if keySource.PrivateKeyFile != "" {
+		if err := proveChallenge(vault, name, keySource, body); err != nil {
+			return err
+		}
+	}
+
+	if _, err := vault.request("POST", "enrole/"+name, body); err != nil {
+		return err
+	}
+
+	data, err := vault.request("GET", "enrole/"+name, nil)
+	if err != nil {
+		return err
+	}
+	return printJSON(data["enrole"])
+}
+
+// proveChallenge fetches a challenge for the enrolement and adds its
+// signature, or its decrypted response, to the enrolement body. Keys given
+// only by a csr can always sign.
+func proveChallenge(vault *vaultClient, name string, keySource *client.KeySource, body map[string]interface{}) error {
+	if pubKey, ok := body["pubkey"].(string); ok {
+		_, keyType, _, err := envelope.ParsePublicKey(pubKey)
+		if err != nil {
+			return err
+		}
+		if !client.CanSignChallenge(keyType) {
+			data, err := vault.request("POST", "enrole/"+name+"/challenge", map[string]interface{}{
+				"pubkey": pubKey,
+			})
+			if err != nil {
+				return err
+			}
+			encrypted, _ := data["encrypted_challenge"].(string)
+			response, err := client.DecryptChallenge(encrypted, keySource)
+			if err != nil {
+				return err
+			}
+			body["response"] = response
+			return nil
+		}
+	}
+
+	key, err := keySource.Key()
+	if err != nil {
+		return err
+	}
+	data, err := vault.request("POST", "enrole/"+name+"/challenge", nil)
+	if err != nil {
+		return err
+	}
+	challenge, _ := data["challenge"].(string)
+	signature, err := client.SignChallenge(key, challenge)
+	if err != nil {
+		return err
+	}
+	body["signature"] = signature
+	return nil
+}
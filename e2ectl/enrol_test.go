@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+func TestEnrolSignsChallenge(t *testing.T) {
+	const challenge = "vault-e2e-enrole:myhost:bm9uY2U"
+	fake := newFakeVault(t, map[string]fakeResponse{
+		"POST /v1/e2e/enrole/myhost/challenge": {http.StatusOK, `{"data": {"challenge": "` + challenge + `"}}`},
+		"POST /v1/e2e/enrole/myhost":           {http.StatusNoContent, ``},
+		"GET /v1/e2e/enrole/myhost":            {http.StatusOK, `{"data": {"enrole": {"name": "myhost"}}}`},
+	})
+
+	for _, keyType := range []string{"rsa", "p256"} {
+		privateFile, publicFile := writeKeyPair(t, keyType)
+		if _, err := withStdin(t, "", func() error {
+			return enrol([]string{"-pubkey", publicFile, "-privkey", privateFile, "-compression", "GZIP", "myhost"})
+		}); err != nil {
+			t.Fatalf("%s: %s", keyType, err)
+		}
+
+		req := fake.request(t, "POST /v1/e2e/enrole/myhost")
+		pubPem, err := ioutil.ReadFile(publicFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.body["pubkey"] != string(pubPem) || req.body["name"] != "myhost" || req.body["compression"] != "GZIP" {
+			t.Errorf("%s: enrolement %v", keyType, req.body)
+		}
+		signature, _ := req.body["signature"].(string)
+		pub, parsedType, der, err := envelope.ParsePublicKey(string(pubPem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := envelope.VerifyProof(pub, parsedType, der, []byte(challenge), signature); err != nil {
+			t.Errorf("%s: challenge signature: %s", keyType, err)
+		}
+	}
+}
+
+func TestEnrolDecryptsChallenge(t *testing.T) {
+	const challenge = "vault-e2e-enrole:myhost:bm9uY2U"
+	for _, keyType := range []string{"x25519", "mlkem768x25519"} {
+		privateFile, publicFile := writeKeyPair(t, keyType)
+		pubPem, err := ioutil.ReadFile(publicFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, parsedType, _, err := envelope.ParsePublicKey(string(pubPem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted, err := envelope.Encrypt(pub, parsedType, []byte(challenge), &envelope.EncryptOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		encryptedJSON, err := json.Marshal(encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fake := newFakeVault(t, map[string]fakeResponse{
+			"POST /v1/e2e/enrole/myhost/challenge": {http.StatusOK, `{"data": {"encrypted_challenge": ` + string(encryptedJSON) + `}}`},
+			"POST /v1/e2e/enrole/myhost":           {http.StatusNoContent, ``},
+			"GET /v1/e2e/enrole/myhost":            {http.StatusOK, `{"data": {"enrole": {"name": "myhost"}}}`},
+		})
+
+		if _, err := withStdin(t, "", func() error {
+			return enrol([]string{"-pubkey", publicFile, "-privkey", privateFile, "myhost"})
+		}); err != nil {
+			t.Fatalf("%s: %s", keyType, err)
+		}
+		if req := fake.request(t, "POST /v1/e2e/enrole/myhost/challenge"); req.body["pubkey"] != string(pubPem) {
+			t.Errorf("%s: challenge requested without the pubkey: %v", keyType, req.body)
+		}
+		req := fake.request(t, "POST /v1/e2e/enrole/myhost")
+		if req.body["response"] != challenge {
+			t.Errorf("%s: enrolement response %v, want the decrypted challenge", keyType, req.body["response"])
+		}
+		if _, ok := req.body["signature"]; ok {
+			t.Errorf("%s: enrolement sent a signature", keyType)
+		}
+	}
+}
+
+func TestEnrolWithoutProof(t *testing.T) {
+	fake := newFakeVault(t, map[string]fakeResponse{
+		"POST /v1/e2e/enrole/myhost": {http.StatusNoContent, ``},
+		"GET /v1/e2e/enrole/myhost":  {http.StatusOK, `{"data": {"enrole": {"name": "myhost"}}}`},
+	})
+	_, publicFile := writeKeyPair(t, "x25519")
+	if _, err := withStdin(t, "", func() error {
+		return enrol([]string{"-pubkey", publicFile, "myhost"})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.request(t, "POST /v1/e2e/enrole/myhost").body["signature"]; ok {
+		t.Error("enrolement without -privkey sent a signature")
+	}
+}
+
+func TestEnrolErrors(t *testing.T) {
+	newFakeVault(t, map[string]fakeResponse{
+		"POST /v1/e2e/enrole/denied/challenge": {http.StatusForbidden, `{"errors": ["permission denied"]}`},
+		"POST /v1/e2e/enrole/invalid":          {http.StatusBadRequest, `{"errors": ["proof of possession is required"]}`},
+	})
+	privateFile, publicFile := writeKeyPair(t, "p256")
+
+	tests := [][]string{
+		{"myhost"},
+		{"-pubkey", publicFile},
+		{"-pubkey", publicFile, "-privkey", privateFile, "denied"},
+		{"-pubkey", publicFile, "invalid"},
+	}
+	for _, args := range tests {
+		if _, err := withStdin(t, "", func() error { return enrol(args) }); err == nil {
+			t.Errorf("enrol %v succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/client"
+)
+
+// keygen generates a key pair to <prefix>_<type>.pem and <prefix>_<type>_pub.pem,
+// with the same options as genrsapair, and prints the files written
+func keygen(args []string) error {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	keyFiles := &client.KeyFiles{}
+	keyFiles.RegisterFlags(flags, "e2e_key")
+	flags.Parse(args)
+
+	filenames, err := keyFiles.Generate()
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		fmt.Println(filename)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// kv puts or gets a kv secret. put takes key=value pairs, or a JSON object on
+// stdin, get prints the secret's data as JSON or just one -field.
+func kv(args []string) error {
+	if len(args) < 1 || (args[0] != "put" && args[0] != "get") {
+		return errors.New("usage: e2ectl kv put PATH [key=value...] | e2ectl kv get [-field NAME] PATH")
+	}
+	flags := flag.NewFlagSet("kv "+args[0], flag.ExitOnError)
+	mount := flags.String("mount", "e2e", "path the plugin is mounted at")
+	field := flags.String("field", "", "print only this field of the secret (get)")
+	flags.Parse(args[1:])
+	if flags.NArg() < 1 {
+		return errors.New("kv " + args[0] + " needs a secret PATH")
+	}
+	path := "kv/" + strings.TrimPrefix(flags.Arg(0), "kv/")
+
+	vault, err := newVaultClient(*mount)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "get" {
+		secret, err := vault.request("GET", path, nil)
+		if err != nil {
+			return err
+		}
+		if *field == "" {
+			return printJSON(secret)
+		}
+		value, ok := secret[*field]
+		if !ok {
+			return fmt.Errorf("no field %q in %s", *field, path)
+		}
+		if s, ok := value.(string); ok {
+			fmt.Println(s)
+			return nil
+		}
+		return printJSON(value)
+	}
+
+	secret := map[string]interface{}{}
+	if flags.NArg() == 1 {
+		if err := json.NewDecoder(os.Stdin).Decode(&secret); err != nil {
+			return fmt.Errorf("reading secret JSON from stdin: %s", err)
+		}
+	}
+	for _, pair := range flags.Args()[1:] {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%q is not key=value", pair)
+		}
+		secret[parts[0]] = parts[1]
+	}
+	_, err = vault.request("POST", path, map[string]interface{}{"data": secret})
+	return err
+}
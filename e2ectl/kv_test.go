@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestKVPutAndGet(t *testing.T) {
+	fake := newFakeVault(t, map[string]fakeResponse{
+		"POST /v1/e2e/kv/myapp/db": {http.StatusNoContent, ``},
+		"GET /v1/e2e/kv/myapp/db":  {http.StatusOK, `{"data": {"user": "app", "password": "secret", "port": 5432}}`},
+	})
+
+	if _, err := withStdin(t, "", func() error {
+		return kv([]string{"put", "myapp/db", "user=app", "password=se=cret"})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := fake.request(t, "POST /v1/e2e/kv/myapp/db").body["data"].(map[string]interface{})
+	if data["user"] != "app" || data["password"] != "se=cret" {
+		t.Errorf("put data %v", data)
+	}
+
+	if _, err := withStdin(t, `{"user": "json"}`, func() error {
+		return kv([]string{"put", "kv/myapp/db"})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = fake.request(t, "POST /v1/e2e/kv/myapp/db").body["data"].(map[string]interface{})
+	if data["user"] != "json" {
+		t.Errorf("put stdin data %v", data)
+	}
+
+	out, err := withStdin(t, "", func() error {
+		return kv([]string{"get", "-field", "password", "myapp/db"})
+	})
+	if err != nil || out != "secret\n" {
+		t.Errorf("get -field password: %q %v", out, err)
+	}
+	out, err = withStdin(t, "", func() error {
+		return kv([]string{"get", "myapp/db"})
+	})
+	if err != nil || !strings.Contains(out, `"port": 5432`) {
+		t.Errorf("get: %q %v", out, err)
+	}
+}
+
+func TestKVErrors(t *testing.T) {
+	newFakeVault(t, map[string]fakeResponse{
+		"GET /v1/e2e/kv/myapp/db":      {http.StatusOK, `{"data": {"user": "app"}}`},
+		"GET /v1/e2e/kv/denied":        {http.StatusForbidden, `{"errors": ["permission denied"]}`},
+		"POST /v1/e2e/kv/schema/check": {http.StatusBadRequest, `{"errors": ["secret does not match its schema"]}`},
+	})
+
+	tests := []struct {
+		args  []string
+		stdin string
+		err   string
+	}{
+		{[]string{"list"}, "", "usage"},
+		{[]string{"get"}, "", "needs a secret PATH"},
+		{[]string{"get", "-field", "password", "myapp/db"}, "", `no field "password"`},
+		{[]string{"get", "denied"}, "", "permission denied"},
+		{[]string{"put", "myapp/db", "novalue"}, "", "is not key=value"},
+		{[]string{"put", "myapp/db"}, "not json", "reading secret JSON"},
+		{[]string{"put", "schema/check", "a=b"}, "", "does not match its schema"},
+	}
+	for _, test := range tests {
+		_, err := withStdin(t, test.stdin, func() error { return kv(test.args) })
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("kv %v: error %v, want %q", test.args, err, test.err)
+		}
+	}
+}
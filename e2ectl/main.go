@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/client"
+)
+
+/*
+ * e2ectl - generate and enrol keys, put kv secrets, and encrypt, decrypt,
+ * inspect and verify payloads, e.g.:
+ *
+ *   e2ectl keygen -type p256 -prefix mykey
+ *   e2ectl enrol -pubkey mykey_p256_pub.pem myhost
+ *   e2ectl kv put myapp/db password=secret
+ *   e2ectl encrypt -pubkey mykey_p256_pub.pem < plain.json > payload.txt
+ *   e2ectl decrypt -privkey mykey_p256.pem < payload.txt
+ *
+ * The Vault subcommands use $VAULT_ADDR and $VAULT_TOKEN (or ~/.vault-token).
+ */
+
+const usage = `usage: e2ectl <command> [flags] [args]
+
+commands:
+  keygen   generate a recipient key pair
+  enrol    enrol a public key with the plugin
+  encrypt  encrypt a JSON payload from stdin to a local public key
+  decrypt  decrypt a payload from stdin
+  inspect  print a payload's headers and recipients, without decrypting it
+  verify   decrypt a payload from stdin and check the plugin signed it, without printing it
+  kv       put or get a kv secret (kv put PATH key=value..., kv get PATH)
+
+run "e2ectl <command> -h" for a command's flags
+`
+
+var commands = map[string]func(args []string) error{
+	"keygen":  keygen,
+	"enrol":   enrol,
+	"encrypt": encrypt,
+	"decrypt": decrypt,
+	"inspect": inspect,
+	"verify":  verify,
+	"kv":      kv,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "e2ectl: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(1)
+	}
+	if err := command(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "e2ectl:", err)
+		// revoked leased payloads and expired payloads exit with status 2,
+		// as with decrypt
+		switch err.(type) {
+		case *client.RevokedError, *client.ExpiredError:
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// printJSON prints v as indented JSON
+func printJSON(v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/client"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+// encrypt encrypts a JSON payload on stdin to a local public key, as the
+// plugin's payload endpoint would for the key's enrolement
+func encrypt(args []string) error {
+	flags := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	encrypter := &client.Encrypter{}
+	encrypter.RegisterFlags(flags)
+	flags.Parse(args)
+
+	plaintext, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	encrypted, err := encrypter.Encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(encrypted))
+	return nil
+}
+
+// payloadFlags registers the key source and decryption flags of decrypt and
+// verify
+func payloadFlags(name string, keySource *client.KeySource) (*flag.FlagSet, *client.Client) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	keySource.RegisterFlags(flags)
+	c := &client.Client{}
+	flags.StringVar(&c.Revocations, "revocations", "", "revocation list file or url (e.g. https://vault:8200/v1/e2e/revocations) to check leased payloads against")
+	flags.StringVar(&c.SigningKey, "signing-key", "", "plugin signing key file or url (e.g. https://vault:8200/v1/e2e/signingkey) payloads must be signed by")
+	flags.Int64Var(&c.MaxSize, "maxsize", envelope.DefaultMaxDecompressedSize, "maximum size in bytes of a decompressed payload")
+	return flags, c
+}
+
+// decrypt decrypts a payload on stdin and prints it
+func decrypt(args []string) error {
+	keySource := &client.KeySource{}
+	flags, c := payloadFlags("decrypt", keySource)
+	flags.Parse(args)
+
+	plaintext, _, err := c.NewReaderFromSource(os.Stdin, keySource)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(os.Stdout, plaintext); err != nil {
+		return err
+	}
+	fmt.Println()
+	return nil
+}
+
+// verify decrypts and authenticates a payload on stdin, checking it is signed
+// by the plugin and has not expired or been revoked, and prints its headers
+// rather than the plaintext. Without -signing-key the plugin's published
+// signing key is fetched from $VAULT_ADDR.
+func verify(args []string) error {
+	keySource := &client.KeySource{}
+	flags, c := payloadFlags("verify", keySource)
+	mount := flags.String("mount", "e2e", "path the plugin is mounted at, to fetch its signing key from")
+	flags.Parse(args)
+	if c.SigningKey == "" {
+		c.SigningKey = fmt.Sprintf("%s/v1/%s/signingkey", vaultAddr(), strings.Trim(*mount, "/"))
+	}
+
+	plaintext, headers, err := c.NewReaderFromSource(os.Stdin, keySource)
+	if err != nil {
+		return err
+	}
+	// STREAM chunks are authenticated as they are read
+	if _, err := io.Copy(ioutil.Discard, plaintext); err != nil {
+		return err
+	}
+	return printJSON(headers)
+}
+
+// inspect prints a payload's headers and recipients, without a key
+func inspect(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	flags.Parse(args)
+
+	inspection, err := client.Inspect(os.Stdin)
+	if err != nil {
+		return err
+	}
+	return printJSON(inspection)
+}
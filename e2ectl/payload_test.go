@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/client"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+func TestVerify(t *testing.T) {
+	signingKey, err := envelope.NewSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPem, err := signingKey.PublicKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := json.Marshal(map[string]interface{}{
+		"data": map[string]interface{}{"public_key": pubPem, "key_id": signingKey.ID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFakeVault(t, map[string]fakeResponse{
+		"GET /v1/e2e/signingkey": {http.StatusOK, string(published)},
+	})
+	otherKey, err := envelope.NewSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateFile, publicFile := writeKeyPair(t, "p256")
+	recipient, err := ioutil.ReadFile(publicFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt := func(opts *envelope.EncryptOptions) string {
+		payload, err := client.Encrypt(recipient, []byte(`{"a": "b"}`), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(payload)
+	}
+
+	out, err := withStdin(t, encrypt(&envelope.EncryptOptions{SigningKey: signingKey, Stream: true}), func() error {
+		return verify([]string{"-privkey", privateFile})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var headers client.Headers
+	if err := json.Unmarshal([]byte(out), &headers); err != nil || headers.SigningKey != signingKey.ID {
+		t.Errorf("verify printed %q %v", out, err)
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		check   func(error) bool
+	}{
+		{"unsigned", encrypt(&envelope.EncryptOptions{}), func(err error) bool { return err == envelope.ErrUnsigned }},
+		{"other signing key", encrypt(&envelope.EncryptOptions{SigningKey: otherKey}), func(err error) bool {
+			_, ok := err.(*envelope.SignatureError)
+			return ok
+		}},
+		{"expired", encrypt(&envelope.EncryptOptions{SigningKey: signingKey, Expires: time.Now().Add(-time.Minute)}), func(err error) bool {
+			_, ok := err.(*client.ExpiredError)
+			return ok
+		}},
+		{"compact jwe", encrypt(&envelope.EncryptOptions{Format: envelope.FormatJWE}), func(err error) bool { return err == envelope.ErrUnsigned }},
+	}
+	for _, test := range tests {
+		_, err := withStdin(t, test.payload, func() error {
+			return verify([]string{"-privkey", privateFile})
+		})
+		if !test.check(err) {
+			t.Errorf("%s: verify error %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultVaultAddr is used when $VAULT_ADDR is not set
+const defaultVaultAddr = "https://127.0.0.1:8200"
+
+// vaultClient calls the plugin's HTTP API
+type vaultClient struct {
+	addr  string
+	token string
+	mount string
+}
+
+// vaultAddr is $VAULT_ADDR, or defaultVaultAddr, without a trailing slash
+func vaultAddr() string {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		addr = defaultVaultAddr
+	}
+	return strings.TrimRight(addr, "/")
+}
+
+func newVaultClient(mount string) (*vaultClient, error) {
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		// the token saved by vault login
+		home, err := os.UserHomeDir()
+		if err == nil {
+			tokenBytes, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+			if err == nil {
+				token = strings.TrimSpace(string(tokenBytes))
+			}
+		}
+	}
+	if token == "" {
+		return nil, errors.New("no vault token, set VAULT_TOKEN or vault login")
+	}
+	return &vaultClient{
+		addr:  vaultAddr(),
+		token: token,
+		mount: strings.Trim(mount, "/"),
+	}, nil
+}
+
+// request calls path, relative to the plugin's mount, with body (if not nil)
+// as JSON, and returns the response's data, nil if there is none
+func (v *vaultClient) request(method string, path string, body interface{}) (map[string]interface{}, error) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	url := fmt.Sprintf("%s/v1/%s/%s", v.addr, v.mount, path)
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []string               `json:"errors"`
+	}
+	if len(bytes.TrimSpace(respBody)) > 0 {
+		if err := json.Unmarshal(respBody, &result); err != nil {
+			return nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+		}
+	}
+	if resp.StatusCode >= 400 {
+		if len(result.Errors) > 0 {
+			return nil, fmt.Errorf("%s %s: %s", method, url, strings.Join(result.Errors, ", "))
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return result.Data, nil
+}
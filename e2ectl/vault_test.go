@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/client"
+)
+
+// fakeResponse is a canned vault API response
+type fakeResponse struct {
+	status int
+	body   string
+}
+
+// fakeRequest is a request sent to a fakeVault
+type fakeRequest struct {
+	method string
+	path   string
+	token  string
+	body   map[string]interface{}
+}
+
+// fakeVault stands in for the vault HTTP API, answering "METHOD path" with
+// its canned response (404 otherwise) and recording the requests sent
+type fakeVault struct {
+	*httptest.Server
+	responses map[string]fakeResponse
+
+	lock     sync.Mutex
+	requests []fakeRequest
+}
+
+// newFakeVault starts a fakeVault, pointing $VAULT_ADDR and $VAULT_TOKEN at
+// it for the test
+func newFakeVault(t *testing.T, responses map[string]fakeResponse) *fakeVault {
+	fake := &fakeVault{responses: responses}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := fakeRequest{method: r.Method, path: r.URL.Path, token: r.Header.Get("X-Vault-Token")}
+		if err := json.NewDecoder(r.Body).Decode(&req.body); err != nil && err != io.EOF {
+			t.Errorf("%s %s: request body is not JSON: %s", r.Method, r.URL.Path, err)
+		}
+		fake.lock.Lock()
+		fake.requests = append(fake.requests, req)
+		fake.lock.Unlock()
+
+		resp, ok := fake.responses[r.Method+" "+r.URL.Path]
+		if !ok {
+			resp = fakeResponse{status: http.StatusNotFound, body: `{"errors":[]}`}
+		}
+		w.WriteHeader(resp.status)
+		io.WriteString(w, resp.body)
+	}))
+	t.Cleanup(fake.Close)
+	t.Setenv("VAULT_ADDR", fake.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+	return fake
+}
+
+// request returns the last request sent as "METHOD path"
+func (fake *fakeVault) request(t *testing.T, methodPath string) fakeRequest {
+	t.Helper()
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	for i := len(fake.requests) - 1; i >= 0; i-- {
+		if req := fake.requests[i]; req.method+" "+req.path == methodPath {
+			return req
+		}
+	}
+	t.Fatalf("no %s request was sent", methodPath)
+	return fakeRequest{}
+}
+
+// writeKeyPair generates a key pair of keyType into a temporary directory,
+// returning the private and public key file names
+func writeKeyPair(t *testing.T, keyType string) (string, string) {
+	t.Helper()
+	private, public, err := client.GenerateKey(keyType, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	privateFile := filepath.Join(dir, "key.pem")
+	publicFile := filepath.Join(dir, "key_pub.pem")
+	if err := ioutil.WriteFile(privateFile, pem.EncodeToMemory(private), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(publicFile, pem.EncodeToMemory(public), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return privateFile, publicFile
+}
+
+// withStdin runs f with input on os.Stdin, returning what it wrote to
+// os.Stdout
+func withStdin(t *testing.T, input string, f func() error) (string, error) {
+	t.Helper()
+	stdin, err := ioutil.TempFile(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(stdin, input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stdin.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savedStdin, savedStdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdin, w
+	output := make(chan string)
+	go func() {
+		out, _ := ioutil.ReadAll(r)
+		output <- string(out)
+	}()
+	err = f()
+	os.Stdin, os.Stdout = savedStdin, savedStdout
+	w.Close()
+	return <-output, err
+}
+
+func TestVaultClientRequest(t *testing.T) {
+	fake := newFakeVault(t, map[string]fakeResponse{
+		"GET /v1/e2e/ok":         {http.StatusOK, `{"data": {"key": "value"}}`},
+		"POST /v1/e2e/nocontent": {http.StatusNoContent, ``},
+		"POST /v1/e2e/invalid":   {http.StatusBadRequest, `{"errors": ["name is required", "bad cipher"]}`},
+		"GET /v1/e2e/denied":     {http.StatusForbidden, `{"errors": ["permission denied"]}`},
+		"GET /v1/e2e/broken":     {http.StatusInternalServerError, `<html>oops</html>`},
+		"GET /v1/e2e/empty":      {http.StatusServiceUnavailable, ``},
+	})
+	vault, err := newVaultClient("/e2e/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   interface{}
+		data   map[string]interface{}
+		err    string
+	}{
+		{"GET", "ok", nil, map[string]interface{}{"key": "value"}, ""},
+		{"POST", "nocontent", map[string]interface{}{"a": "b"}, nil, ""},
+		{"POST", "invalid", map[string]interface{}{}, nil, "name is required, bad cipher"},
+		{"GET", "denied", nil, nil, "permission denied"},
+		{"GET", "broken", nil, nil, "500 Internal Server Error"},
+		{"GET", "empty", nil, nil, "503 Service Unavailable"},
+		{"GET", "missing", nil, nil, "404 Not Found"},
+	}
+	for _, test := range tests {
+		data, err := vault.request(test.method, test.path, test.body)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s %s: error %v, want %q", test.method, test.path, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %s", test.method, test.path, err)
+			continue
+		}
+		if len(data) != len(test.data) || data["key"] != test.data["key"] {
+			t.Errorf("%s %s: data %v, want %v", test.method, test.path, data, test.data)
+		}
+	}
+
+	req := fake.request(t, "POST /v1/e2e/nocontent")
+	if req.token != "test-token" {
+		t.Errorf("token %q, want test-token", req.token)
+	}
+	if req.body["a"] != "b" {
+		t.Errorf("body %v, want the JSON request body", req.body)
+	}
+}
+
+func TestNewVaultClientNeedsToken(t *testing.T) {
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("HOME", t.TempDir())
+	if _, err := newVaultClient("e2e"); err == nil {
+		t.Error("vault client created without a token")
+	}
+}
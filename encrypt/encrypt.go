@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/client"
+)
+
+/*
+ * Encrypt a JSON payload on stdin to a recipient's public key, offline, in the
+ * same format as the plugin's payload endpoint, e.g. to generate fixtures:
+ *
+ *   encrypt -pubkey key_pub.pem [-format binary] < plain.json > payload.txt
+ */
+func main() {
+	encrypter := &client.Encrypter{}
+	encrypter.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	plaintext, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		panic(err)
+	}
+	encrypted, err := encrypter.Encrypt(plaintext)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(encrypted))
+}
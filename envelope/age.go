@@ -0,0 +1,123 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+	"golang.org/x/crypto/ssh"
+)
+
+// Age recipient key types, an age X25519 recipient (age1...) or an SSH public
+// key in authorized_keys format
+const (
+	AgeX25519KeyType  = "age-X25519"
+	SSHEd25519KeyType = ssh.KeyAlgoED25519
+	SSHRSAKeyType     = ssh.KeyAlgoRSA
+)
+
+// AgeHeader starts a binary age file, armoured files start with armor.Header
+const AgeHeader = "age-encryption.org/v1"
+
+// IsAgeKeyType reports whether keyType is an age recipient key type
+func IsAgeKeyType(keyType string) bool {
+	switch keyType {
+	case AgeX25519KeyType, SSHEd25519KeyType, SSHRSAKeyType:
+		return true
+	}
+	return false
+}
+
+// IsAgeRecipient reports whether s looks like an age or SSH recipient rather
+// than a PEM public key
+func IsAgeRecipient(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.HasPrefix(s, "age1") || strings.HasPrefix(s, "ssh-")
+}
+
+// ParseAgeRecipient parses an age X25519 recipient or an ssh-ed25519/ssh-rsa
+// public key, returning the recipient, its key type and the encoded key for
+// fingerprinting (the recipient string, or the SSH wire format key)
+func ParseAgeRecipient(s string) (age.Recipient, string, []byte, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "age1") {
+		recipient, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return recipient, AgeX25519KeyType, []byte(recipient.String()), nil
+	}
+
+	sshPub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	recipient, err := agessh.ParseRecipient(s)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return recipient, sshPub.Type(), sshPub.Marshal(), nil
+}
+
+// EncryptAge encrypts plaintext as an age file for the recipient, binary or
+// armoured
+func EncryptAge(recipient age.Recipient, plaintext []byte, armoured bool) ([]byte, error) {
+	var out bytes.Buffer
+	var dst io.Writer = &out
+	var armourWriter io.WriteCloser
+	if armoured {
+		armourWriter = armor.NewWriter(&out)
+		dst = armourWriter
+	}
+
+	w, err := age.Encrypt(dst, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if armourWriter != nil {
+		if err := armourWriter.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// ParseAgeIdentities parses age identities (AGE-SECRET-KEY-1...) or an SSH
+// private key. An encrypted SSH private key is decrypted with the passphrase
+// when it is first used, it must be an OpenSSH key (whose public key is not
+// encrypted) and passphrase must not be nil.
+func ParseAgeIdentities(keyData []byte, passphrase func() ([]byte, error)) ([]age.Identity, error) {
+	if bytes.Contains(keyData, []byte("AGE-SECRET-KEY-1")) {
+		return age.ParseIdentities(bytes.NewReader(keyData))
+	}
+	identity, err := agessh.ParseIdentity(keyData)
+	if missing, ok := err.(*ssh.PassphraseMissingError); ok && passphrase != nil && missing.PublicKey != nil {
+		identity, err = agessh.NewEncryptedSSHIdentity(missing.PublicKey, keyData, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []age.Identity{identity}, nil
+}
+
+// DecryptAge returns a reader of the plaintext of an age file, binary or
+// armoured
+func DecryptAge(r io.Reader, armoured bool, identities ...age.Identity) (io.Reader, error) {
+	if len(identities) == 0 {
+		return nil, errors.New("no age identities")
+	}
+	if armoured {
+		r = armor.NewReader(r)
+	}
+	return age.Decrypt(r, identities...)
+}
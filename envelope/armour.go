@@ -0,0 +1,128 @@
+package envelope
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+)
+
+// E2E ENCRYPTED PAYLOAD armour lines
+const (
+	ArmourBegin = "-----BEGIN E2E ENCRYPTED PAYLOAD-----"
+	ArmourEnd   = "-----END E2E ENCRYPTED PAYLOAD-----"
+)
+
+// armourLineLength is the length of the armour's base64 body lines
+const armourLineLength = 76
+
+// Armour decoding errors
+var (
+	ErrNoArmour   = errors.New("no E2E ENCRYPTED PAYLOAD found")
+	ErrArmourEnd  = errors.New("E2E ENCRYPTED PAYLOAD has no END line")
+	ErrArmourBody = errors.New("E2E ENCRYPTED PAYLOAD body is not base64")
+)
+
+// EncodeArmour wraps the headers and body as an E2E ENCRYPTED PAYLOAD armour
+func EncodeArmour(h *Headers, body []byte) string {
+	armourLines := []string{ArmourBegin}
+	armourLines = append(armourLines, h.Lines()...)
+	armourLines = append(armourLines, "")
+	armourLines = append(armourLines, splitB64(base64.StdEncoding.EncodeToString(body), armourLineLength)...)
+	armourLines = append(armourLines, ArmourEnd)
+	return strings.Join(armourLines, "\n")
+}
+
+// DecodeArmour reads an E2E ENCRYPTED PAYLOAD armour's headers from r,
+// returning them and a reader of the decoded body. The body is decoded as it
+// is read, and reading it fails with ErrArmourEnd if the END line is missing.
+func DecodeArmour(r io.Reader) (*Headers, io.Reader, error) {
+	scanner := bufio.NewScanner(r)
+
+	// the BEGIN line, after any blank lines
+	for {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, ErrNoArmour
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == ArmourBegin {
+			break
+		}
+		if line != "" {
+			return nil, nil, ErrNoArmour
+		}
+	}
+
+	// headers, until the empty line
+	lines := []string{}
+	for {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, ErrArmourEnd
+		}
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		if len(lines) == len(headerNames) {
+			return nil, nil, &HeaderError{Header: line, Reason: "too many headers"}
+		}
+		lines = append(lines, line)
+	}
+	h, err := ParseHeaders(lines)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, base64.NewDecoder(base64.StdEncoding, &armourBody{scanner: scanner}), nil
+}
+
+// armourBody reads the base64 body lines of the armour, up to the END line
+type armourBody struct {
+	scanner *bufio.Scanner
+	line    []byte
+	ended   bool
+}
+
+func (body *armourBody) Read(p []byte) (int, error) {
+	for len(body.line) == 0 {
+		if body.ended {
+			return 0, io.EOF
+		}
+		if !body.scanner.Scan() {
+			if err := body.scanner.Err(); err != nil {
+				return 0, err
+			}
+			return 0, ErrArmourEnd
+		}
+		line := strings.TrimSpace(body.scanner.Text())
+		if line == ArmourEnd {
+			body.ended = true
+			continue
+		}
+		if strings.HasPrefix(line, "-") {
+			return 0, ErrArmourBody
+		}
+		body.line = []byte(line)
+	}
+	n := copy(p, body.line)
+	body.line = body.line[n:]
+	return n, nil
+}
+
+// https://stackoverflow.com/questions/45412089/split-a-base64-line-into-chunks
+func splitB64(s string, size int) []string {
+	ss := make([]string, 0, len(s)/size+1)
+	for len(s) > 0 {
+		if len(s) < size {
+			size = len(s)
+		}
+		ss, s = append(ss, s[:size]), s[size:]
+	}
+	return ss
+}
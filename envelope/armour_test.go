@@ -0,0 +1,89 @@
+package envelope
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// testHeaders are the headers of the armours and containers parsed by the
+// tests
+var testHeaders = &Headers{Version: Version21, Compression: CompressionGzip, PayloadID: "abc"}
+
+func isHeaderError(err error) bool {
+	_, ok := err.(*HeaderError)
+	return ok
+}
+
+func isVersionError(err error) bool {
+	_, ok := err.(*VersionError)
+	return ok
+}
+
+// decodeArmour decodes an armour and reads its body
+func decodeArmour(armour string) (*Headers, []byte, error) {
+	h, body, err := DecodeArmour(strings.NewReader(armour))
+	if err != nil {
+		return nil, nil, err
+	}
+	decoded, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, decoded, nil
+}
+
+func TestDecodeArmourErrors(t *testing.T) {
+	armour := func(headers ...string) string {
+		return strings.Join(append(append([]string{ArmourBegin}, headers...), "", "AAAA", ArmourEnd), "\n")
+	}
+	tests := []struct {
+		name   string
+		armour string
+		check  func(error) bool
+	}{
+		{"no armour", "hello", func(err error) bool { return err == ErrNoArmour }},
+		{"empty", "", func(err error) bool { return err == ErrNoArmour }},
+		{"no end line", ArmourBegin + "\nPAYLOAD_VERSION: 2.0\n\nAAAA\n", func(err error) bool { return err == ErrArmourEnd }},
+		{"no body", ArmourBegin + "\nPAYLOAD_VERSION: 2.0\n", func(err error) bool { return err == ErrArmourEnd }},
+		{"armour in body", ArmourBegin + "\nPAYLOAD_VERSION: 2.0\n\nAAAA\n" + ArmourBegin + "\n" + ArmourEnd, func(err error) bool { return err == ErrArmourBody }},
+		{"unknown header", armour("PAYLOAD_VERSION: 2.1", "COLOUR: blue"), isHeaderError},
+		{"version not first", armour("CIPHER: "+CipherChaCha20Poly1305, "PAYLOAD_VERSION: 2.1"), isHeaderError},
+		{"duplicate header", armour("PAYLOAD_VERSION: 2.1", "PAYLOAD_ID: a", "PAYLOAD_ID: b"), isHeaderError},
+		{"out of order", armour("PAYLOAD_VERSION: 2.1", "PAYLOAD_ID: a", "COMPRESSION: GZIP"), isHeaderError},
+		{"padded value", armour("PAYLOAD_VERSION: 2.1", "PAYLOAD_ID:  a"), isHeaderError},
+		{"not a header", armour("PAYLOAD_VERSION: 2.1", "PAYLOAD_ID"), isHeaderError},
+		{"headers on 2.0", armour("PAYLOAD_VERSION: 2.0", "PAYLOAD_ID: a"), isHeaderError},
+		{"bad chunk size", armour("PAYLOAD_VERSION: 2.1", "STREAM: 012"), isHeaderError},
+		{"bad expiry", armour("PAYLOAD_VERSION: 2.1", "EXPIRES: tomorrow"), isHeaderError},
+		{"unsigned signing key", armour("PAYLOAD_VERSION: 2.1", "SIGNING_KEY: k"), isHeaderError},
+		{"unknown version", armour("PAYLOAD_VERSION: 9.0"), isVersionError},
+	}
+	for _, test := range tests {
+		if _, _, err := decodeArmour(test.armour); !test.check(err) {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
+
+func TestDecodeArmourTruncated(t *testing.T) {
+	body := bytes.Repeat([]byte("payload body "), 20)
+	armour := EncodeArmour(testHeaders, body)
+
+	h, decoded, err := decodeArmour(armour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.PayloadID != testHeaders.PayloadID || !bytes.Equal(decoded, body) {
+		t.Fatalf("armour decoded to %+v %q", h, decoded)
+	}
+
+	// every truncation fails, there is no point the END line can be dropped
+	// and a shorter body read
+	for i := 0; i < len(armour); i++ {
+		if _, _, err := decodeArmour(armour[:i]); err == nil {
+			t.Errorf("armour truncated to %d bytes decoded", i)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Content cipher ids, recorded in the authenticated CIPHER armour header when
+// not the default AES-256-GCM
+const (
+	CipherAES256GCM         = "AES-256-GCM"
+	CipherChaCha20Poly1305  = "CHACHA20-POLY1305"
+	CipherXChaCha20Poly1305 = "XCHACHA20-POLY1305"
+)
+
+// maxNonceSize is the largest nonce of the supported ciphers (XChaCha20)
+const maxNonceSize = chacha20poly1305.NonceSizeX
+
+// ValidCipher reports whether id names a supported content cipher
+func ValidCipher(id string) bool {
+	switch id {
+	case CipherAES256GCM, CipherChaCha20Poly1305, CipherXChaCha20Poly1305:
+		return true
+	}
+	return false
+}
+
+// NewAEAD returns the content cipher for id with a 32 byte key, an empty id
+// is AES-256-GCM
+func NewAEAD(id string, key []byte) (cipher.AEAD, error) {
+	switch id {
+	case "", CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, fmt.Errorf("unsupported cipher %q", id)
+}
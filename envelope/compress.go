@@ -0,0 +1,112 @@
+package envelope
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression ids, recorded in the authenticated COMPRESSION armour header
+// when the payload is compressed before encryption
+const (
+	CompressionNone = "NONE"
+	CompressionGzip = "GZIP"
+	CompressionZstd = "ZSTD"
+)
+
+// DefaultMaxDecompressedSize limits the size of a decompressed payload, so a
+// small payload cannot expand without bound (a decompression bomb)
+const DefaultMaxDecompressedSize = 64 * 1024 * 1024
+
+// ErrTooLarge is returned when a payload decompresses to more than the limit
+var ErrTooLarge = errors.New("decompressed payload exceeds the size limit")
+
+// ValidCompression reports whether id names a supported compression
+func ValidCompression(id string) bool {
+	switch id {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return true
+	}
+	return false
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// NewCompressor returns a writer compressing to w, Close flushes the
+// compressed stream but does not close w. An empty id or NONE writes through
+// uncompressed.
+func NewCompressor(id string, w io.Writer) (io.WriteCloser, error) {
+	switch id {
+	case "", CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("unsupported compression %q", id)
+}
+
+// NewDecompressor returns a reader decompressing r, which returns ErrTooLarge
+// rather than more than limit bytes. An empty id or NONE reads r unchanged.
+func NewDecompressor(id string, r io.Reader, limit int64) (io.Reader, error) {
+	var decompressed io.Reader
+	switch id {
+	case "", CompressionNone:
+		return r, nil
+	case CompressionGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		gz.Multistream(false)
+		decompressed = gz
+	case CompressionZstd:
+		options := []zstd.DOption{
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(uint64(limit)),
+		}
+		if limit >= zstd.MinWindowSize && limit <= zstd.MaxWindowSize {
+			options = append(options, zstd.WithDecoderMaxWindow(uint64(limit)))
+		}
+		zr, err := zstd.NewReader(r, options...)
+		if err != nil {
+			return nil, err
+		}
+		decompressed = zr.IOReadCloser()
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", id)
+	}
+	return &limitReader{r: decompressed, remaining: limit}, nil
+}
+
+// limitReader fails, rather than truncating like io.LimitReader, when its
+// limit is exceeded
+type limitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > lr.remaining+1 {
+		p = p[:lr.remaining+1]
+	}
+	n, err := lr.r.Read(p)
+	if int64(n) > lr.remaining {
+		return 0, ErrTooLarge
+	}
+	// the zstd decoder enforces the limit itself, for frames that declare
+	// their size or window
+	if err == zstd.ErrDecoderSizeExceeded || err == zstd.ErrWindowSizeExceeded {
+		return 0, ErrTooLarge
+	}
+	lr.remaining -= int64(n)
+	return n, err
+}
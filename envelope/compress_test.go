@@ -0,0 +1,46 @@
+package envelope
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDecompressorLimit(t *testing.T) {
+	const limit = 64 * 1024
+	for _, id := range []string{CompressionGzip, CompressionZstd} {
+		for _, test := range []struct {
+			size int
+			err  error
+		}{
+			{limit, nil},
+			{limit + 1, ErrTooLarge},
+			// a bomb, a megabyte of zeros compresses to a few hundred bytes
+			{1024 * 1024, ErrTooLarge},
+		} {
+			var compressed bytes.Buffer
+			compressor, err := NewCompressor(id, &compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := compressor.Write(make([]byte, test.size)); err != nil {
+				t.Fatal(err)
+			}
+			if err := compressor.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			decompressor, err := NewDecompressor(id, &compressed, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decompressed, err := ioutil.ReadAll(decompressor)
+			if err != test.err {
+				t.Errorf("%s %d bytes: got %v, want %v", id, test.size, err, test.err)
+			}
+			if err == nil && len(decompressed) != test.size {
+				t.Errorf("%s %d bytes: decompressed %d bytes", id, test.size, len(decompressed))
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The binary container carries the same headers and body as the E2E armour,
+//
+//	magic "E2EP" | container version (1 byte)
+//	header records: tag (1 byte) | length (uint16, little endian) | value
+//	body record: tag 0x00, then the body to the end of the container
+//
+// Header records are in ascending tag order, each at most once, starting with
+// PAYLOAD_VERSION. The header lines they stand for ("NAME: value", in order)
+// are the additional data, exactly as for the armour.
+const (
+	ContainerMagic   = "E2EP"
+	ContainerVersion = 1
+)
+
+// Container record tags
+const (
+	TagBody           = 0x00
+	TagPayloadVersion = 0x01
+	TagKeyAgreement   = 0x02
+	TagCipher         = 0x03
+	TagCompression    = 0x04
+	TagStream         = 0x05
+	TagPayloadID      = 0x06
+	TagExpires        = 0x07
+	TagSigningKey     = 0x08
+	TagSignature      = 0x09
+)
+
+// Container decoding errors
+var (
+	ErrNotContainer       = errors.New("not an E2E payload container")
+	ErrContainerTruncated = errors.New("container is truncated")
+)
+
+// MarshalContainer encodes the headers and body as a binary container, each
+// header's tag is its position in the header order, from 1
+func MarshalContainer(h *Headers, body []byte) ([]byte, error) {
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(ContainerMagic)
+	out.WriteByte(ContainerVersion)
+	for _, line := range h.Lines() {
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts[1]) > 0xffff {
+			return nil, &HeaderError{Header: parts[0], Reason: "too long"}
+		}
+		var length [2]byte
+		binary.LittleEndian.PutUint16(length[:], uint16(len(parts[1])))
+		out.WriteByte(containerTag(parts[0]))
+		out.Write(length[:])
+		out.WriteString(parts[1])
+	}
+
+	out.WriteByte(TagBody)
+	out.Write(body)
+	return out.Bytes(), nil
+}
+
+// ReadContainer reads a binary container's header records from r, returning
+// the headers and r positioned at the body
+func ReadContainer(r io.Reader) (*Headers, io.Reader, error) {
+	var preamble [len(ContainerMagic) + 1]byte
+	if _, err := io.ReadFull(r, preamble[:]); err != nil {
+		return nil, nil, ErrContainerTruncated
+	}
+	if string(preamble[:len(ContainerMagic)]) != ContainerMagic {
+		return nil, nil, ErrNotContainer
+	}
+	if preamble[len(ContainerMagic)] != ContainerVersion {
+		return nil, nil, &VersionError{Format: "container", Version: fmt.Sprint(preamble[len(ContainerMagic)])}
+	}
+
+	// the header records become header lines, which ParseHeaders checks for
+	// order and duplicates
+	lines := []string{}
+	for {
+		var tag [1]byte
+		if _, err := io.ReadFull(r, tag[:]); err != nil {
+			return nil, nil, ErrContainerTruncated
+		}
+		if tag[0] == TagBody {
+			break
+		}
+		if int(tag[0]) > len(headerNames) {
+			return nil, nil, &HeaderError{Header: fmt.Sprintf("tag 0x%02x", tag[0]), Reason: "unknown container tag"}
+		}
+		if len(lines) == len(headerNames) {
+			return nil, nil, &HeaderError{Header: headerNames[tag[0]-1], Reason: "too many headers"}
+		}
+
+		value, err := readPrefixed(r)
+		if err == ErrKeyTruncated {
+			return nil, nil, ErrContainerTruncated
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		lines = append(lines, headerNames[tag[0]-1]+": "+string(value))
+	}
+
+	h, err := ParseHeaders(lines)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, r, nil
+}
+
+// containerTag returns the tag of a named header
+func containerTag(name string) byte {
+	for i, known := range headerNames {
+		if known == name {
+			return byte(i + 1)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package envelope
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Label binds the payload encryption to this plugin, it is the RSA-OAEP label
+// and the HKDF info for key agreement recipients
+const Label = "Vault E2E Payload"
+
+// ECDHPublicKey converts a parsed PKIX public key to an ECDH key, supporting
+// P-256, P-384 and X25519 recipients
+func ECDHPublicKey(pub crypto.PublicKey) (*ecdh.PublicKey, error) {
+	switch k := pub.(type) {
+	case *ecdh.PublicKey:
+		if k.Curve() != ecdh.X25519() && k.Curve() != ecdh.P256() && k.Curve() != ecdh.P384() {
+			return nil, errors.New("unsupported ecdh curve")
+		}
+		return k, nil
+	case *ecdsa.PublicKey:
+		ek, err := k.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		return ECDHPublicKey(ek)
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// ECDHPrivateKey converts a parsed private key to an ECDH key
+func ECDHPrivateKey(key crypto.PrivateKey) (*ecdh.PrivateKey, error) {
+	switch k := key.(type) {
+	case *ecdh.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k.ECDH()
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+// EncapsulateECDH establishes a content key for an ECDH recipient (ECIES
+// style): an ephemeral key pair is generated on the recipient's curve and
+// HKDF-SHA256 over the shared secret derives the content cipher's key and
+// nonce. The encapsulated key is
+//
+//	ephemeral public key length (uint16, little endian) | ephemeral public key
+func EncapsulateECDH(pub *ecdh.PublicKey, cipherID string) ([]byte, cipher.AEAD, []byte, error) {
+	ephemeral, err := pub.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	shared, err := ephemeral.ECDH(pub)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	aead, nonce, err := ecdhAEAD(cipherID, shared, ephemeralPub, pub.Bytes())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	encapsulated := make([]byte, 2)
+	binary.LittleEndian.PutUint16(encapsulated, uint16(len(ephemeralPub)))
+	encapsulated = append(encapsulated, ephemeralPub...)
+	return encapsulated, aead, nonce, nil
+}
+
+// DecapsulateECDH reads a key encapsulated by EncapsulateECDH from r
+func DecapsulateECDH(key *ecdh.PrivateKey, cipherID string, r io.Reader) (cipher.AEAD, []byte, error) {
+	ephemeralBytes, err := readPrefixed(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ephemeralPub, err := key.Curve().NewPublicKey(ephemeralBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ephemeral public key: %s", err)
+	}
+	shared, err := key.ECDH(ephemeralPub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ecdhAEAD(cipherID, shared, ephemeralBytes, key.PublicKey().Bytes())
+}
+
+// ecdhAEAD derives the content key and nonce from the shared secret, salted
+// with both public keys so the derivation is bound to this recipient.
+func ecdhAEAD(cipherID string, shared []byte, ephemeralPub []byte, recipientPub []byte) (cipher.AEAD, []byte, error) {
+	salt := append(append([]byte{}, ephemeralPub...), recipientPub...)
+	return deriveAEAD(cipherID, shared, salt)
+}
+
+// deriveAEAD derives the content cipher's key and nonce with HKDF-SHA256. The
+// key is unique per payload, so a derived nonce is safe. HKDF output is a
+// prefix of any longer output, so the key and a shorter nonce are unchanged by
+// deriving enough for the largest nonce.
+func deriveAEAD(cipherID string, secret []byte, salt []byte) (cipher.AEAD, []byte, error) {
+	keyNonce, err := hkdf.Key(sha256.New, secret, salt, Label, 32+maxNonceSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := NewAEAD(cipherID, keyNonce[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, keyNonce[32 : 32+aead.NonceSize()], nil
+}
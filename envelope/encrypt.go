@@ -0,0 +1,216 @@
+package envelope
+
+import (
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// Payload output formats
+const (
+	FormatArmour    = "armour"
+	FormatBinary    = "binary"
+	FormatJWE       = "jwe"
+	FormatJWEJSON   = "jwe-json"
+	FormatAge       = "age"
+	FormatAgeBinary = "age-binary"
+	FormatPGP       = "pgp"
+)
+
+// EncryptOptions are a payload's output format and its recipient's
+// enrolement options
+type EncryptOptions struct {
+	// Format is the output format, empty for DefaultFormat
+	Format string
+	// Cipher is the content cipher, empty for AES-256-GCM
+	Cipher string
+	// Compression is applied before encryption, empty or NONE for none
+	Compression string
+	// Stream encrypts armour and binary payloads as STREAM chunks
+	Stream bool
+	// PayloadID is the id of a leased payload
+	PayloadID string
+	// KeyID is the JWE kid, the enrolement's fingerprint
+	KeyID string
+	// Expires is when the payload expires, zero if it does not. Age and PGP
+	// payloads cannot carry it.
+	Expires time.Time
+	// SigningKey signs armour, binary and jwe-json payloads, nil to leave
+	// them unsigned. JWE compact, age and PGP payloads cannot carry a
+	// signature, so are refused one.
+	SigningKey *SigningKey
+}
+
+// DefaultFormat returns the format recipients of keyType can decrypt with
+// their standard tools
+func DefaultFormat(keyType string) string {
+	switch {
+	case keyType == OpenPGPKeyType:
+		return FormatPGP
+	case IsAgeKeyType(keyType):
+		return FormatAge
+	}
+	return FormatArmour
+}
+
+// CheckFormat rejects output formats that cannot carry the recipient's key
+// type or enrolement options
+func CheckFormat(format string, keyType string, opts *EncryptOptions) error {
+	if IsAgeKeyType(keyType) && format != FormatAge && format != FormatAgeBinary {
+		return fmt.Errorf("%s recipients require the age or age-binary format", keyType)
+	}
+	if keyType == OpenPGPKeyType && format != FormatPGP {
+		return fmt.Errorf("%s recipients require the pgp format", keyType)
+	}
+	if !opts.Expires.IsZero() && (format == FormatPGP || format == FormatAge || format == FormatAgeBinary) {
+		return fmt.Errorf("%s format does not support expiry", format)
+	}
+	if opts.SigningKey != nil && format != FormatArmour && format != FormatBinary && format != FormatJWEJSON {
+		return fmt.Errorf("%s format does not support signing", format)
+	}
+	switch format {
+	case FormatArmour, FormatBinary:
+		return nil
+	case FormatPGP:
+		if keyType != OpenPGPKeyType {
+			return fmt.Errorf("%s format requires an OpenPGP recipient", format)
+		}
+		if opts.Stream {
+			return fmt.Errorf("%s format does not support stream", format)
+		}
+		return nil
+	case FormatAge, FormatAgeBinary:
+		if !IsAgeKeyType(keyType) {
+			return fmt.Errorf("%s format requires an age or SSH recipient", format)
+		}
+		return nil
+	case FormatJWE, FormatJWEJSON:
+		if keyType == HybridKeyType {
+			return fmt.Errorf("%s format does not support %s recipients", format, keyType)
+		}
+		if opts.Cipher != "" && opts.Cipher != CipherAES256GCM {
+			return fmt.Errorf("%s format only supports the %s cipher", format, CipherAES256GCM)
+		}
+		if opts.Stream {
+			return fmt.Errorf("%s format does not support stream", format)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported format %q", format)
+}
+
+// Encrypt encrypts a payload in any output format, to a public key and key
+// type from ParsePublicKey. JWE flattened JSON payloads are returned as a
+// *JWEJSON, other formats as a string.
+func Encrypt(pub crypto.PublicKey, keyType string, plaintext []byte, opts *EncryptOptions) (interface{}, error) {
+	format := opts.Format
+	if format == "" {
+		format = DefaultFormat(keyType)
+	}
+	if err := CheckFormat(format, keyType, opts); err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatJWE, FormatJWEJSON:
+		return jwePayload(pub, plaintext, opts, format == FormatJWEJSON)
+	case FormatAge, FormatAgeBinary:
+		return agePayload(pub, plaintext, format == FormatAge)
+	case FormatPGP:
+		return pgpPayload(pub, plaintext, opts)
+	}
+	return e2ePayload(pub, keyType, plaintext, opts, format == FormatBinary)
+}
+
+// e2ePayload encrypts the payload as an E2E ENCRYPTED PAYLOAD armour, or as a
+// base64 encoded binary container
+func e2ePayload(pub crypto.PublicKey, keyType string, plaintext []byte, opts *EncryptOptions, binary bool) (string, error) {
+	headers, body, err := Seal(pub, keyType, plaintext, &SealOptions{
+		Cipher:      opts.Cipher,
+		Compression: opts.Compression,
+		Stream:      opts.Stream,
+		PayloadID:   opts.PayloadID,
+		Expires:     opts.Expires,
+		SigningKey:  opts.SigningKey,
+	})
+	if err != nil {
+		return "", err
+	}
+	if binary {
+		container, err := MarshalContainer(headers, body)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(container), nil
+	}
+	return EncodeArmour(headers, body), nil
+}
+
+// jwePayload encrypts the payload as a JWE, compact or flattened JSON, with
+// the KeyID as the kid and any expiry as exp. Compressing enrolements use the
+// JWE DEFLATE compression. Only the flattened JSON serialisation can be
+// signed.
+func jwePayload(pub crypto.PublicKey, plaintext []byte, opts *EncryptOptions, jsonSerialisation bool) (interface{}, error) {
+	extra := map[string]interface{}{
+		"cty": "application/json",
+	}
+	if opts.PayloadID != "" {
+		extra["payload_id"] = opts.PayloadID
+	}
+	if !opts.Expires.IsZero() {
+		extra["exp"] = opts.Expires.Unix()
+	}
+	if opts.SigningKey != nil {
+		extra[jweSigningKeyParameter] = opts.SigningKey.ID
+	}
+	deflate := opts.Compression != "" && opts.Compression != CompressionNone
+
+	jwe, err := EncryptJWE(pub, opts.KeyID, plaintext, extra, deflate)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SigningKey != nil {
+		jwe.Sign(opts.SigningKey)
+	}
+	if jsonSerialisation {
+		return jwe.JSON(), nil
+	}
+	return jwe.Compact(), nil
+}
+
+// agePayload encrypts the payload as an age file for an age or SSH recipient,
+// armoured or base64 encoded binary
+func agePayload(pub crypto.PublicKey, plaintext []byte, armoured bool) (string, error) {
+	recipient, ok := pub.(age.Recipient)
+	if !ok {
+		return "", fmt.Errorf("%T is not an age recipient", pub)
+	}
+	encrypted, err := EncryptAge(recipient, plaintext, armoured)
+	if err != nil {
+		return "", err
+	}
+	if armoured {
+		return string(encrypted), nil
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+// pgpPayload encrypts the payload as an ASCII armoured PGP MESSAGE for an
+// OpenPGP recipient. The payload id of leased payloads is carried as the
+// literal data's file name.
+func pgpPayload(pub crypto.PublicKey, plaintext []byte, opts *EncryptOptions) (string, error) {
+	entity, ok := pub.(*openpgp.Entity)
+	if !ok {
+		return "", fmt.Errorf("%T is not an OpenPGP key", pub)
+	}
+	compress := opts.Compression != "" && opts.Compression != CompressionNone
+	encrypted, err := EncryptOpenPGP(entity, plaintext, opts.PayloadID, compress)
+	if err != nil {
+		return "", err
+	}
+	return string(encrypted), nil
+}
@@ -0,0 +1,57 @@
+package envelope
+
+import (
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestDefaultFormat(t *testing.T) {
+	tests := []struct {
+		keyType string
+		format  string
+	}{
+		{"rsa", FormatArmour},
+		{"P-256", FormatArmour},
+		{"X25519", FormatArmour},
+		{HybridKeyType, FormatArmour},
+		{AgeX25519KeyType, FormatAge},
+		{SSHEd25519KeyType, FormatAge},
+		{SSHRSAKeyType, FormatAge},
+		{OpenPGPKeyType, FormatPGP},
+	}
+	for _, test := range tests {
+		format := DefaultFormat(test.keyType)
+		if format != test.format {
+			t.Errorf("DefaultFormat(%q) = %q, want %q", test.keyType, format, test.format)
+		}
+		if err := CheckFormat(format, test.keyType, &EncryptOptions{}); err != nil {
+			t.Errorf("%s default format: %s", test.keyType, err)
+		}
+	}
+}
+
+func TestEncryptAgeDefaultFormat(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, keyType, _, err := ParsePublicKey(identity.Recipient().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// age recipients are sent armoured age files unless they ask otherwise,
+	// and are refused the E2E formats
+	encrypted, err := Encrypt(pub, keyType, []byte(`{"hello": "world"}`), &EncryptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted.(string), "-----BEGIN AGE ENCRYPTED FILE-----") {
+		t.Errorf("default age payload %q", encrypted)
+	}
+	if _, err := Encrypt(pub, keyType, []byte(`{}`), &EncryptOptions{Format: FormatArmour}); err == nil {
+		t.Error("armour format accepted for an age recipient")
+	}
+}
@@ -0,0 +1,88 @@
+// Package envelope holds the E2E payload encryption primitives shared by the
+// vault plugin and the recipient tools. The plugin's payload endpoint and
+// offline encryption both encrypt with Encrypt, so their payloads are the
+// same format.
+package envelope
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrKeyTruncated is returned when a payload ends within its encapsulated key
+var ErrKeyTruncated = errors.New("encapsulated key is truncated")
+
+// Encapsulate establishes a content key for a recipient's public key,
+// returning the encapsulated key to send ahead of the ciphertext, and the
+// content cipher and nonce
+func Encapsulate(pub crypto.PublicKey, cipherID string) ([]byte, cipher.AEAD, []byte, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return EncapsulateRSA(pub, cipherID)
+	case *ecdh.PublicKey:
+		return EncapsulateECDH(pub, cipherID)
+	case *HybridPublicKey:
+		return EncapsulateHybrid(pub, cipherID)
+	}
+	return nil, nil, nil, fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// Decapsulate reads an encapsulated content key from r with the recipient's
+// private key, leaving r at the start of the ciphertext. RSA keys may be any
+// crypto.Decrypter, e.g. one backed by a token or agent.
+func Decapsulate(key crypto.PrivateKey, cipherID string, r io.Reader) (cipher.AEAD, []byte, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return DecapsulateRSA(key, cipherID, r)
+	case *HybridPrivateKey:
+		return DecapsulateHybrid(key, cipherID, r)
+	}
+	if decrypter, ok := IsRSADecrypter(key); ok {
+		return DecapsulateRSA(decrypter, cipherID, r)
+	}
+	ecKey, err := ECDHPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return DecapsulateECDH(ecKey, cipherID, r)
+}
+
+// IsRSADecrypter reports whether key is a crypto.Decrypter of an RSA key
+func IsRSADecrypter(key crypto.PrivateKey) (crypto.Decrypter, bool) {
+	decrypter, ok := key.(crypto.Decrypter)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := decrypter.Public().(*rsa.PublicKey); !ok {
+		return nil, false
+	}
+	return decrypter, true
+}
+
+// readPrefixed reads a uint16 little endian length prefixed field
+func readPrefixed(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, truncated(err)
+	}
+	field := make([]byte, binary.LittleEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, truncated(err)
+	}
+	return field, nil
+}
+
+// truncated reports a short read of an encapsulated key as ErrKeyTruncated,
+// passing on errors from the reader (e.g. armour decoding errors)
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrKeyTruncated
+	}
+	return err
+}
@@ -0,0 +1,250 @@
+package envelope
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Payload versions
+const (
+	// Version20 is an RSA payload with no other headers and no additional data
+	Version20 = "2.0"
+	// Version21 is an RSA payload with authenticated headers
+	Version21 = "2.1"
+	// Version30 is an ECDH (P-256, P-384 or X25519) payload
+	Version30 = "3.0"
+	// Version40 is an ML-KEM-768+X25519 hybrid payload
+	Version40 = "4.0"
+)
+
+// headerNames are the payload headers, in the order they must appear
+var headerNames = []string{
+	"PAYLOAD_VERSION",
+	"KEY_AGREEMENT",
+	"CIPHER",
+	"COMPRESSION",
+	"STREAM",
+	"PAYLOAD_ID",
+	"EXPIRES",
+	"SIGNING_KEY",
+	"SIGNATURE",
+}
+
+// Headers are the authenticated headers of an E2E payload, as carried by the
+// armour and the binary container
+type Headers struct {
+	// Version is the PAYLOAD_VERSION
+	Version string `json:"payload_version,omitempty"`
+	// KeyAgreement is the recipient key type of ECDH and hybrid payloads
+	KeyAgreement string `json:"key_agreement,omitempty"`
+	// Cipher is the content cipher, empty for AES-256-GCM
+	Cipher string `json:"cipher,omitempty"`
+	// Compression is the compression applied before encryption, empty for none
+	Compression string `json:"compression,omitempty"`
+	// ChunkSize is the STREAM chunk size, zero for payloads sealed in one go
+	ChunkSize int `json:"stream,omitempty"`
+	// PayloadID is the id of a leased payload
+	PayloadID string `json:"payload_id,omitempty"`
+	// Expires is when the payload expires (RFC 3339), empty if it does not
+	Expires string `json:"expires,omitempty"`
+	// SigningKey is the key id of the plugin signing key that signed the
+	// payload
+	SigningKey string `json:"signing_key,omitempty"`
+	// Signature is the base64 signature over the other headers and the
+	// body's SHA-256 digest, it is the only header that is not additional data
+	Signature string `json:"signature,omitempty"`
+}
+
+// HeaderError reports an invalid, unknown or misplaced payload header
+type HeaderError struct {
+	Header string
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("invalid %s header: %s", e.Header, e.Reason)
+}
+
+// VersionError reports an unsupported payload or container version
+type VersionError struct {
+	Format  string
+	Version string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("unsupported %s version %s", e.Format, e.Version)
+}
+
+// Lines returns the header lines ("NAME: value"), in order
+func (h *Headers) Lines() []string {
+	lines := []string{"PAYLOAD_VERSION: " + h.Version}
+	if h.KeyAgreement != "" {
+		lines = append(lines, "KEY_AGREEMENT: "+h.KeyAgreement)
+	}
+	if h.Cipher != "" {
+		lines = append(lines, "CIPHER: "+h.Cipher)
+	}
+	if h.Compression != "" {
+		lines = append(lines, "COMPRESSION: "+h.Compression)
+	}
+	if h.ChunkSize != 0 {
+		lines = append(lines, "STREAM: "+strconv.Itoa(h.ChunkSize))
+	}
+	if h.PayloadID != "" {
+		lines = append(lines, "PAYLOAD_ID: "+h.PayloadID)
+	}
+	if h.Expires != "" {
+		lines = append(lines, "EXPIRES: "+h.Expires)
+	}
+	if h.SigningKey != "" {
+		lines = append(lines, "SIGNING_KEY: "+h.SigningKey)
+	}
+	if h.Signature != "" {
+		lines = append(lines, "SIGNATURE: "+h.Signature)
+	}
+	return lines
+}
+
+// AdditionalData returns the data authenticated with the payload, the header
+// lines before SIGNATURE joined with newlines, or nil for version 2.0
+// payloads
+func (h *Headers) AdditionalData() []byte {
+	if h.Version == Version20 {
+		return nil
+	}
+	lines := h.Lines()
+	if h.Signature != "" {
+		lines = lines[:len(lines)-1]
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// Validate checks the headers are consistent with the payload version
+func (h *Headers) Validate() error {
+	switch h.Version {
+	case Version20:
+		if len(h.Lines()) > 1 {
+			return &HeaderError{Header: "PAYLOAD_VERSION", Reason: "version 2.0 payloads have no other headers"}
+		}
+	case Version21:
+		if h.KeyAgreement != "" {
+			return &HeaderError{Header: "KEY_AGREEMENT", Reason: "RSA payloads have no key agreement"}
+		}
+	case Version30:
+		switch h.KeyAgreement {
+		case "P-256", "P-384", "X25519":
+		default:
+			return &HeaderError{Header: "KEY_AGREEMENT", Reason: fmt.Sprintf("%q is not an ECDH key agreement", h.KeyAgreement)}
+		}
+	case Version40:
+		if h.KeyAgreement != HybridKeyType {
+			return &HeaderError{Header: "KEY_AGREEMENT", Reason: fmt.Sprintf("version 4.0 payloads require %s", HybridKeyType)}
+		}
+	default:
+		return &VersionError{Format: "payload", Version: h.Version}
+	}
+
+	if h.Cipher != "" && !ValidCipher(h.Cipher) {
+		return &HeaderError{Header: "CIPHER", Reason: fmt.Sprintf("unsupported cipher %q", h.Cipher)}
+	}
+	if h.Compression != "" && !ValidCompression(h.Compression) {
+		return &HeaderError{Header: "COMPRESSION", Reason: fmt.Sprintf("unsupported compression %q", h.Compression)}
+	}
+	if h.ChunkSize != 0 && !ValidChunkSize(h.ChunkSize) {
+		return &HeaderError{Header: "STREAM", Reason: fmt.Sprintf("chunk size %d is out of range", h.ChunkSize)}
+	}
+	if h.Expires != "" {
+		if _, err := time.Parse(time.RFC3339, h.Expires); err != nil {
+			return &HeaderError{Header: "EXPIRES", Reason: fmt.Sprintf("%q is not an RFC 3339 time", h.Expires)}
+		}
+	}
+	if h.Signature != "" {
+		if h.SigningKey == "" {
+			return &HeaderError{Header: "SIGNATURE", Reason: "signature without a SIGNING_KEY"}
+		}
+		if _, err := base64.StdEncoding.DecodeString(h.Signature); err != nil {
+			return &HeaderError{Header: "SIGNATURE", Reason: "not base64"}
+		}
+	}
+	return nil
+}
+
+// ParseHeaders parses and validates header lines. Each header must appear at
+// most once, in order, starting with PAYLOAD_VERSION, so the lines are exactly
+// those the additional data is rebuilt from.
+func ParseHeaders(lines []string) (*Headers, error) {
+	h := &Headers{}
+	next := 0
+	for i, line := range lines {
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			return nil, &HeaderError{Header: fmt.Sprintf("line %d", i+1), Reason: "expected NAME: value"}
+		}
+		name, value := parts[0], parts[1]
+		if value == "" || strings.TrimSpace(value) != value {
+			return nil, &HeaderError{Header: name, Reason: "empty or padded value"}
+		}
+
+		index := -1
+		for j, known := range headerNames {
+			if known == name {
+				index = j
+			}
+		}
+		switch {
+		case index < 0:
+			return nil, &HeaderError{Header: name, Reason: "unknown header"}
+		case i == 0 && index != 0:
+			return nil, &HeaderError{Header: name, Reason: "PAYLOAD_VERSION must be the first header"}
+		case index < next:
+			return nil, &HeaderError{Header: name, Reason: "duplicate or out of order"}
+		}
+		next = index + 1
+
+		if err := h.set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	if h.Version == "" {
+		return nil, &HeaderError{Header: "PAYLOAD_VERSION", Reason: "missing"}
+	}
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+	if h.SigningKey != "" && h.Signature == "" {
+		return nil, &HeaderError{Header: "SIGNING_KEY", Reason: "signing key without a SIGNATURE"}
+	}
+	return h, nil
+}
+
+// set assigns a named header's value
+func (h *Headers) set(name string, value string) error {
+	switch name {
+	case "PAYLOAD_VERSION":
+		h.Version = value
+	case "KEY_AGREEMENT":
+		h.KeyAgreement = value
+	case "CIPHER":
+		h.Cipher = value
+	case "COMPRESSION":
+		h.Compression = value
+	case "STREAM":
+		chunkSize, err := strconv.Atoi(value)
+		if err != nil || chunkSize <= 0 || strconv.Itoa(chunkSize) != value {
+			return &HeaderError{Header: name, Reason: fmt.Sprintf("%q is not a chunk size", value)}
+		}
+		h.ChunkSize = chunkSize
+	case "PAYLOAD_ID":
+		h.PayloadID = value
+	case "EXPIRES":
+		h.Expires = value
+	case "SIGNING_KEY":
+		h.SigningKey = value
+	case "SIGNATURE":
+		h.Signature = value
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package envelope
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/mlkem"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+const (
+	// HybridKeyType names the post-quantum hybrid recipient key type
+	HybridKeyType = "ML-KEM-768+X25519"
+
+	// HybridPublicKeyPEMType is the PEM block type of a hybrid public key,
+	// the ML-KEM-768 encapsulation key followed by the X25519 public key
+	HybridPublicKeyPEMType = "MLKEM768X25519 PUBLIC KEY"
+
+	// HybridPrivateKeyPEMType is the PEM block type of a hybrid private key,
+	// the ML-KEM-768 seed followed by the X25519 private key
+	HybridPrivateKeyPEMType = "MLKEM768X25519 PRIVATE KEY"
+
+	x25519KeySize = 32
+)
+
+// HybridPublicKey is an ML-KEM-768 and X25519 recipient public key
+type HybridPublicKey struct {
+	MLKEM  *mlkem.EncapsulationKey768
+	X25519 *ecdh.PublicKey
+}
+
+// HybridPrivateKey is an ML-KEM-768 and X25519 recipient private key
+type HybridPrivateKey struct {
+	MLKEM  *mlkem.DecapsulationKey768
+	X25519 *ecdh.PrivateKey
+}
+
+// GenerateHybridKey generates a new hybrid recipient key pair
+func GenerateHybridKey() (*HybridPrivateKey, error) {
+	mlkemKey, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, err
+	}
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &HybridPrivateKey{MLKEM: mlkemKey, X25519: x25519Key}, nil
+}
+
+// Public returns the public half of the key pair
+func (key *HybridPrivateKey) Public() *HybridPublicKey {
+	return &HybridPublicKey{
+		MLKEM:  key.MLKEM.EncapsulationKey(),
+		X25519: key.X25519.PublicKey(),
+	}
+}
+
+// Bytes encodes the private key as the ML-KEM seed and X25519 private key
+func (key *HybridPrivateKey) Bytes() []byte {
+	return append(key.MLKEM.Bytes(), key.X25519.Bytes()...)
+}
+
+// Bytes encodes the public key as the ML-KEM encapsulation key and X25519
+// public key
+func (pub *HybridPublicKey) Bytes() []byte {
+	return append(pub.MLKEM.Bytes(), pub.X25519.Bytes()...)
+}
+
+// ParseHybridPublicKey decodes a public key encoded by HybridPublicKey.Bytes
+func ParseHybridPublicKey(b []byte) (*HybridPublicKey, error) {
+	if len(b) != mlkem.EncapsulationKeySize768+x25519KeySize {
+		return nil, fmt.Errorf("invalid hybrid public key length %d", len(b))
+	}
+	mlkemKey, err := mlkem.NewEncapsulationKey768(b[:mlkem.EncapsulationKeySize768])
+	if err != nil {
+		return nil, err
+	}
+	x25519Key, err := ecdh.X25519().NewPublicKey(b[mlkem.EncapsulationKeySize768:])
+	if err != nil {
+		return nil, err
+	}
+	return &HybridPublicKey{MLKEM: mlkemKey, X25519: x25519Key}, nil
+}
+
+// ParseHybridPrivateKey decodes a private key encoded by HybridPrivateKey.Bytes
+func ParseHybridPrivateKey(b []byte) (*HybridPrivateKey, error) {
+	if len(b) != mlkem.SeedSize+x25519KeySize {
+		return nil, fmt.Errorf("invalid hybrid private key length %d", len(b))
+	}
+	mlkemKey, err := mlkem.NewDecapsulationKey768(b[:mlkem.SeedSize])
+	if err != nil {
+		return nil, err
+	}
+	x25519Key, err := ecdh.X25519().NewPrivateKey(b[mlkem.SeedSize:])
+	if err != nil {
+		return nil, err
+	}
+	return &HybridPrivateKey{MLKEM: mlkemKey, X25519: x25519Key}, nil
+}
+
+// EncapsulateHybrid establishes a content key for a hybrid recipient.
+// ML-KEM-768 encapsulation and ephemeral X25519 agreement each produce a
+// shared secret, both are fed into HKDF-SHA256 to derive the content cipher's
+// key and nonce, so the payload remains secure unless both are broken. The
+// encapsulated key is
+//
+//	ML-KEM ciphertext | ephemeral X25519 public key
+func EncapsulateHybrid(pub *HybridPublicKey, cipherID string) ([]byte, cipher.AEAD, []byte, error) {
+	mlkemShared, mlkemCiphertext := pub.MLKEM.Encapsulate()
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	x25519Shared, err := ephemeral.ECDH(pub.X25519)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	aead, nonce, err := hybridAEAD(cipherID, mlkemShared, x25519Shared, mlkemCiphertext, ephemeralPub, pub.X25519.Bytes())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return append(mlkemCiphertext, ephemeralPub...), aead, nonce, nil
+}
+
+// DecapsulateHybrid reads a key encapsulated by EncapsulateHybrid from r
+func DecapsulateHybrid(key *HybridPrivateKey, cipherID string, r io.Reader) (cipher.AEAD, []byte, error) {
+	encapsulated := make([]byte, mlkem.CiphertextSize768+x25519KeySize)
+	if _, err := io.ReadFull(r, encapsulated); err != nil {
+		return nil, nil, truncated(err)
+	}
+	mlkemCiphertext := encapsulated[:mlkem.CiphertextSize768]
+	ephemeralBytes := encapsulated[mlkem.CiphertextSize768:]
+
+	mlkemShared, err := key.MLKEM.Decapsulate(mlkemCiphertext)
+	if err != nil {
+		return nil, nil, err
+	}
+	ephemeralPub, err := ecdh.X25519().NewPublicKey(ephemeralBytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ephemeral public key: %s", err)
+	}
+	x25519Shared, err := key.X25519.ECDH(ephemeralPub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return hybridAEAD(cipherID, mlkemShared, x25519Shared, mlkemCiphertext, ephemeralBytes, key.X25519.PublicKey().Bytes())
+}
+
+// hybridAEAD derives the content key from both shared secrets, salted with the
+// ML-KEM ciphertext and both X25519 public keys
+func hybridAEAD(cipherID string, mlkemShared []byte, x25519Shared []byte, mlkemCiphertext []byte, ephemeralPub []byte, recipientPub []byte) (cipher.AEAD, []byte, error) {
+	secret := append(append([]byte{}, mlkemShared...), x25519Shared...)
+	salt := append(append(append([]byte{}, mlkemCiphertext...), ephemeralPub...), recipientPub...)
+	return deriveAEAD(cipherID, secret, salt)
+}
@@ -0,0 +1,394 @@
+package envelope
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// JWE (RFC 7516) algorithms, RSA-OAEP-256 for RSA recipients and direct
+// ECDH-ES key agreement (RFC 7518 section 4.6) for P-256, P-384 and X25519
+// recipients, with A256GCM content encryption
+const (
+	JWEAlgRSAOAEP256 = "RSA-OAEP-256"
+	JWEAlgECDHES     = "ECDH-ES"
+	JWEEncA256GCM    = "A256GCM"
+	JWEZipDeflate    = "DEF"
+)
+
+// JWE is an encrypted JWE, which can be written in the compact or flattened
+// JSON serialisation
+type JWE struct {
+	Protected    string
+	EncryptedKey []byte
+	IV           []byte
+	Ciphertext   []byte
+	Tag          []byte
+	// Signature is the plugin's signature, see Sign, which only the
+	// flattened JSON serialisation carries
+	Signature string
+}
+
+// JWEJSON is the flattened JSON serialisation of a JWE
+type JWEJSON struct {
+	Protected    string                 `json:"protected"`
+	Unprotected  map[string]interface{} `json:"unprotected,omitempty"`
+	EncryptedKey string                 `json:"encrypted_key,omitempty"`
+	IV           string                 `json:"iv"`
+	Ciphertext   string                 `json:"ciphertext"`
+	Tag          string                 `json:"tag"`
+}
+
+var b64url = base64.RawURLEncoding
+
+// EncryptJWE encrypts plaintext to a recipient as a JWE, with kid and any
+// extra parameters in the protected header. With deflate set the plaintext is
+// compressed first ("zip": "DEF").
+func EncryptJWE(pub crypto.PublicKey, kid string, plaintext []byte, extra map[string]interface{}, deflate bool) (*JWE, error) {
+	header := map[string]interface{}{}
+	for k, v := range extra {
+		header[k] = v
+	}
+	header["enc"] = JWEEncA256GCM
+	if kid != "" {
+		header["kid"] = kid
+	}
+
+	var cek, encryptedKey []byte
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		header["alg"] = JWEAlgRSAOAEP256
+		cek = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, cek); err != nil {
+			return nil, err
+		}
+		var err error
+		encryptedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, cek, nil)
+		if err != nil {
+			return nil, err
+		}
+	case *ecdh.PublicKey:
+		header["alg"] = JWEAlgECDHES
+		ephemeral, err := pub.Curve().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := ephemeral.ECDH(pub)
+		if err != nil {
+			return nil, err
+		}
+		epk, err := ecdhJWK(ephemeral.PublicKey())
+		if err != nil {
+			return nil, err
+		}
+		header["epk"] = epk
+		cek = concatKDF(shared, JWEEncA256GCM)
+	default:
+		return nil, fmt.Errorf("jwe does not support %T recipients", pub)
+	}
+
+	if deflate {
+		header["zip"] = JWEZipDeflate
+		var compressed bytes.Buffer
+		w, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		plaintext = compressed.Bytes()
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	protected := b64url.EncodeToString(headerJSON)
+
+	aesgcm, err := jweGCM(cek)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aesgcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	sealed := aesgcm.Seal(nil, iv, plaintext, []byte(protected))
+	tagStart := len(sealed) - aesgcm.Overhead()
+
+	return &JWE{
+		Protected:    protected,
+		EncryptedKey: encryptedKey,
+		IV:           iv,
+		Ciphertext:   sealed[:tagStart],
+		Tag:          sealed[tagStart:],
+	}, nil
+}
+
+// Compact returns the JWE compact serialisation
+func (jwe *JWE) Compact() string {
+	return strings.Join([]string{
+		jwe.Protected,
+		b64url.EncodeToString(jwe.EncryptedKey),
+		b64url.EncodeToString(jwe.IV),
+		b64url.EncodeToString(jwe.Ciphertext),
+		b64url.EncodeToString(jwe.Tag),
+	}, ".")
+}
+
+// JSON returns the JWE flattened JSON serialisation
+func (jwe *JWE) JSON() *JWEJSON {
+	j := &JWEJSON{
+		Protected:    jwe.Protected,
+		EncryptedKey: b64url.EncodeToString(jwe.EncryptedKey),
+		IV:           b64url.EncodeToString(jwe.IV),
+		Ciphertext:   b64url.EncodeToString(jwe.Ciphertext),
+		Tag:          b64url.EncodeToString(jwe.Tag),
+	}
+	if jwe.Signature != "" {
+		j.Unprotected = map[string]interface{}{jweSignatureParameter: jwe.Signature}
+	}
+	return j
+}
+
+// ParseJWE parses the compact or flattened JSON serialisation of a JWE
+func ParseJWE(serialised []byte) (*JWE, error) {
+	serialised = bytes.TrimSpace(serialised)
+	var parts []string
+	var signature string
+	if bytes.HasPrefix(serialised, []byte("{")) {
+		var j JWEJSON
+		if err := json.Unmarshal(serialised, &j); err != nil {
+			return nil, err
+		}
+		parts = []string{j.Protected, j.EncryptedKey, j.IV, j.Ciphertext, j.Tag}
+		signature, _ = j.Unprotected[jweSignatureParameter].(string)
+	} else {
+		parts = strings.Split(string(serialised), ".")
+		if len(parts) != 5 {
+			return nil, errors.New("jwe compact serialisation must have 5 parts")
+		}
+	}
+	if parts[0] == "" {
+		return nil, errors.New("jwe has no protected header")
+	}
+
+	jwe := &JWE{Protected: parts[0], Signature: signature}
+	fields := []*[]byte{&jwe.EncryptedKey, &jwe.IV, &jwe.Ciphertext, &jwe.Tag}
+	for i, field := range fields {
+		decoded, err := b64url.DecodeString(parts[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwe part %d: %s", i+2, err)
+		}
+		*field = decoded
+	}
+	return jwe, nil
+}
+
+// Header decodes the JWE's protected header
+func (jwe *JWE) Header() (map[string]interface{}, error) {
+	headerJSON, err := b64url.DecodeString(jwe.Protected)
+	if err != nil {
+		return nil, err
+	}
+	header := map[string]interface{}{}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// Headers returns the payload headers the JWE's protected header carries,
+// its payload id, expiry and signing key, and its signature
+func (jwe *JWE) Headers() (*Headers, error) {
+	header, err := jwe.Header()
+	if err != nil {
+		return nil, err
+	}
+	h := &Headers{Signature: jwe.Signature}
+	h.PayloadID, _ = header["payload_id"].(string)
+	h.SigningKey, _ = header[jweSigningKeyParameter].(string)
+	if exp, ok := header["exp"].(float64); ok {
+		h.Expires = time.Unix(int64(exp), 0).UTC().Format(time.RFC3339)
+	}
+	return h, nil
+}
+
+// Decrypt decrypts the JWE with the recipient's private key, refusing to
+// inflate a compressed payload beyond limit bytes
+func (jwe *JWE) Decrypt(key crypto.PrivateKey, limit int64) ([]byte, error) {
+	header, err := jwe.Header()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := header["crit"]; ok {
+		return nil, errors.New("jwe crit header parameters are not supported")
+	}
+	if header["enc"] != JWEEncA256GCM {
+		return nil, fmt.Errorf("unsupported jwe enc %v", header["enc"])
+	}
+
+	var cek []byte
+	switch header["alg"] {
+	case JWEAlgRSAOAEP256:
+		rsaKey, ok := IsRSADecrypter(key)
+		if !ok {
+			return nil, errors.New("jwe requires an RSA private key")
+		}
+		cek, err = rsaKey.Decrypt(rand.Reader, jwe.EncryptedKey, &rsa.OAEPOptions{Hash: crypto.SHA256})
+		if err != nil {
+			return nil, err
+		}
+	case JWEAlgECDHES:
+		if len(jwe.EncryptedKey) != 0 {
+			return nil, errors.New("ECDH-ES jwe must not have an encrypted key")
+		}
+		ecKey, err := ECDHPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		epk, err := parseECDHJWK(header["epk"], ecKey.Curve())
+		if err != nil {
+			return nil, err
+		}
+		shared, err := ecKey.ECDH(epk)
+		if err != nil {
+			return nil, err
+		}
+		cek = concatKDF(shared, JWEEncA256GCM)
+	default:
+		return nil, fmt.Errorf("unsupported jwe alg %v", header["alg"])
+	}
+
+	aesgcm, err := jweGCM(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(jwe.IV) != aesgcm.NonceSize() || len(jwe.Tag) != aesgcm.Overhead() {
+		return nil, errors.New("invalid jwe iv or tag length")
+	}
+	sealed := append(append([]byte{}, jwe.Ciphertext...), jwe.Tag...)
+	plaintext, err := aesgcm.Open(nil, jwe.IV, sealed, []byte(jwe.Protected))
+	if err != nil {
+		return nil, err
+	}
+
+	switch header["zip"] {
+	case nil:
+		return plaintext, nil
+	case JWEZipDeflate:
+		inflated, err := io.ReadAll(&limitReader{r: flate.NewReader(bytes.NewReader(plaintext)), remaining: limit})
+		if err != nil {
+			return nil, err
+		}
+		return inflated, nil
+	}
+	return nil, fmt.Errorf("unsupported jwe zip %v", header["zip"])
+}
+
+func jweGCM(cek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// concatKDF derives the ECDH-ES content key (RFC 7518 section 4.6.2) with no
+// PartyUInfo or PartyVInfo, a 256 bit key only needs a single SHA-256 round
+func concatKDF(shared []byte, enc string) []byte {
+	h := sha256.New()
+	var u32 [4]byte
+	binary.BigEndian.PutUint32(u32[:], 1)
+	h.Write(u32[:])
+	h.Write(shared)
+	binary.BigEndian.PutUint32(u32[:], uint32(len(enc)))
+	h.Write(u32[:])
+	h.Write([]byte(enc))
+	binary.BigEndian.PutUint32(u32[:], 0)
+	h.Write(u32[:]) // PartyUInfo
+	h.Write(u32[:]) // PartyVInfo
+	binary.BigEndian.PutUint32(u32[:], 256)
+	h.Write(u32[:]) // SuppPubInfo, the key length in bits
+	return h.Sum(nil)
+}
+
+// ecdhJWK encodes an ECDH public key as a JWK, EC for the NIST curves and OKP
+// (RFC 8037) for X25519
+func ecdhJWK(pub *ecdh.PublicKey) (map[string]interface{}, error) {
+	crv := fmt.Sprint(pub.Curve())
+	if pub.Curve() == ecdh.X25519() {
+		return map[string]interface{}{
+			"kty": "OKP",
+			"crv": crv,
+			"x":   b64url.EncodeToString(pub.Bytes()),
+		}, nil
+	}
+
+	// uncompressed point, 0x04 | x | y
+	point := pub.Bytes()
+	size := (len(point) - 1) / 2
+	return map[string]interface{}{
+		"kty": "EC",
+		"crv": crv,
+		"x":   b64url.EncodeToString(point[1 : 1+size]),
+		"y":   b64url.EncodeToString(point[1+size:]),
+	}, nil
+}
+
+// parseECDHJWK decodes an epk JWK, which must be on the recipient's curve
+func parseECDHJWK(raw interface{}, curve ecdh.Curve) (*ecdh.PublicKey, error) {
+	jwk, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("ECDH-ES jwe has no epk")
+	}
+	if jwk["crv"] != fmt.Sprint(curve) {
+		return nil, fmt.Errorf("jwe epk curve %v does not match the %s private key", jwk["crv"], curve)
+	}
+
+	coordinate := func(name string) ([]byte, error) {
+		s, _ := jwk[name].(string)
+		return b64url.DecodeString(s)
+	}
+	x, err := coordinate("x")
+	if err != nil {
+		return nil, err
+	}
+	if curve == ecdh.X25519() {
+		if jwk["kty"] != "OKP" {
+			return nil, errors.New("X25519 epk must be an OKP key")
+		}
+		return curve.NewPublicKey(x)
+	}
+
+	if jwk["kty"] != "EC" {
+		return nil, errors.New("epk must be an EC key")
+	}
+	y, err := coordinate("y")
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != len(y) {
+		return nil, errors.New("invalid epk coordinates")
+	}
+	point := append(append([]byte{4}, x...), y...)
+	return curve.NewPublicKey(point)
+}
@@ -0,0 +1,170 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+)
+
+// ProofNamespace is the SSH signature namespace of proof-of-possession
+// signatures (ssh-keygen -Y sign -n vault-e2e)
+const ProofNamespace = "vault-e2e"
+
+// ErrCannotSign is returned for proofs of possession of keys that can only
+// agree or decapsulate keys (X25519, ML-KEM-768+X25519 and age X25519)
+var ErrCannotSign = errors.New("key type cannot sign, so cannot prove possession")
+
+// VerifyCSR verifies a PKCS#10 CERTIFICATE REQUEST's self signature, and
+// that its subject common name or a DNS subject alternative name is name, so
+// a request made for one enrolement cannot be replayed for another. It
+// returns the request's public key as a PEM PUBLIC KEY.
+func VerifyCSR(csrPem string, name string) (string, error) {
+	block, _ := pem.Decode([]byte(csrPem))
+	if block == nil || (block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST") {
+		return "", errors.New("failed to decode PEM block containing certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return "", err
+	}
+	named := csr.Subject.CommonName == name
+	for _, dnsName := range csr.DNSNames {
+		named = named || dnsName == name
+	}
+	if !named {
+		return "", fmt.Errorf("certificate request is for %q, not %q", csr.Subject.CommonName, name)
+	}
+	pkixBytes, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkixBytes})), nil
+}
+
+// VerifyProof verifies a signature over message by a recipient's key, as
+// returned by ParsePublicKey. RSA keys sign with PKCS#1 v1.5 or PSS, and
+// ECDSA keys with ASN.1 DER signatures, over SHA-256, base64 encoded (as
+// "openssl dgst -sha256 -sign key.pem | base64" writes). SSH keys sign with
+// an armoured SSH SIGNATURE in the ProofNamespace, and OpenPGP keys with an
+// armoured detached signature.
+func VerifyProof(pub crypto.PublicKey, keyType string, encoded []byte, message []byte, signature string) error {
+	switch {
+	case keyType == OpenPGPKeyType:
+		_, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{pub.(*openpgp.Entity)}, bytes.NewReader(message), strings.NewReader(signature), nil)
+		return err
+	case strings.HasPrefix(keyType, "ssh-"):
+		sshPub, err := ssh.ParsePublicKey(encoded)
+		if err != nil {
+			return err
+		}
+		return verifySSHSignature(sshPub, message, signature)
+	case keyType == "rsa", keyType == "P-256", keyType == "P-384":
+	default:
+		return ErrCannotSign
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return errors.New("signature is not base64")
+	}
+	digest := sha256.Sum256(message)
+	if rsaPub, ok := pub.(*rsa.PublicKey); ok {
+		if err := rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, digest[:], sig); err == nil {
+			return nil
+		}
+		return rsa.VerifyPSS(rsaPub, crypto.SHA256, digest[:], sig, nil)
+	}
+
+	// ParsePublicKey converts EC keys for key agreement
+	parsed, err := x509.ParsePKIXPublicKey(encoded)
+	if err != nil {
+		return err
+	}
+	ecdsaPub, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return ErrCannotSign
+	}
+	if !ecdsa.VerifyASN1(ecdsaPub, digest[:], sig) {
+		return errors.New("ecdsa signature verification failed")
+	}
+	return nil
+}
+
+// sshSignature is the SSHSIG signature blob (OpenSSH PROTOCOL.sshsig)
+type sshSignature struct {
+	Magic         [6]byte
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSignedData is what an SSHSIG signature signs
+type sshSignedData struct {
+	Magic         [6]byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// verifySSHSignature verifies an armoured SSH SIGNATURE over message by pub
+func verifySSHSignature(pub ssh.PublicKey, message []byte, armoured string) error {
+	block, _ := pem.Decode([]byte(armoured))
+	if block == nil || block.Type != "SSH SIGNATURE" {
+		return errors.New("failed to decode SSH SIGNATURE")
+	}
+	var sig sshSignature
+	if err := ssh.Unmarshal(block.Bytes, &sig); err != nil {
+		return err
+	}
+	if string(sig.Magic[:]) != "SSHSIG" || sig.Version != 1 {
+		return errors.New("unsupported SSH SIGNATURE")
+	}
+	if sig.Namespace != ProofNamespace {
+		return fmt.Errorf("SSH SIGNATURE namespace is %q, not %q", sig.Namespace, ProofNamespace)
+	}
+	if !bytes.Equal(sig.PublicKey, pub.Marshal()) {
+		return errors.New("SSH SIGNATURE is by another key")
+	}
+
+	var hash []byte
+	switch sig.HashAlgorithm {
+	case "sha256":
+		sum := sha256.Sum256(message)
+		hash = sum[:]
+	case "sha512":
+		sum := sha512.Sum512(message)
+		hash = sum[:]
+	default:
+		return fmt.Errorf("unsupported SSH SIGNATURE hash %q", sig.HashAlgorithm)
+	}
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &signature); err != nil {
+		return err
+	}
+	signed := ssh.Marshal(sshSignedData{
+		Magic:         sig.Magic,
+		Namespace:     sig.Namespace,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          hash,
+	})
+	return pub.Verify(signed, &signature)
+}
@@ -0,0 +1,64 @@
+package envelope
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// ParsePublicKey decodes a recipient's PEM public key, returning the key, its
+// type (rsa, P-256, P-384, X25519 or ML-KEM-768+X25519) and the encoded key
+// for fingerprinting. age and SSH recipients return an age.Recipient, and
+// OpenPGP keys an *openpgp.Entity.
+func ParsePublicKey(pubKeyPem string) (crypto.PublicKey, string, []byte, error) {
+	if IsAgeRecipient(pubKeyPem) {
+		return ParseAgeRecipient(pubKeyPem)
+	}
+	if IsOpenPGPPublicKey(pubKeyPem) {
+		entity, err := ParseOpenPGPPublicKey(pubKeyPem)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return entity, OpenPGPKeyType, entity.PrimaryKey.Fingerprint, nil
+	}
+
+	// https://golang.org/pkg/encoding/pem/#Decode
+	pblock, _ := pem.Decode([]byte(pubKeyPem))
+	if pblock != nil && pblock.Type == HybridPublicKeyPEMType {
+		hybridPub, err := ParseHybridPublicKey(pblock.Bytes)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return hybridPub, HybridKeyType, pblock.Bytes, nil
+	}
+	if pblock == nil || pblock.Type != "PUBLIC KEY" {
+		return nil, "", nil, errors.New("failed to decode PEM block containing public key")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(pblock.Bytes)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		return pub, "rsa", pblock.Bytes, nil
+	}
+
+	ecPub, err := ECDHPublicKey(pub)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return ecPub, fmt.Sprint(ecPub.Curve()), pblock.Bytes, nil
+}
+
+// Fingerprint of an encoded public key, from ParsePublicKey, as lower case hex
+// SHA-256
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
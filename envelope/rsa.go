@@ -0,0 +1,77 @@
+package envelope
+
+import (
+	"crypto"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// EncapsulateRSA establishes a random content key for an RSA recipient,
+// RSA-OAEP encrypting the key and nonce. The encapsulated key is
+//
+//	RSA ciphertext length (uint16, little endian) | RSA ciphertext
+func EncapsulateRSA(pub *rsa.PublicKey, cipherID string) ([]byte, cipher.AEAD, []byte, error) {
+	// Generate random key
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// see https://golang.org/pkg/crypto/cipher/#example_NewGCM_encrypt
+	aead, err := NewAEAD(cipherID, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Generate nonce/iv
+	// Never use more than 2^32 random nonces with a given key because of the risk of a repeat.
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// encrypt the above key using RSA public key
+	// https://golang.org/pkg/crypto/rsa/#EncryptOAEP
+	keyNonce := append(append([]byte{}, key...), nonce...)
+	rsaCiphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, keyNonce, []byte(Label))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	encapsulated := make([]byte, 2)
+	binary.LittleEndian.PutUint16(encapsulated, uint16(len(rsaCiphertext)))
+	encapsulated = append(encapsulated, rsaCiphertext...)
+	return encapsulated, aead, nonce, nil
+}
+
+// DecapsulateRSA reads a key encapsulated by EncapsulateRSA from r. The key
+// is an *rsa.PrivateKey or any other crypto.Decrypter of an RSA key that
+// supports OAEP.
+func DecapsulateRSA(key crypto.Decrypter, cipherID string, r io.Reader) (cipher.AEAD, []byte, error) {
+	rsaCiphertext, err := readPrefixed(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyNonce, err := key.Decrypt(rand.Reader, rsaCiphertext, &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte(Label)})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(keyNonce) < 32 {
+		return nil, nil, errors.New("rsa encapsulated key is too short")
+	}
+
+	aead, err := NewAEAD(cipherID, keyNonce[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(keyNonce) != 32+aead.NonceSize() {
+		return nil, nil, errors.New("rsa encapsulated nonce does not match the cipher")
+	}
+	return aead, keyNonce[32:], nil
+}
@@ -0,0 +1,125 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto"
+	"time"
+)
+
+// SealOptions are a recipient's payload options
+type SealOptions struct {
+	// Cipher is the content cipher, empty for AES-256-GCM
+	Cipher string
+	// Compression is applied before encryption, empty or NONE for none
+	Compression string
+	// Stream encrypts the payload as STREAM chunks
+	Stream bool
+	// PayloadID is the id of a leased payload
+	PayloadID string
+	// Expires is when the payload expires, zero if it does not
+	Expires time.Time
+	// SigningKey signs the payload, nil to leave it unsigned
+	SigningKey *SigningKey
+}
+
+// Seal encrypts a payload for the E2E armour or binary container, returning
+// the authenticated headers and the body. keyType is the recipient's key type
+// from ParsePublicKey. Signed payloads are signed over their headers and
+// body, so the payload cannot be replaced by one encrypted to the recipient's
+// public key by anyone else.
+func Seal(pub crypto.PublicKey, keyType string, plaintext []byte, opts *SealOptions) (*Headers, []byte, error) {
+	cipherID := opts.Cipher
+	if cipherID == "" {
+		cipherID = CipherAES256GCM
+	}
+
+	// Headers after PAYLOAD_VERSION are authenticated. Elliptic curve
+	// recipients use ephemeral ECDH key agreement (PAYLOAD_VERSION 3.0) and
+	// hybrid recipients add ML-KEM-768 encapsulation (PAYLOAD_VERSION 4.0),
+	// with the key type in the headers. RSA payloads with any headers are
+	// PAYLOAD_VERSION 2.1.
+	headers := &Headers{
+		Version:   Version30,
+		PayloadID: opts.PayloadID,
+	}
+	switch keyType {
+	case "rsa":
+		headers.Version = Version20
+	case HybridKeyType:
+		headers.Version = Version40
+	}
+	if keyType != "rsa" {
+		headers.KeyAgreement = keyType
+	}
+	if cipherID != CipherAES256GCM {
+		headers.Cipher = cipherID
+	}
+	compression := opts.Compression
+	if compression == "" {
+		compression = CompressionNone
+	}
+	if compression != CompressionNone {
+		headers.Compression = compression
+	}
+	if opts.Stream {
+		headers.ChunkSize = DefaultChunkSize
+	}
+	if !opts.Expires.IsZero() {
+		headers.Expires = opts.Expires.UTC().Format(time.RFC3339)
+	}
+	if opts.SigningKey != nil {
+		headers.SigningKey = opts.SigningKey.ID
+	}
+	if headers.Version == Version20 && len(headers.Lines()) > 1 {
+		headers.Version = Version21
+	}
+	if err := headers.Validate(); err != nil {
+		return nil, nil, err
+	}
+	additionalData := headers.AdditionalData()
+
+	// Compress before encryption only when the recipient opted in, as the
+	// caller controls part of the payload and compressed sizes can leak the
+	// secrets alongside it (CRIME)
+	if compression != CompressionNone {
+		var compressed bytes.Buffer
+		w, err := NewCompressor(compression, &compressed)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			return nil, nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, nil, err
+		}
+		plaintext = compressed.Bytes()
+	}
+
+	// establish the content key for the recipient, then encrypt the payload
+	// in one go or as STREAM chunks
+	encapsulated, aead, nonce, err := Encapsulate(pub, cipherID)
+	if err != nil {
+		return nil, nil, err
+	}
+	combined := bytes.NewBuffer(encapsulated)
+	if opts.Stream {
+		w, err := NewStreamWriter(combined, aead, nonce, additionalData, DefaultChunkSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			return nil, nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		combined.Write(aead.Seal(nil, nonce, plaintext, additionalData))
+	}
+
+	if opts.SigningKey != nil {
+		signPayload(opts.SigningKey, headers, combined.Bytes())
+	}
+	return headers, combined.Bytes(), nil
+}
@@ -0,0 +1,218 @@
+package envelope
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// SignatureLabel prefixes the data a payload signature signs, so it cannot be
+// confused with any other Ed25519 signature by the same key
+const SignatureLabel = "Vault E2E Payload Signature\n"
+
+// jweSignatureParameter carries a JWE's signature in the flattened JSON
+// serialisation's unprotected header, and jweSigningKeyParameter the signing
+// key id in its protected header
+const (
+	jweSignatureParameter  = "e2e_signature"
+	jweSigningKeyParameter = "e2e_signing_key"
+)
+
+// ErrUnsigned is returned when a payload must be signed but is not
+var ErrUnsigned = errors.New("payload is not signed")
+
+// SignatureError is returned for a payload whose signature does not verify
+type SignatureError struct {
+	SigningKey string
+	Reason     string
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("payload signature by %s: %s", e.SigningKey, e.Reason)
+}
+
+// SigningKey is the plugin's Ed25519 payload signing key
+type SigningKey struct {
+	// ID is the Fingerprint of the PKIX public key, the payloads'
+	// SIGNING_KEY
+	ID  string
+	Key ed25519.PrivateKey
+}
+
+// NewSigningKey generates a signing key
+func NewSigningKey() (*SigningKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(key)
+}
+
+func newSigningKey(key ed25519.PrivateKey) (*SigningKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: Fingerprint(der), Key: key}, nil
+}
+
+// MarshalSigningKey encodes a signing key as a PKCS#8 PEM PRIVATE KEY
+func MarshalSigningKey(key *SigningKey) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParseSigningKey decodes a PKCS#8 PEM Ed25519 PRIVATE KEY
+func ParseSigningKey(keyPem string) (*SigningKey, error) {
+	block, _ := pem.Decode([]byte(keyPem))
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("failed to decode PEM block containing signing key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key must be Ed25519, not %T", parsed)
+	}
+	return newSigningKey(key)
+}
+
+// PublicKeyPEM returns the signing key's PEM PUBLIC KEY, which recipients
+// verify payloads with
+func (key *SigningKey) PublicKeyPEM() (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key.Key.Public())
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// ParseVerifyingKey decodes a signing key's PEM PUBLIC KEY, returning it and
+// its key id
+func ParseVerifyingKey(pubPem string) (ed25519.PublicKey, string, error) {
+	block, _ := pem.Decode([]byte(pubPem))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, "", errors.New("failed to decode PEM block containing signing public key")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, "", err
+	}
+	pub, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, "", fmt.Errorf("signing public key must be Ed25519, not %T", parsed)
+	}
+	return pub, Fingerprint(block.Bytes), nil
+}
+
+// signedData is what an E2E payload's signature signs, the label, the header
+// lines before SIGNATURE and the SHA-256 digest of the body (the encapsulated
+// key and ciphertext). Signing the digest lets the body be verified as it is
+// read, so STREAM payloads need not be read whole.
+func signedData(h *Headers, bodyDigest []byte) []byte {
+	lines := h.Lines()
+	if h.Signature != "" {
+		lines = lines[:len(lines)-1]
+	}
+	data := []byte(SignatureLabel + strings.Join(lines, "\n") + "\n\n")
+	return append(data, bodyDigest...)
+}
+
+// signPayload sets the SIGNATURE of an E2E payload, whose SIGNING_KEY is
+// already the key's id
+func signPayload(key *SigningKey, h *Headers, body []byte) {
+	digest := sha256.Sum256(body)
+	h.Signature = ""
+	h.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key.Key, signedData(h, digest[:])))
+}
+
+// NewVerifyingReader returns a reader of an E2E payload's body that verifies
+// the payload's signature by the signing key pub with id keyID as the body is
+// read. At the end of the body it returns a *SignatureError rather than
+// io.EOF if the signature does not verify, so a STREAM reader of it fails
+// before returning the last chunk. It returns ErrUnsigned if the payload is
+// not signed, and a *SignatureError if it is signed by another key.
+func NewVerifyingReader(pub ed25519.PublicKey, keyID string, h *Headers, body io.Reader) (io.Reader, error) {
+	if h.Signature == "" {
+		return nil, ErrUnsigned
+	}
+	if h.SigningKey != keyID {
+		return nil, &SignatureError{SigningKey: h.SigningKey, Reason: "not signed by signing key " + keyID}
+	}
+	return &verifyingReader{r: body, pub: pub, keyID: keyID, h: h, digest: sha256.New()}, nil
+}
+
+type verifyingReader struct {
+	r      io.Reader
+	pub    ed25519.PublicKey
+	keyID  string
+	h      *Headers
+	digest hash.Hash
+	err    error
+}
+
+func (vr *verifyingReader) Read(p []byte) (int, error) {
+	if vr.err != nil {
+		return 0, vr.err
+	}
+	n, err := vr.r.Read(p)
+	vr.digest.Write(p[:n])
+	if err == io.EOF {
+		err = verifySignature(vr.pub, vr.keyID, vr.h.SigningKey, vr.h.Signature, signedData(vr.h, vr.digest.Sum(nil)))
+		if err == nil {
+			err = io.EOF
+		}
+	}
+	if err != nil {
+		vr.err = err
+	}
+	return n, err
+}
+
+// Sign signs the JWE, whose protected header already carries the key's id as
+// e2e_signing_key. The signature is over its compact serialisation, and is
+// only carried by the flattened JSON serialisation.
+func (jwe *JWE) Sign(key *SigningKey) {
+	jwe.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key.Key, []byte(SignatureLabel+jwe.Compact())))
+}
+
+// Verify verifies the JWE's signature by the signing key pub with id keyID,
+// returning ErrUnsigned if it is not signed
+func (jwe *JWE) Verify(pub ed25519.PublicKey, keyID string) error {
+	if jwe.Signature == "" {
+		return ErrUnsigned
+	}
+	header, err := jwe.Header()
+	if err != nil {
+		return err
+	}
+	signingKey, _ := header[jweSigningKeyParameter].(string)
+	return verifySignature(pub, keyID, signingKey, jwe.Signature, []byte(SignatureLabel+jwe.Compact()))
+}
+
+func verifySignature(pub ed25519.PublicKey, keyID string, signingKey string, signature string, data []byte) error {
+	if signingKey != keyID {
+		return &SignatureError{SigningKey: signingKey, Reason: "not signed by signing key " + keyID}
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return &SignatureError{SigningKey: signingKey, Reason: "signature is not base64"}
+	}
+	if !ed25519.Verify(pub, data, sig) {
+		return &SignatureError{SigningKey: signingKey, Reason: "verification failed"}
+	}
+	return nil
+}
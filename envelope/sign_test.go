@@ -0,0 +1,111 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+func TestVerifyingReaderStream(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey, err := NewSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := NewSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := signingKey.Key.Public().(ed25519.PublicKey)
+	plaintext := bytes.Repeat([]byte{4}, 3*DefaultChunkSize+10)
+	h, body, err := Seal(&key.PublicKey, "rsa", plaintext, &SealOptions{Stream: true, SigningKey: signingKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// open decrypts the body through a verifying reader, returning the
+	// plaintext read before any error
+	open := func(h *Headers, body io.Reader) ([]byte, error) {
+		verified, err := NewVerifyingReader(pub, signingKey.ID, h, body)
+		if err != nil {
+			return nil, err
+		}
+		aead, nonce, err := Decapsulate(key, h.Cipher, verified)
+		if err != nil {
+			return nil, err
+		}
+		sr, err := NewStreamReader(verified, aead, nonce, h.AdditionalData(), h.ChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(sr)
+	}
+
+	// the first chunk is returned before the rest of the body is read
+	counted := &countingReader{r: bytes.NewReader(body)}
+	verified, err := NewVerifyingReader(pub, signingKey.ID, h, counted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, nonce, err := Decapsulate(key, h.Cipher, verified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := NewStreamReader(verified, aead, nonce, h.AdditionalData(), h.ChunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sr.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if counted.n >= len(body) {
+		t.Errorf("read %d of %d body bytes for the first chunk", counted.n, len(body))
+	}
+
+	opened, err := open(h, bytes.NewReader(body))
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("opened %d bytes: %v", len(opened), err)
+	}
+
+	// a bad signature fails before the last chunk is returned
+	forged := *h
+	forged.Signature = base64.StdEncoding.EncodeToString(make([]byte, 64))
+	opened, err = open(&forged, bytes.NewReader(body))
+	if _, ok := err.(*SignatureError); !ok {
+		t.Errorf("forged signature: %v", err)
+	}
+	if len(opened) != 3*DefaultChunkSize {
+		t.Errorf("forged signature: %d bytes returned", len(opened))
+	}
+
+	unsigned := *h
+	unsigned.SigningKey, unsigned.Signature = "", ""
+	if _, err := open(&unsigned, bytes.NewReader(body)); err != ErrUnsigned {
+		t.Errorf("unsigned: %v", err)
+	}
+	other := *h
+	other.SigningKey = otherKey.ID
+	if _, err := open(&other, bytes.NewReader(body)); err == nil {
+		t.Error("payload signed by another key verified")
+	}
+}
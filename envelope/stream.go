@@ -0,0 +1,216 @@
+package envelope
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Chunk sizes for STREAM segmented payloads, the chunk size is recorded in the
+// authenticated STREAM armour header
+const (
+	DefaultChunkSize = 64 * 1024
+	MinChunkSize     = 1024
+	MaxChunkSize     = 16 * 1024 * 1024
+)
+
+// ErrTruncated is returned when a STREAM payload ends before its final chunk,
+// or its chunks have been dropped, reordered or appended to
+var ErrTruncated = errors.New("stream payload is truncated")
+
+// ValidChunkSize reports whether size is an acceptable STREAM chunk size
+func ValidChunkSize(size int) bool {
+	return size >= MinChunkSize && size <= MaxChunkSize
+}
+
+// streamNonce builds the STREAM nonce for a chunk,
+//
+//	nonce prefix | chunk counter (uint32, big endian) | last chunk flag
+//
+// the prefix being the content nonce less its last 5 bytes. The counter stops
+// chunks being reordered or dropped, and the flag stops the payload being
+// truncated at a chunk boundary.
+func streamNonce(base []byte, counter uint32, last bool) []byte {
+	nonce := append([]byte{}, base...)
+	binary.BigEndian.PutUint32(nonce[len(nonce)-5:], counter)
+	nonce[len(nonce)-1] = 0
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+type streamWriter struct {
+	w              io.Writer
+	aead           cipher.AEAD
+	nonce          []byte
+	additionalData []byte
+	chunkSize      int
+	buf            []byte
+	counter        uint32
+	closed         bool
+}
+
+// NewStreamWriter returns a writer encrypting to w as STREAM chunks of
+// chunkSize plaintext bytes, each sealed with additionalData. Close must be
+// called to write the final chunk.
+func NewStreamWriter(w io.Writer, aead cipher.AEAD, nonce []byte, additionalData []byte, chunkSize int) (io.WriteCloser, error) {
+	if !ValidChunkSize(chunkSize) {
+		return nil, fmt.Errorf("invalid stream chunk size %d", chunkSize)
+	}
+	return &streamWriter{
+		w:              w,
+		aead:           aead,
+		nonce:          nonce,
+		additionalData: additionalData,
+		chunkSize:      chunkSize,
+		buf:            make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, errors.New("write to closed stream")
+	}
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only flushed once more data follows, as the last
+		// chunk must carry the final flag
+		if len(sw.buf) == sw.chunkSize {
+			if err := sw.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(sw.buf[len(sw.buf):sw.chunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close writes the final chunk, it does not close the underlying writer
+func (sw *streamWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	return sw.flush(true)
+}
+
+func (sw *streamWriter) flush(last bool) error {
+	if sw.counter == ^uint32(0) && !last {
+		return errors.New("stream payload has too many chunks")
+	}
+	sealed := sw.aead.Seal(nil, streamNonce(sw.nonce, sw.counter, last), sw.buf, sw.additionalData)
+	if _, err := sw.w.Write(sealed); err != nil {
+		return err
+	}
+	sw.buf = sw.buf[:0]
+	sw.counter++
+	return nil
+}
+
+type streamReader struct {
+	r              io.Reader
+	aead           cipher.AEAD
+	nonce          []byte
+	additionalData []byte
+	sealed         []byte
+	plaintext      []byte
+	counter        uint32
+	done           bool
+}
+
+// NewStreamReader returns a reader decrypting the STREAM chunks read from r.
+// Each chunk is authenticated before it is returned, and ErrTruncated is
+// returned if r ends before the final chunk.
+func NewStreamReader(r io.Reader, aead cipher.AEAD, nonce []byte, additionalData []byte, chunkSize int) (io.Reader, error) {
+	if !ValidChunkSize(chunkSize) {
+		return nil, fmt.Errorf("invalid stream chunk size %d", chunkSize)
+	}
+	return &streamReader{
+		r:              r,
+		aead:           aead,
+		nonce:          nonce,
+		additionalData: additionalData,
+		// one byte more than a sealed chunk, to see if another chunk follows
+		sealed: make([]byte, 0, chunkSize+aead.Overhead()+1),
+	}, nil
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.plaintext) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		if err := sr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, sr.plaintext)
+	sr.plaintext = sr.plaintext[n:]
+	return n, nil
+}
+
+// next reads and opens the next chunk, which is the last if no byte follows
+// it
+func (sr *streamReader) next() error {
+	chunkLen := cap(sr.sealed) - 1
+	n, err := io.ReadFull(sr.r, sr.sealed[len(sr.sealed):cap(sr.sealed)])
+	sr.sealed = sr.sealed[:len(sr.sealed)+n]
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+
+	last := len(sr.sealed) <= chunkLen
+	if len(sr.sealed) < sr.aead.Overhead() {
+		return ErrTruncated
+	}
+	sealed := sr.sealed
+	if !last {
+		sealed = sr.sealed[:chunkLen]
+	}
+
+	plaintext, err := sr.aead.Open(nil, streamNonce(sr.nonce, sr.counter, last), sealed, sr.additionalData)
+	if err != nil {
+		if sr.misplaced(sealed, last) {
+			return ErrTruncated
+		}
+		return err
+	}
+	if !last && sr.counter == ^uint32(0) {
+		return errors.New("stream payload has too many chunks")
+	}
+
+	sr.plaintext = plaintext
+	sr.counter++
+	sr.done = last
+	if !last {
+		// keep the byte read ahead as the start of the next chunk
+		extra := sr.sealed[chunkLen]
+		sr.sealed = append(sr.sealed[:0], extra)
+	}
+	return nil
+}
+
+// misplaced reports whether a chunk that does not open in its position is a
+// genuine chunk out of place: one that only opens with the other final flag
+// (the payload was cut short, or chunks follow the final chunk), or the next
+// chunk (a chunk was dropped, or two were swapped)
+func (sr *streamReader) misplaced(sealed []byte, last bool) bool {
+	if _, err := sr.aead.Open(nil, streamNonce(sr.nonce, sr.counter, !last), sealed, sr.additionalData); err == nil {
+		return true
+	}
+	if sr.counter == ^uint32(0) {
+		return false
+	}
+	for _, final := range []bool{false, true} {
+		if _, err := sr.aead.Open(nil, streamNonce(sr.nonce, sr.counter+1, final), sealed, sr.additionalData); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+)
+
+// sealStream seals plaintext as STREAM chunks of MinChunkSize, returning
+// the sealed chunks
+func sealStream(t *testing.T, plaintext []byte) [][]byte {
+	aead, nonce := testStreamAEAD(t)
+	var sealed bytes.Buffer
+	sw, err := NewStreamWriter(&sealed, aead, nonce, []byte("PAYLOAD_VERSION: 2.1"), MinChunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sw.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks := [][]byte{}
+	chunkLen := MinChunkSize + aead.Overhead()
+	for b := sealed.Bytes(); len(b) > 0; {
+		n := chunkLen
+		if len(b) < n {
+			n = len(b)
+		}
+		chunks, b = append(chunks, b[:n]), b[n:]
+	}
+	return chunks
+}
+
+// testStreamAEAD returns the same AEAD and nonce on every call
+func testStreamAEAD(t *testing.T) (cipher.AEAD, []byte) {
+	aead, err := NewAEAD(CipherAES256GCM, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead, bytes.Repeat([]byte{2}, aead.NonceSize())
+}
+
+// openStream reads the STREAM payload made of chunks
+func openStream(t *testing.T, chunks [][]byte) ([]byte, error) {
+	aead, nonce := testStreamAEAD(t)
+	sr, err := NewStreamReader(bytes.NewReader(bytes.Join(chunks, nil)), aead, nonce, []byte("PAYLOAD_VERSION: 2.1"), MinChunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ioutil.ReadAll(sr)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, MinChunkSize, 3*MinChunkSize + 100} {
+		plaintext := make([]byte, size)
+		if _, err := rand.Read(plaintext); err != nil {
+			t.Fatal(err)
+		}
+		opened, err := openStream(t, sealStream(t, plaintext))
+		if err != nil {
+			t.Errorf("%d bytes: %s", size, err)
+		} else if !bytes.Equal(opened, plaintext) {
+			t.Errorf("%d bytes: opened to %d bytes", size, len(opened))
+		}
+	}
+}
+
+func TestStreamTampered(t *testing.T) {
+	// four full chunks, the last flagged final
+	plaintext := bytes.Repeat([]byte{3}, 4*MinChunkSize)
+	chunks := sealStream(t, plaintext)
+	if len(chunks) != 4 {
+		t.Fatalf("sealed %d chunks", len(chunks))
+	}
+
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{"last chunk dropped", []int{0, 1, 2}},
+		{"middle chunk dropped", []int{0, 2, 3}},
+		{"chunks reordered", []int{0, 2, 1, 3}},
+		{"final chunk moved", []int{0, 1, 3, 2}},
+		{"chunk appended", []int{0, 1, 2, 3, 1}},
+		{"final chunk repeated", []int{0, 1, 2, 3, 3}},
+	}
+	for _, test := range tests {
+		tampered := [][]byte{}
+		for _, i := range test.order {
+			tampered = append(tampered, chunks[i])
+		}
+		if _, err := openStream(t, tampered); err != ErrTruncated {
+			t.Errorf("%s: got %v, want ErrTruncated", test.name, err)
+		}
+	}
+}
+
+func TestStreamCorrupted(t *testing.T) {
+	chunks := sealStream(t, bytes.Repeat([]byte{3}, 2*MinChunkSize))
+	chunks[1] = append([]byte{}, chunks[1]...)
+	chunks[1][0] ^= 1
+
+	// an altered chunk is not a misplaced one
+	if _, err := openStream(t, chunks); err == nil || err == ErrTruncated {
+		t.Errorf("corrupted chunk: got %v", err)
+	}
+}
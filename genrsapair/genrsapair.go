@@ -1,74 +1,28 @@
 package main
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
 	"flag"
-	"fmt"
 	"log"
 	"os"
+
+	"gitlab.com/gbevan/vault-e2e-plugin/client"
 )
 
 /*
- * Generate a new 2048 bit RSA key pair to Stdout
+ * Generate a new key pair (2048 bit RSA by default, 3072 or 4096 bit RSA, a
+ * P-256, P-384 or X25519 key for ECDH recipients, an ML-KEM-768+X25519 hybrid
+ * key, or an Ed25519 signing key) to <prefix>_<type>.pem and
+ * <prefix>_<type>_pub.pem, optionally with the public key as a JWK in
+ * <prefix>_<type>_pub.jwk and the enrolement request body in
+ * <prefix>_<type>_enrole.json
  */
 func main() {
-	prefix := flag.String("prefix", "test/test_key", "folder/file prefix to generate key pair to")
+	keyFiles := &client.KeyFiles{}
+	keyFiles.RegisterFlags(flag.CommandLine, "test/test_key")
 	flag.Parse()
 
-	privKeyFilename := fmt.Sprintf("%s_rsa.pem", *prefix)
-	pubKeyFilename := fmt.Sprintf("%s_rsa_pub.pem", *prefix)
-
-	privKeyFile, err := os.OpenFile(privKeyFilename, os.O_RDWR|os.O_CREATE, 0600)
-	if err != nil {
-		panic(err)
-	}
-
-	pubKeyFile, err := os.OpenFile(pubKeyFilename, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
-		panic(err)
-	}
-
-	reader := rand.Reader
-	bitSize := 2048
-
-	key, err := rsa.GenerateKey(reader, bitSize)
-	checkError(err)
-
-	key.Precompute()
-	// http://golang.org/pkg/crypto/rsa/#PrivateKey.Validate
-	err = key.Validate()
+	_, err := keyFiles.Generate()
 	checkError(err)
-
-	publicKey := &key.PublicKey
-
-	// write PEM encoded private key
-	privateKey := &pem.Block{
-		Type:  "PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
-	}
-	pem.Encode(privKeyFile, privateKey)
-	if err = privKeyFile.Close(); err != nil {
-		panic(err)
-	}
-
-	fmt.Println()
-
-	// write PEM encoded public key
-	// asn1Bytes, err := asn1.Marshal(publicKey)
-	pkixBytes, err := x509.MarshalPKIXPublicKey(publicKey)
-	checkError(err)
-
-	pubKey := &pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: pkixBytes,
-	}
-	pem.Encode(pubKeyFile, pubKey)
-	if err := pubKeyFile.Close(); err != nil {
-		panic(err)
-	}
 }
 
 func checkError(err error) {
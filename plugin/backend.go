@@ -3,6 +3,7 @@ package e2e
 import (
 	"context"
 	"log"
+	"sync"
 
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
@@ -12,6 +13,12 @@ import (
 type E2eBackend struct { // nolint
 	*framework.Backend
 	view logical.Storage
+
+	// serialises creation and rotation of the kv keyring
+	keyringLock sync.RWMutex
+
+	// serialises creation of the payload signing key
+	signingKeyLock sync.RWMutex
 }
 
 // Factory returns a new backend as logical.Backend.
@@ -33,30 +40,42 @@ func Backend(ctx context.Context, conf *logical.BackendConfig) *E2eBackend {
 		Help:        "E2E Plugin",
 		BackendType: logical.TypeLogical,
 		//		AuthRenew:   backend.pathAuthRenew,
-		// PathsSpecial: &logical.Paths{
-		// 	Unauthenticated: []string{
-		// 		"jwt/validate/*",
-		// 		"jwks/*",
-		// 		"roles/jwks/*",
-		// 	},
-		// 	SealWrapStorage: []string{
-		// 		"keys/",
-		// 	},
-		// },
-		// Secrets: []*framework.Secret{
-		// 	secretJWT(backend),
-		// },
-		Secrets: []*framework.Secret{
-			&framework.Secret{
-				Type: "kv",
+		PathsSpecial: &logical.Paths{
+			// recipients fetch the revocation list and signing key without a
+			// vault token
+			Unauthenticated: []string{
+				"revocations",
+				"signingkey",
 			},
+			// sensitive key material and secrets are seal wrapped when Vault
+			// has a seal that supports it (e.g. HSM backed)
+			SealWrapStorage: []string{
+				"enrole/",
+				"kv/",
+				keyringStorageKey,
+				signingKeyStorageKey,
+			},
+			// no LocalStorage: the plugin keeps no per-cluster state
+			// (receipts, rate-limit counters, caches), everything it stores
+			// must replicate
+		},
+		Secrets: []*framework.Secret{
+			secretPayload(backend),
 		},
 		Paths: framework.PathAppend(
+			pathConfig(backend),
 			pathEnrole(backend),
+			pathPayloadInspect(backend),
 			pathPayload(backend),
 			pathKV(backend),
+			pathPasswordPolicy(backend),
+			pathSchema(backend),
+			pathKeyring(backend),
+			pathRevocations(backend),
+			pathSigningKey(backend),
 		),
-		WALRollback: rollback,
+		PeriodicFunc: backend.periodicTidy,
+		WALRollback:  rollback,
 	}
 
 	return backend
@@ -0,0 +1,164 @@
+package e2e
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// parseCertificates parses the CERTIFICATE blocks of a PEM bundle
+func parseCertificates(bundle string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(bundle)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("failed to decode PEM block containing certificate")
+	}
+	return certs, nil
+}
+
+// parseCRL parses a PEM X509 CRL and checks it is signed by one of the CA
+// certificates
+func parseCRL(crlPem string, cas []*x509.Certificate) (*x509.RevocationList, error) {
+	block, _ := pem.Decode([]byte(crlPem))
+	if block == nil || block.Type != "X509 CRL" {
+		return nil, errors.New("failed to decode PEM block containing X509 CRL")
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	for _, ca := range cas {
+		if bytes.Equal(ca.RawSubject, crl.RawIssuer) && crl.CheckSignatureFrom(ca) == nil {
+			return crl, nil
+		}
+	}
+	return nil, errors.New("crl is not signed by a trusted ca certificate")
+}
+
+// verifyCertificate verifies an enrolement's certificate, the leaf followed
+// by any intermediates, chains to one of the config's trusted CA
+// certificates, permits key encipherment (RSA) or key agreement (EC and
+// X25519), and has not been revoked, returning the leaf
+func verifyCertificate(config *E2eConfigEntry, certPem string, now time.Time) (*x509.Certificate, error) {
+	if config.CACertificates == "" {
+		return nil, errors.New("no trusted ca_certificates configured")
+	}
+	certs, err := parseCertificates(certPem)
+	if err != nil {
+		return nil, err
+	}
+	cas, err := parseCertificates(config.CACertificates)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := x509.NewCertPool()
+	for _, ca := range cas {
+		roots.AddCert(ca)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	leaf := certs[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, err
+	}
+
+	// a certificate without the key usage extension is not restricted
+	if leaf.KeyUsage != 0 {
+		switch leaf.PublicKey.(type) {
+		case *rsa.PublicKey:
+			if leaf.KeyUsage&x509.KeyUsageKeyEncipherment == 0 {
+				return nil, errors.New("certificate key usage does not permit key encipherment")
+			}
+		case *ecdsa.PublicKey, *ecdh.PublicKey:
+			if leaf.KeyUsage&x509.KeyUsageKeyAgreement == 0 {
+				return nil, errors.New("certificate key usage does not permit key agreement")
+			}
+		}
+	}
+
+	crl, err := loadCRL(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCertificateRevoked(config, crl, leaf, now); err != nil {
+		return nil, err
+	}
+	return leaf, nil
+}
+
+// loadCRL parses the config's CRL and checks it is signed by one of its CA
+// certificates, returning nil if no CRL is supplied
+func loadCRL(config *E2eConfigEntry) (*x509.RevocationList, error) {
+	if config.CRL == "" {
+		return nil, nil
+	}
+	cas, err := parseCertificates(config.CACertificates)
+	if err != nil {
+		return nil, err
+	}
+	return parseCRL(config.CRL, cas)
+}
+
+// checkCRLCurrent refuses a CRL past its NextUpdate, unless the config
+// allows stale CRLs, as it may not list certificates revoked since
+func checkCRLCurrent(config *E2eConfigEntry, crl *x509.RevocationList, now time.Time) error {
+	if config.AllowStaleCRL || crl.NextUpdate.IsZero() || !now.After(crl.NextUpdate) {
+		return nil
+	}
+	return fmt.Errorf("crl is stale, its next update was due at %s (write the CA's current crl, or set allow_stale_crl)", crl.NextUpdate.Format(time.RFC3339))
+}
+
+// checkCertificateRevoked checks a certificate against the CRL from loadCRL,
+// if one is supplied and the certificate's issuer issued it. A stale CRL
+// refuses the certificates it covers (see checkCRLCurrent).
+func checkCertificateRevoked(config *E2eConfigEntry, crl *x509.RevocationList, cert *x509.Certificate, now time.Time) error {
+	if crl == nil {
+		return nil
+	}
+	if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) {
+		return nil
+	}
+	if err := checkCRLCurrent(config, crl, now); err != nil {
+		return err
+	}
+	for _, revoked := range crl.RevokedCertificateEntries {
+		if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return fmt.Errorf("certificate %X was revoked at %s", cert.SerialNumber, revoked.RevocationTime.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// certificatePublicKey returns a certificate's public key as a PEM PUBLIC KEY
+func certificatePublicKey(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: cert.RawSubjectPublicKeyInfo}))
+}
@@ -0,0 +1,202 @@
+package e2e
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// testCA is a CA issuing certificates and CRLs for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert, key, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))}
+}
+
+// issue issues a key agreement certificate with serial
+func (ca *testCA) issue(t *testing.T, serial int64) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyAgreement,
+	}, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// crl returns a PEM CRL revoking serials, due to be updated at nextUpdate
+func (ca *testCA) crl(t *testing.T, nextUpdate time.Time, serials ...int64) string {
+	t.Helper()
+	var revoked []x509.RevocationListEntry
+	for _, serial := range serials {
+		revoked = append(revoked, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now().Add(-time.Minute)})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                nextUpdate.Add(-24 * time.Hour),
+		NextUpdate:                nextUpdate,
+		RevokedCertificateEntries: revoked,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}))
+}
+
+func TestCheckCertificateRevoked(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	other := newTestCA(t, "other")
+	now := time.Now()
+	current, stale := now.Add(time.Hour), now.Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		crl        string
+		allowStale bool
+		cert       *x509.Certificate
+		err        string
+	}{
+		{"no crl", "", false, ca.issue(t, 2), ""},
+		{"not revoked", ca.crl(t, current, 3), false, ca.issue(t, 2), ""},
+		{"revoked", ca.crl(t, current, 2), false, ca.issue(t, 2), "certificate 2 was revoked"},
+		{"stale", ca.crl(t, stale), false, ca.issue(t, 2), "crl is stale"},
+		{"stale and revoked", ca.crl(t, stale, 2), false, ca.issue(t, 2), "crl is stale"},
+		{"stale allowed", ca.crl(t, stale), true, ca.issue(t, 2), ""},
+		{"stale allowed and revoked", ca.crl(t, stale, 2), true, ca.issue(t, 2), "certificate 2 was revoked"},
+		{"stale crl of another issuer", other.crl(t, stale, 2), false, ca.issue(t, 2), ""},
+	}
+	for _, test := range tests {
+		config := &E2eConfigEntry{CACertificates: ca.pem + other.pem, CRL: test.crl, AllowStaleCRL: test.allowStale}
+		crl, err := loadCRL(config)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		err = checkCertificateRevoked(config, crl, test.cert, now)
+		if (err == nil) != (test.err == "") || (err != nil && !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestConfigRefusesStaleCRL(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	backend := Backend(ctx, &logical.BackendConfig{StorageView: storage, System: logical.TestSystemView()})
+	ca := newTestCA(t, "ca")
+	write := func(raw map[string]interface{}) *logical.Response {
+		resp, _ := backend.pathConfigWrite(ctx, &logical.Request{Storage: storage}, &framework.FieldData{Raw: raw, Schema: e2eConfigSchema})
+		return resp
+	}
+
+	staleCRL := ca.crl(t, time.Now().Add(-time.Hour))
+	if resp := write(map[string]interface{}{"ca_certificates": ca.pem, "crl": staleCRL}); resp == nil || !strings.Contains(resp.Data["error"].(string), "crl is stale") {
+		t.Errorf("stale crl written: %v", resp)
+	}
+	if resp := write(map[string]interface{}{"ca_certificates": ca.pem, "crl": staleCRL, "allow_stale_crl": true}); resp != nil {
+		t.Errorf("stale crl refused with allow_stale_crl: %v", resp)
+	}
+	if resp := write(map[string]interface{}{"crl": ca.crl(t, time.Now().Add(time.Hour)), "allow_stale_crl": false}); resp != nil {
+		t.Errorf("current crl refused: %v", resp)
+	}
+
+	// once the stored CRL goes stale, only writes changing the CAs or CRL
+	// check it again
+	if err := putJSON(ctx, storage, configStorageKey, &E2eConfigEntry{ChallengeTTL: 300, CACertificates: ca.pem, CRL: staleCRL}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := write(map[string]interface{}{"challenge_ttl": 600}); resp != nil {
+		t.Errorf("challenge_ttl refused with a stale crl stored: %v", resp)
+	}
+	if resp := write(map[string]interface{}{"allow_stale_crl": false}); resp == nil || !strings.Contains(resp.Data["error"].(string), "crl is stale") {
+		t.Errorf("stale crl kept: %v", resp)
+	}
+}
+
+func TestTidyKeepsEnrolementsOfStaleCRL(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	config := &logical.BackendConfig{StorageView: storage, System: logical.TestSystemView()}
+	backend := Backend(ctx, config)
+	if err := backend.Setup(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+	ca := newTestCA(t, "ca")
+	cert := ca.issue(t, 2)
+	certPem := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+
+	// the stale CRL revokes the certificate, but may be superseded
+	if err := putJSON(ctx, storage, configStorageKey, &E2eConfigEntry{CACertificates: ca.pem, CRL: ca.crl(t, time.Now().Add(-time.Hour), 2)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := putJSON(ctx, storage, "enrole/leaf", E2eEnrolementEntry{Name: "leaf", Certificate: certPem}); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.periodicTidy(ctx, &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+	enrole := E2eEnrolementEntry{}
+	entry, err := storage.Get(ctx, "enrole/leaf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(entry.Value, &enrole); err != nil {
+		t.Fatal(err)
+	}
+	if enrole.Revoked != "" {
+		t.Error("enrolement revoked by a stale crl")
+	}
+	if resp, _ := checkEnrolementValid(ctx, storage, &enrole); resp == nil || !strings.Contains(resp.Data["error"].(string), "crl is stale") {
+		t.Errorf("payload allowed with a stale crl: %v", resp)
+	}
+}
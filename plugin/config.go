@@ -0,0 +1,62 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// storage key of the backend configuration
+const configStorageKey = "config"
+
+// E2eConfigEntry structure representing the backend configuration
+type E2eConfigEntry struct { // nolint
+	// enrolements must prove possession of their private key, with a CSR, a
+	// signed challenge or a decrypted challenge
+	RequireProof bool `json:"require_proof" structs:"require_proof" mapstructure:"require_proof"`
+
+	// seconds an enrolement challenge can be signed within
+	ChallengeTTL int `json:"challenge_ttl" structs:"challenge_ttl" mapstructure:"challenge_ttl"`
+
+	// PEM bundle of the CA certificates enrolement certificates must chain to
+	CACertificates string `json:"ca_certificates" structs:"ca_certificates" mapstructure:"ca_certificates"`
+
+	// PEM X509 CRL, signed by one of the CA certificates, that enrolement
+	// certificates are checked against
+	CRL string `json:"crl" structs:"crl" mapstructure:"crl"`
+
+	// a CRL past its NextUpdate is still used, rather than refusing the
+	// certificates it covers until a new one is written
+	AllowStaleCRL bool `json:"allow_stale_crl" structs:"allow_stale_crl" mapstructure:"allow_stale_crl"`
+
+	// seconds revoked enrolements are kept before they are purged, zero to
+	// keep them
+	EnrolementRetention int `json:"enrolement_retention" structs:"enrolement_retention" mapstructure:"enrolement_retention"`
+}
+
+// defaultConfig is used until the backend is configured
+var defaultConfig = E2eConfigEntry{
+	RequireProof:        false,
+	ChallengeTTL:        300,
+	AllowStaleCRL:       false,
+	EnrolementRetention: 30 * 24 * 60 * 60,
+}
+
+// getConfig loads the backend configuration, or the defaults if it has not
+// been written
+func getConfig(ctx context.Context, s logical.Storage) (*E2eConfigEntry, error) {
+	config := defaultConfig
+	entry, err := s.Get(ctx, configStorageKey)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return &config, nil
+	}
+
+	if err := json.Unmarshal(entry.Value, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
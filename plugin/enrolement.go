@@ -1,5 +1,13 @@
 package e2e
 
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
 // E2eEnrolementEntry structure repesenting an E2E public key enrolement
 type E2eEnrolementEntry struct { // nolint
 	// ID string `json:"id" structs:"id" mapstructure:"id"`
@@ -8,9 +16,74 @@ type E2eEnrolementEntry struct { // nolint
 
 	PubKey string `json:"pubkey" structs:"pubkey" mapstructure:"pubkey"`
 
+	KeyType string `json:"keytype" structs:"keytype" mapstructure:"keytype"`
+
+	Cipher string `json:"cipher" structs:"cipher" mapstructure:"cipher"`
+
+	Compression string `json:"compression" structs:"compression" mapstructure:"compression"`
+
 	Fingerprint string `json:"fingerprint" structs:"fingerprint" mapstructure:"fingerprint"`
 
+	KeyID string `json:"keyid" structs:"keyid" mapstructure:"keyid"`
+
+	Certificate string `json:"certificate" structs:"certificate" mapstructure:"certificate"`
+
+	Proof string `json:"proof" structs:"proof" mapstructure:"proof"`
+
 	Authorised bool `json:"authorised" structs:"authorised" mapstructure:"authorised"`
 
 	Created string `json:"created" structs:"created" mapstructure:"created"`
+
+	ExpiresAt string `json:"expires_at" structs:"expires_at" mapstructure:"expires_at"`
+
+	Revoked string `json:"revoked" structs:"revoked" mapstructure:"revoked"`
+}
+
+// Expired reports whether the enrolement has expired by now, enrolements
+// without an expiry never do
+func (enrole *E2eEnrolementEntry) Expired(now time.Time) (bool, error) {
+	if enrole.ExpiresAt == "" {
+		return false, nil
+	}
+	var expiresAt time.Time
+	if err := expiresAt.UnmarshalText([]byte(enrole.ExpiresAt)); err != nil {
+		return false, err
+	}
+	return !now.Before(expiresAt), nil
+}
+
+// checkEnrolementValid refuses payloads for enrolements that have been
+// revoked or have expired, or whose certificate has since been revoked by the
+// config's CRL (ahead of the periodic tidy revoking them)
+func checkEnrolementValid(ctx context.Context, s logical.Storage, enrole *E2eEnrolementEntry) (*logical.Response, error) {
+	if enrole.Revoked != "" {
+		return logical.ErrorResponse(fmt.Sprintf("enrolement %s was revoked at %s", enrole.Name, enrole.Revoked)), logical.ErrInvalidRequest
+	}
+	expired, err := enrole.Expired(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return logical.ErrorResponse(fmt.Sprintf("enrolement %s expired at %s", enrole.Name, enrole.ExpiresAt)), logical.ErrInvalidRequest
+	}
+
+	if enrole.Certificate == "" {
+		return nil, nil
+	}
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parseCertificates(enrole.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	crl, err := loadCRL(config)
+	if err == nil {
+		err = checkCertificateRevoked(config, crl, certs[0], time.Now())
+	}
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("enrolement %s: %s", enrole.Name, err)), logical.ErrInvalidRequest
+	}
+	return nil, nil
 }
@@ -0,0 +1,187 @@
+package e2e
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/logical"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+const (
+	defaultGenerateBytes   = 32
+	maxGenerateBytes       = 1024
+	defaultGenerateRSABits = 2048
+)
+
+// generateSecret creates a random value for a generator spec of the form
+// "type[:arg[:arg]]", e.g.:
+//
+//	password            password from the default policy
+//	password:<policy>   password from a named policy
+//	bytes[:n[:hex|base64]]
+//	uuid
+//	rsa[:2048|3072|4096]
+//	ed25519
+//
+// returning the value to store and metadata that is safe to return to the caller
+func generateSecret(ctx context.Context, s logical.Storage, spec string) (interface{}, map[string]interface{}, error) {
+	parts := strings.Split(spec, ":")
+	kind := parts[0]
+	args := parts[1:]
+
+	switch kind {
+	case "password":
+		name := defaultPasswordPolicy.Name
+		if len(args) > 0 && args[0] != "" {
+			name = args[0]
+		}
+		policy, err := getPasswordPolicy(ctx, s, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if policy == nil {
+			return nil, nil, fmt.Errorf("password policy %q not found", name)
+		}
+		password, err := policy.Generate()
+		if err != nil {
+			return nil, nil, err
+		}
+		return password, map[string]interface{}{
+			"type":   kind,
+			"policy": policy.Name,
+			"length": policy.Length,
+		}, nil
+
+	case "bytes":
+		n := defaultGenerateBytes
+		if len(args) > 0 && args[0] != "" {
+			var err error
+			n, err = strconv.Atoi(args[0])
+			if err != nil || n < 1 || n > maxGenerateBytes {
+				return nil, nil, fmt.Errorf("invalid byte count %q, must be 1-%d", args[0], maxGenerateBytes)
+			}
+		}
+		encoding := "hex"
+		if len(args) > 1 {
+			encoding = args[1]
+		}
+		b, err := generateRandomBytes(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		var value string
+		switch encoding {
+		case "hex":
+			value = hex.EncodeToString(b)
+		case "base64":
+			value = base64.StdEncoding.EncodeToString(b)
+		default:
+			return nil, nil, fmt.Errorf("unsupported encoding %q, use hex or base64", encoding)
+		}
+		return value, map[string]interface{}{
+			"type":     kind,
+			"bytes":    n,
+			"encoding": encoding,
+		}, nil
+
+	case "uuid":
+		id, err := uuid.GenerateUUID()
+		if err != nil {
+			return nil, nil, err
+		}
+		return id, map[string]interface{}{
+			"type": kind,
+		}, nil
+
+	case "rsa":
+		bits := defaultGenerateRSABits
+		if len(args) > 0 && args[0] != "" {
+			var err error
+			bits, err = strconv.Atoi(args[0])
+			if err != nil || (bits != 2048 && bits != 3072 && bits != 4096) {
+				return nil, nil, fmt.Errorf("invalid rsa key size %q, must be 2048, 3072 or 4096", args[0])
+			}
+		}
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, nil, err
+		}
+		value, meta, err := keypairSecret(key, &key.PublicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		meta["type"] = kind
+		meta["bits"] = bits
+		return value, meta, nil
+
+	case "ed25519":
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		value, meta, err := keypairSecret(key, pub)
+		if err != nil {
+			return nil, nil, err
+		}
+		meta["type"] = kind
+		return value, meta, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown generator %q", kind)
+}
+
+// keypairSecret PEM encodes a generated key pair (PKCS#8 / SPKI) as a nested
+// secret, so payloads can reference `.field.private_key` and `.field.public_key`
+func keypairSecret(key crypto.PrivateKey, pub crypto.PublicKey) (map[string]interface{}, map[string]interface{}, error) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privPem := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	pubPem := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}))
+
+	value := map[string]interface{}{
+		"private_key": privPem,
+		"public_key":  pubPem,
+	}
+	meta := map[string]interface{}{
+		"public_key":  pubPem,
+		"fingerprint": envelope.Fingerprint(pkix),
+	}
+	return value, meta, nil
+}
+
+// getGeneratorSpecs loads the generator specs recorded for a kv secret's
+// generated fields, used to rotate them
+func getGeneratorSpecs(ctx context.Context, s logical.Storage, key string) (map[string]string, error) {
+	specs := map[string]string{}
+	entry, err := s.Get(ctx, "generators/"+key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return specs, nil
+	}
+	if err := json.Unmarshal(entry.Value, &specs); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
@@ -0,0 +1,325 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// storage key of the backend managed keyring
+const keyringStorageKey = "keyring"
+
+// sealed kv records are stored as:
+//
+//	magic (6 bytes) | key id (uint32, big endian) | nonce (12 bytes) | AES-256-GCM ciphertext
+//
+// with the storage key as additional data, so records can't be swapped between
+// paths. Legacy plaintext records are JSON objects, so always start with '{'.
+var sealedKVMagic = []byte("E2EKV\x01")
+
+// E2eKeyringEntry structure representing the backend's kv encryption keyring
+type E2eKeyringEntry struct { // nolint
+	Current uint32 `json:"current" structs:"current" mapstructure:"current"`
+
+	Keys []E2eKeyringKey `json:"keys" structs:"keys" mapstructure:"keys"`
+}
+
+// E2eKeyringKey a single AES-256-GCM key within the keyring
+type E2eKeyringKey struct { // nolint
+	ID uint32 `json:"id" structs:"id" mapstructure:"id"`
+
+	Key []byte `json:"key" structs:"key" mapstructure:"key"`
+
+	Created string `json:"created" structs:"created" mapstructure:"created"`
+}
+
+func (keyring *E2eKeyringEntry) key(id uint32) []byte {
+	for _, k := range keyring.Keys {
+		if k.ID == id {
+			return k.Key
+		}
+	}
+	return nil
+}
+
+// rotate adds a new random key to the keyring and makes it current
+func (keyring *E2eKeyringEntry) rotate() error {
+	key, err := generateRandomBytes(32)
+	if err != nil {
+		return err
+	}
+	timeText, err := time.Now().MarshalText()
+	if err != nil {
+		return err
+	}
+
+	keyring.Current++
+	keyring.Keys = append(keyring.Keys, E2eKeyringKey{
+		ID:      keyring.Current,
+		Key:     key,
+		Created: string(timeText),
+	})
+	return nil
+}
+
+// getKeyring loads the keyring, creating it with an initial key on first use
+func (backend *E2eBackend) getKeyring(ctx context.Context, s logical.Storage) (*E2eKeyringEntry, error) {
+	backend.keyringLock.RLock()
+	keyring, err := loadKeyring(ctx, s)
+	backend.keyringLock.RUnlock()
+	if err != nil || keyring != nil {
+		return keyring, err
+	}
+
+	backend.keyringLock.Lock()
+	defer backend.keyringLock.Unlock()
+
+	// check again now we hold the write lock
+	keyring, err = loadKeyring(ctx, s)
+	if err != nil || keyring != nil {
+		return keyring, err
+	}
+
+	keyring = &E2eKeyringEntry{}
+	if err := keyring.rotate(); err != nil {
+		return nil, err
+	}
+	if err := storeKeyring(ctx, s, keyring); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// rotateKeyring adds a new current key, existing keys are kept for decryption
+func (backend *E2eBackend) rotateKeyring(ctx context.Context, s logical.Storage) (*E2eKeyringEntry, error) {
+	if _, err := backend.getKeyring(ctx, s); err != nil {
+		return nil, err
+	}
+
+	backend.keyringLock.Lock()
+	defer backend.keyringLock.Unlock()
+
+	keyring, err := loadKeyring(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if err := keyring.rotate(); err != nil {
+		return nil, err
+	}
+	if err := storeKeyring(ctx, s, keyring); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+func loadKeyring(ctx context.Context, s logical.Storage) (*E2eKeyringEntry, error) {
+	entry, err := s.Get(ctx, keyringStorageKey)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var keyring E2eKeyringEntry
+	if err := json.Unmarshal(entry.Value, &keyring); err != nil {
+		return nil, err
+	}
+	return &keyring, nil
+}
+
+func storeKeyring(ctx context.Context, s logical.Storage, keyring *E2eKeyringEntry) error {
+	dataJSON, err := json.Marshal(keyring)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   keyringStorageKey,
+		Value: dataJSON,
+	})
+}
+
+// putKV encrypts a kv secret with the current keyring key and stores it
+func (backend *E2eBackend) putKV(ctx context.Context, s logical.Storage, key string, value map[string]interface{}) error {
+	keyring, err := backend.getKeyring(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := sealKV(keyring, key, plaintext)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   key,
+		Value: sealed,
+	})
+}
+
+// getKV loads and decrypts a kv secret, returning nil if it does not exist.
+// Records written before encryption at rest are read as plaintext json.
+func (backend *E2eBackend) getKV(ctx context.Context, s logical.Storage, key string) (map[string]interface{}, error) {
+	entry, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	plaintext := entry.Value
+	if isSealedKV(entry.Value) {
+		keyring, err := backend.getKeyring(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		if plaintext, _, err = unsealKV(keyring, key, entry.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	vData := map[string]interface{}{}
+	if err := json.Unmarshal(plaintext, &vData); err != nil {
+		return nil, err
+	}
+	return vData, nil
+}
+
+// rewrapKV re-encrypts every kv secret not already sealed with the current
+// key (including legacy plaintext records), returning the number rewrapped
+func (backend *E2eBackend) rewrapKV(ctx context.Context, s logical.Storage) (int, error) {
+	keyring, err := backend.getKeyring(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = walkStorage(ctx, s, "kv/", func(key string) error {
+		entry, err := s.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return nil
+		}
+
+		plaintext := entry.Value
+		if isSealedKV(entry.Value) {
+			var keyID uint32
+			plaintext, keyID, err = unsealKV(keyring, key, entry.Value)
+			if err != nil {
+				return err
+			}
+			if keyID == keyring.Current {
+				return nil
+			}
+		}
+
+		sealed, err := sealKV(keyring, key, plaintext)
+		if err != nil {
+			return err
+		}
+		if err := s.Put(ctx, &logical.StorageEntry{Key: key, Value: sealed}); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// walkStorage calls fn for every key beneath prefix, recursing into "folders"
+func walkStorage(ctx context.Context, s logical.Storage, prefix string, fn func(key string) error) error {
+	keys, err := s.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if strings.HasSuffix(k, "/") {
+			if err := walkStorage(ctx, s, prefix+k, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(prefix + k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isSealedKV(value []byte) bool {
+	return bytes.HasPrefix(value, sealedKVMagic)
+}
+
+func sealKV(keyring *E2eKeyringEntry, key string, plaintext []byte) ([]byte, error) {
+	aesgcm, err := keyringGCM(keyring.key(keyring.Current))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := generateRandomBytes(aesgcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(sealedKVMagic)+4)
+	copy(header, sealedKVMagic)
+	binary.BigEndian.PutUint32(header[len(sealedKVMagic):], keyring.Current)
+
+	sealed := append(header, nonce...)
+	return aesgcm.Seal(sealed, nonce, plaintext, []byte(key)), nil
+}
+
+func unsealKV(keyring *E2eKeyringEntry, key string, sealed []byte) ([]byte, uint32, error) {
+	headerLen := len(sealedKVMagic) + 4
+	if len(sealed) < headerLen {
+		return nil, 0, errors.New("sealed kv record is truncated")
+	}
+	keyID := binary.BigEndian.Uint32(sealed[len(sealedKVMagic):headerLen])
+
+	k := keyring.key(keyID)
+	if k == nil {
+		return nil, 0, fmt.Errorf("kv record %q is sealed with unknown key id %d", key, keyID)
+	}
+	aesgcm, err := keyringGCM(k)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rest := sealed[headerLen:]
+	if len(rest) < aesgcm.NonceSize() {
+		return nil, 0, errors.New("sealed kv record is truncated")
+	}
+	plaintext, err := aesgcm.Open(nil, rest[:aesgcm.NonceSize()], rest[aesgcm.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to decrypt kv record %q: %s", key, err)
+	}
+	return plaintext, keyID, nil
+}
+
+func keyringGCM(key []byte) (cipher.AEAD, error) {
+	if key == nil {
+		return nil, errors.New("keyring key not found")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
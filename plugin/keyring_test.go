@@ -0,0 +1,118 @@
+package e2e
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestKeyringRotateAndRewrap(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	backend := Backend(ctx, &logical.BackendConfig{StorageView: storage, System: logical.TestSystemView()})
+
+	if err := backend.putKV(ctx, storage, "kv/old", map[string]interface{}{"secret": "old"}); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := backend.rotateKeyring(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyring.Current != 2 {
+		t.Fatalf("rotated keyring current key %d", keyring.Current)
+	}
+	if err := backend.putKV(ctx, storage, "kv/folder/new", map[string]interface{}{"secret": "new"}); err != nil {
+		t.Fatal(err)
+	}
+	// written before encryption at rest
+	if err := storage.Put(ctx, &logical.StorageEntry{Key: "kv/legacy", Value: []byte(`{"secret":"legacy"}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// keyID returns the id of the key a record is sealed with, 0 if plaintext
+	keyID := func(key string) uint32 {
+		entry, err := storage.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isSealedKV(entry.Value) {
+			return 0
+		}
+		return binary.BigEndian.Uint32(entry.Value[len(sealedKVMagic):])
+	}
+	check := func(when string) {
+		for key, secret := range map[string]string{"kv/old": "old", "kv/folder/new": "new", "kv/legacy": "legacy"} {
+			data, err := backend.getKV(ctx, storage, key)
+			if err != nil {
+				t.Fatalf("%s, %s: %s", when, key, err)
+			}
+			if data["secret"] != secret {
+				t.Errorf("%s, %s read as %v", when, key, data)
+			}
+		}
+	}
+
+	check("after rotation")
+	if keyID("kv/old") != 1 || keyID("kv/folder/new") != 2 || keyID("kv/legacy") != 0 {
+		t.Fatalf("records sealed with keys %d, %d and %d", keyID("kv/old"), keyID("kv/folder/new"), keyID("kv/legacy"))
+	}
+
+	// the old and legacy records are rewrapped, the new one is already current
+	count, err := backend.rewrapKV(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("rewrapped %d records, want 2", count)
+	}
+	for _, key := range []string{"kv/old", "kv/folder/new", "kv/legacy"} {
+		if id := keyID(key); id != 2 {
+			t.Errorf("%s sealed with key %d after rewrap", key, id)
+		}
+	}
+	check("after rewrap")
+
+	if count, err := backend.rewrapKV(ctx, storage); err != nil || count != 0 {
+		t.Errorf("second rewrap rewrapped %d records: %v", count, err)
+	}
+}
+
+func TestKeyringUnknownKey(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	backend := Backend(ctx, &logical.BackendConfig{StorageView: storage, System: logical.TestSystemView()})
+
+	if err := backend.putKV(ctx, storage, "kv/a", map[string]interface{}{"secret": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := storage.Get(ctx, "kv/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a record sealed with a key no longer in the keyring, or moved to
+	// another path, is refused
+	binary.BigEndian.PutUint32(entry.Value[len(sealedKVMagic):], 7)
+	if err := storage.Put(ctx, &logical.StorageEntry{Key: "kv/a", Value: entry.Value}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.getKV(ctx, storage, "kv/a"); err == nil {
+		t.Error("record sealed with an unknown key read")
+	}
+
+	if err := backend.putKV(ctx, storage, "kv/b", map[string]interface{}{"secret": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = storage.Get(ctx, "kv/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, &logical.StorageEntry{Key: "kv/c", Value: entry.Value}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.getKV(ctx, storage, "kv/c"); err == nil {
+		t.Error("record moved to another path read")
+	}
+}
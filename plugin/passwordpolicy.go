@@ -0,0 +1,100 @@
+package e2e
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// character classes that a password policy can require
+var passwordClasses = map[string]string{
+	"lower":  "abcdefghijklmnopqrstuvwxyz",
+	"upper":  "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"digit":  "0123456789",
+	"symbol": "!#$%&()*+,-./:;<=>?@[]^_{|}~",
+}
+
+// E2ePasswordPolicyEntry structure representing a named password policy
+// used by the kv secret generators
+type E2ePasswordPolicyEntry struct { // nolint
+	Name string `json:"name" structs:"name" mapstructure:"name"`
+
+	Length int `json:"length" structs:"length" mapstructure:"length"`
+
+	Charset string `json:"charset" structs:"charset" mapstructure:"charset"`
+
+	Required []string `json:"required" structs:"required" mapstructure:"required"`
+}
+
+// defaultPasswordPolicy is used when a generator does not name a policy, or
+// names "default" and no stored policy overrides it
+var defaultPasswordPolicy = E2ePasswordPolicyEntry{
+	Name:     "default",
+	Length:   24,
+	Charset:  passwordClasses["lower"] + passwordClasses["upper"] + passwordClasses["digit"] + passwordClasses["symbol"],
+	Required: []string{"lower", "upper", "digit", "symbol"},
+}
+
+// maximum attempts to generate a password that satisfies the required classes
+const passwordPolicyMaxAttempts = 100
+
+// Validate checks the policy can actually produce a password
+func (policy *E2ePasswordPolicyEntry) Validate() error {
+	if policy.Length < 1 {
+		return errors.New("length must be greater than zero")
+	}
+	if policy.Charset == "" {
+		return errors.New("charset must not be empty")
+	}
+	if len(policy.Required) > policy.Length {
+		return fmt.Errorf("length %d is too short to include %d required classes", policy.Length, len(policy.Required))
+	}
+	for _, class := range policy.Required {
+		chars, ok := passwordClasses[class]
+		if !ok {
+			return fmt.Errorf("unknown character class %q", class)
+		}
+		if !strings.ContainsAny(policy.Charset, chars) {
+			return fmt.Errorf("charset contains no characters from required class %q", class)
+		}
+	}
+	return nil
+}
+
+// Generate a random password from the policy's charset, retrying until all
+// required classes are present (rejection keeps the distribution uniform)
+func (policy *E2ePasswordPolicyEntry) Generate() (string, error) {
+	if err := policy.Validate(); err != nil {
+		return "", err
+	}
+
+	charset := []rune(policy.Charset)
+	max := big.NewInt(int64(len(charset)))
+
+	for attempt := 0; attempt < passwordPolicyMaxAttempts; attempt++ {
+		password := make([]rune, policy.Length)
+		for i := range password {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			password[i] = charset[n.Int64()]
+		}
+
+		if policy.satisfied(string(password)) {
+			return string(password), nil
+		}
+	}
+	return "", fmt.Errorf("unable to satisfy password policy %q after %d attempts", policy.Name, passwordPolicyMaxAttempts)
+}
+
+func (policy *E2ePasswordPolicyEntry) satisfied(password string) bool {
+	for _, class := range policy.Required {
+		if !strings.ContainsAny(password, passwordClasses[class]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package e2e
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// schema for the backend configuration
+var e2eConfigSchema = map[string]*framework.FieldSchema{
+	"require_proof": {
+		Type:        framework.TypeBool,
+		Default:     defaultConfig.RequireProof,
+		Description: "Require enrolements to prove possession of their private key, with a csr, or a signature over (or the decrypted response to) a challenge from enrole/<name>/challenge",
+	},
+	"challenge_ttl": {
+		Type:        framework.TypeDurationSecond,
+		Default:     defaultConfig.ChallengeTTL,
+		Description: "How long an enrolement challenge can be signed within",
+	},
+	"ca_certificates": {
+		Type:        framework.TypeString,
+		Description: "PEM bundle of the trusted CA certificates enrolement certificates must chain to",
+	},
+	"crl": {
+		Type:        framework.TypeString,
+		Description: "PEM X509 CRL, signed by one of the ca_certificates, to check enrolement certificates against",
+	},
+	"allow_stale_crl": {
+		Type:        framework.TypeBool,
+		Default:     defaultConfig.AllowStaleCRL,
+		Description: "Keep using the crl after its next update is due, otherwise the certificates it covers are refused enrolement and payloads until a new crl is written",
+	},
+	"enrolement_retention": {
+		Type:        framework.TypeDurationSecond,
+		Default:     defaultConfig.EnrolementRetention,
+		Description: "How long revoked (e.g. expired) enrolements are kept before they are purged, 0 to keep them",
+	},
+}
+
+const e2eConfigHelpDescription = `
+Backend configuration, e.g. to require enrolements to prove possession of
+their private key:
+
+  vault write e2e/config require_proof=true challenge_ttl=10m
+
+or to trust an internal CA to issue enrolement certificates, with its current
+CRL:
+
+  vault write e2e/config ca_certificates=@ca.pem crl=@ca.crl
+
+A CRL past its next update is refused, as are enrolement certificates it
+covers (so write the CA's new CRL before then), unless allow_stale_crl is
+set.
+
+Expired enrolements, and those whose certificate the CRL revokes, are
+revoked periodically, and purged enrolement_retention after (30 days by
+default):
+
+  vault write e2e/config enrolement_retention=2160h
+
+Fields not given keep their current values, an empty ca_certificates or crl
+removes them.
+`
+
+func pathConfig(backend *E2eBackend) []*framework.Path {
+	paths := []*framework.Path{
+		&framework.Path{
+			Pattern:         "config",
+			HelpSynopsis:    "E2E Backend Configuration",
+			HelpDescription: e2eConfigHelpDescription,
+			Fields:          e2eConfigSchema,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   backend.pathConfigRead,
+				logical.UpdateOperation: backend.pathConfigWrite,
+			},
+		},
+	}
+	return paths
+}
+
+func (backend *E2eBackend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if requireProof, ok := data.GetOk("require_proof"); ok {
+		config.RequireProof = requireProof.(bool)
+	}
+	if challengeTTL, ok := data.GetOk("challenge_ttl"); ok {
+		config.ChallengeTTL = challengeTTL.(int)
+		if config.ChallengeTTL < 1 {
+			return logical.ErrorResponse("challenge_ttl must be greater than zero"), logical.ErrInvalidRequest
+		}
+	}
+	if enrolementRetention, ok := data.GetOk("enrolement_retention"); ok {
+		config.EnrolementRetention = enrolementRetention.(int)
+		if config.EnrolementRetention < 0 {
+			return logical.ErrorResponse("enrolement_retention must not be negative"), logical.ErrInvalidRequest
+		}
+	}
+
+	// the CAs and CRL are only checked when one of them changes, so a CRL
+	// gone stale does not block writing the other fields
+	checkCRL := false
+	if caCertificates, ok := data.GetOk("ca_certificates"); ok {
+		config.CACertificates = caCertificates.(string)
+		checkCRL = true
+	}
+	if crl, ok := data.GetOk("crl"); ok {
+		config.CRL = crl.(string)
+		checkCRL = true
+	}
+	if allowStaleCRL, ok := data.GetOk("allow_stale_crl"); ok {
+		config.AllowStaleCRL = allowStaleCRL.(bool)
+		checkCRL = true
+	}
+	var cas []*x509.Certificate
+	if checkCRL && config.CACertificates != "" {
+		if cas, err = parseCertificates(config.CACertificates); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid ca_certificates: %s", err)), logical.ErrInvalidRequest
+		}
+	}
+	if checkCRL && config.CRL != "" {
+		crl, err := parseCRL(config.CRL, cas)
+		if err == nil {
+			err = checkCRLCurrent(config, crl, time.Now())
+		}
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid crl: %s", err)), logical.ErrInvalidRequest
+		}
+	}
+
+	if err := putJSON(ctx, req.Storage, configStorageKey, config); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (backend *E2eBackend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"require_proof":        config.RequireProof,
+			"challenge_ttl":        config.ChallengeTTL,
+			"ca_certificates":      config.CACertificates,
+			"crl":                  config.CRL,
+			"allow_stale_crl":      config.AllowStaleCRL,
+			"enrolement_retention": config.EnrolementRetention,
+		},
+	}, nil
+}
@@ -1,14 +1,19 @@
 package e2e
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
 )
 
 // basic schema for the creation of the E2E enrolement,
@@ -21,11 +26,45 @@ var createE2eEncroleSchema = map[string]*framework.FieldSchema{
 	},
 	"pubkey": {
 		Type:        framework.TypeString,
-		Description: "End point's RSA Public Key",
+		Description: "End point's PEM Public Key (RSA, P-256, P-384, X25519 or ML-KEM-768+X25519 hybrid), age X25519 recipient (age1...), ssh-ed25519/ssh-rsa public key or ASCII armoured OpenPGP public key",
+	},
+	"cipher": {
+		Type:        framework.TypeString,
+		Default:     envelope.CipherAES256GCM,
+		Description: "Payload content cipher: AES-256-GCM, CHACHA20-POLY1305 or XCHACHA20-POLY1305 (for recipients without AES hardware support)",
+	},
+	"compression": {
+		Type:        framework.TypeString,
+		Default:     envelope.CompressionNone,
+		Description: "Compress payloads before encryption: NONE, GZIP or ZSTD. Payloads mix request and secret data, so only enable this where compressed sizes cannot be observed by a requester (CRIME style leaks)",
 	},
 	"fingerprint": {
 		Type:        framework.TypeString,
-		Description: "RSA Public Key's fingerprint",
+		Description: "Public Key's fingerprint (SHA-256 of the encoded key, or the OpenPGP fingerprint)",
+	},
+	"csr": {
+		Type:        framework.TypeString,
+		Description: "PEM PKCS#10 certificate request signed by the key, with the enrolement name as its subject CN or a DNS SAN, as proof of possession (pubkey may then be omitted)",
+	},
+	"certificate": {
+		Type:        framework.TypeString,
+		Description: "PEM X.509 certificate of the key, followed by any intermediates, issued by one of the config's ca_certificates (pubkey may then be omitted). The enrolement expires at the certificate's NotAfter",
+	},
+	"signature": {
+		Type:        framework.TypeString,
+		Description: "Signature over the challenge from enrole/<name>/challenge, as proof of possession: base64 SHA-256 PKCS#1 v1.5, PSS or ECDSA signature, armoured SSH SIGNATURE (namespace vault-e2e) or armoured OpenPGP detached signature",
+	},
+	"response": {
+		Type:        framework.TypeString,
+		Description: "The challenge from enrole/<name>/challenge, decrypted with the key's private key, as proof of possession for keys that cannot sign (X25519, ML-KEM-768+X25519 and age X25519). The challenge must have been fetched with the pubkey",
+	},
+	"proof": {
+		Type:        framework.TypeString,
+		Description: "Proof of possession given at enrolement: csr, challenge, decryption or empty",
+	},
+	"keyid": {
+		Type:        framework.TypeString,
+		Description: "Key id of the OpenPGP (sub)key payloads are encrypted to",
 	},
 	"authorised": {
 		Type:        framework.TypeBool,
@@ -35,14 +74,58 @@ var createE2eEncroleSchema = map[string]*framework.FieldSchema{
 		Type:        framework.TypeString,
 		Description: "Datetime stamp then this enrolement was created",
 	},
+	"ttl": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Expire the enrolement after this duration",
+	},
+	"expires_at": {
+		Type:        framework.TypeString,
+		Description: "Datetime stamp (RFC 3339) when this enrolement expires, after which it is not sent payloads and is revoked. Defaults to the certificate's NotAfter, if earlier, for certificate enrolements",
+	},
+	"revoked": {
+		Type:        framework.TypeString,
+		Description: "Datetime stamp when this enrolement was revoked, it is purged after the config's enrolement_retention",
+	},
 }
 
 const e2eEnroleHelpDescription = `
 E2E Enrolement Help goes here...
 `
 
+const e2eEnroleChallengeHelpDescription = `
+Issues a single use challenge for an enrolement to sign with its private key,
+as proof of possession, replacing any challenge issued before:
+
+  vault write -f e2e/enrole/myhost/challenge
+  vault write e2e/enrole/myhost pubkey=@pub.pem signature=@challenge.sig
+
+Given the pubkey, the challenge is only returned encrypted to it, as a payload
+in the key's default format, for keys that cannot sign to decrypt instead:
+
+  vault write -field=encrypted_challenge e2e/enrole/myhost/challenge pubkey=@pub.pem
+  vault write e2e/enrole/myhost pubkey=@pub.pem response=@challenge.txt
+`
+
 func pathEnrole(backend *E2eBackend) []*framework.Path {
 	paths := []*framework.Path{
+		&framework.Path{
+			Pattern:         fmt.Sprintf("enrole/%s/challenge", framework.GenericNameRegex("name")),
+			HelpSynopsis:    "E2E Enrolement Challenges",
+			HelpDescription: e2eEnroleChallengeHelpDescription,
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "The name of the e2e target endpoint being enrolled",
+				},
+				"pubkey": {
+					Type:        framework.TypeString,
+					Description: "Public key to encrypt the challenge to, for enrolements proving possession by decrypting it",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: backend.pathEnroleChallenge,
+			},
+		},
 		&framework.Path{
 			Pattern:         fmt.Sprintf("enrole/"),
 			HelpSynopsis:    "E2E Enrolements",
@@ -85,19 +168,128 @@ func (backend *E2eBackend) pathEnroleDeny(ctx context.Context, req *logical.Requ
 }
 
 func (backend *E2eBackend) pathEnroleCreate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	timeText, err := time.Now().MarshalText()
+	now := time.Now()
+	timeText, err := now.MarshalText()
 	if err != nil {
 		return nil, err
 	}
 
+	name := data.Get("name").(string)
+	pubKey := data.Get("pubkey").(string)
+	var csrPubKey string
+	if csr := data.Get("csr").(string); csr != "" {
+		csrPubKey, err = envelope.VerifyCSR(csr, name)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid csr: %s", err)), logical.ErrInvalidRequest
+		}
+		if pubKey == "" {
+			pubKey = csrPubKey
+		}
+	}
+	certPem := data.Get("certificate").(string)
+	var certificate *x509.Certificate
+	if certPem != "" {
+		config, err := getConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		certificate, err = verifyCertificate(config, certPem, now)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid certificate: %s", err)), logical.ErrInvalidRequest
+		}
+		if pubKey == "" {
+			pubKey = certificatePublicKey(certificate)
+		}
+	}
+	pub, keyType, der, err := envelope.ParsePublicKey(pubKey)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid pubkey: %s", err)), logical.ErrInvalidRequest
+	}
+	if certificate != nil && !bytes.Equal(certificate.RawSubjectPublicKeyInfo, der) {
+		return logical.ErrorResponse("certificate is not for pubkey"), logical.ErrInvalidRequest
+	}
+
+	proof, resp, err := checkProof(ctx, req.Storage, name, pub, keyType, der, csrPubKey, data.Get("signature").(string), data.Get("response").(string))
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	cipherID := strings.ToUpper(data.Get("cipher").(string))
+	if !envelope.ValidCipher(cipherID) {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported cipher %q", cipherID)), logical.ErrInvalidRequest
+	}
+
+	compression := strings.ToUpper(data.Get("compression").(string))
+	if !envelope.ValidCompression(compression) {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported compression %q", compression)), logical.ErrInvalidRequest
+	}
+
+	// age files are always ChaCha20-Poly1305 and uncompressed
+	if envelope.IsAgeKeyType(keyType) {
+		if _, ok := data.GetOk("cipher"); ok && cipherID != envelope.CipherChaCha20Poly1305 {
+			return logical.ErrorResponse(fmt.Sprintf("age recipients only support the %s cipher", envelope.CipherChaCha20Poly1305)), logical.ErrInvalidRequest
+		}
+		if compression != envelope.CompressionNone {
+			return logical.ErrorResponse("age recipients do not support compression"), logical.ErrInvalidRequest
+		}
+		cipherID = envelope.CipherChaCha20Poly1305
+	}
+
 	enroleEntry := E2eEnrolementEntry{
-		Name:        data.Get("name").(string),
-		PubKey:      data.Get("pubkey").(string),
-		Fingerprint: "Pubkey-finger-print-goes-here",
+		Name:        name,
+		PubKey:      pubKey,
+		KeyType:     keyType,
+		Cipher:      cipherID,
+		Compression: compression,
+		Fingerprint: envelope.Fingerprint(der),
+		Proof:       proof,
 		Authorised:  false,
 		Created:     string(timeText),
 	}
 
+	// certificate enrolements expire with their certificate, if not before
+	var expiresAt time.Time
+	ttl := data.Get("ttl").(int)
+	if ttl > 0 {
+		expiresAt = now.Add(time.Duration(ttl) * time.Second)
+	}
+	if expiresAtText := data.Get("expires_at").(string); expiresAtText != "" {
+		if ttl > 0 {
+			return logical.ErrorResponse("give ttl or expires_at, not both"), logical.ErrInvalidRequest
+		}
+		if expiresAt, err = time.Parse(time.RFC3339, expiresAtText); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid expires_at: %s", err)), logical.ErrInvalidRequest
+		}
+		if !expiresAt.After(now) {
+			return logical.ErrorResponse("expires_at is in the past"), logical.ErrInvalidRequest
+		}
+	}
+	if certificate != nil {
+		enroleEntry.Certificate = certPem
+		if expiresAt.IsZero() || certificate.NotAfter.Before(expiresAt) {
+			expiresAt = certificate.NotAfter
+		}
+	}
+	if !expiresAt.IsZero() {
+		expiresAtText, err := expiresAt.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		enroleEntry.ExpiresAt = string(expiresAtText)
+	}
+
+	// OpenPGP messages use the OpenPGP cipher (AES-256) and ZLIB compression,
+	// and the key is identified by its OpenPGP fingerprint and the id of the
+	// (sub)key payloads are encrypted to
+	if entity, ok := pub.(*openpgp.Entity); ok {
+		if _, ok := data.GetOk("cipher"); ok {
+			return logical.ErrorResponse("OpenPGP recipients do not support the cipher option"), logical.ErrInvalidRequest
+		}
+		enroleEntry.Cipher = ""
+		enroleEntry.Fingerprint = envelope.OpenPGPFingerprint(entity)
+		enroleEntry.KeyID = envelope.OpenPGPEncryptionKeyID(entity)
+	}
+
 	dataJSON, err := json.Marshal(enroleEntry)
 	if err != nil {
 		return nil, err
@@ -114,6 +306,13 @@ func (backend *E2eBackend) pathEnroleCreate(ctx context.Context, req *logical.Re
 		return nil, err
 	}
 
+	// challenges are single use
+	if proof == proofChallenge || proof == proofDecryption {
+		if err := s.Delete(ctx, "challenges/"+name); err != nil {
+			return nil, err
+		}
+	}
+
 	response := &logical.Response{
 		Data: map[string]interface{}{
 			"Name": enroleEntry.Name,
@@ -123,6 +322,59 @@ func (backend *E2eBackend) pathEnroleCreate(ctx context.Context, req *logical.Re
 	return response, nil
 }
 
+func (backend *E2eBackend) pathEnroleChallenge(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	entry, err := req.Storage.Get(ctx, "enrole/"+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		return logical.ErrorResponse(fmt.Sprintf("%s is already enroled", name)), logical.ErrInvalidRequest
+	}
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	ttl := time.Duration(config.ChallengeTTL) * time.Second
+
+	// the challenge is encrypted to the pubkey, if given, for keys that can
+	// only prove possession by decrypting it, and then only returned
+	// encrypted, so having the public key is not enough to answer it
+	pubKey := data.Get("pubkey").(string)
+	if pubKey == "" {
+		challenge, err := newChallenge(ctx, req.Storage, name, "", ttl)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"challenge": challenge.Challenge,
+				"expires":   challenge.Expires,
+			},
+		}, nil
+	}
+
+	pub, keyType, der, err := envelope.ParsePublicKey(pubKey)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid pubkey: %s", err)), logical.ErrInvalidRequest
+	}
+	challenge, err := newChallenge(ctx, req.Storage, name, envelope.Fingerprint(der), ttl)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := envelope.Encrypt(pub, keyType, []byte(challenge.Challenge), &envelope.EncryptOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"encrypted_challenge": encrypted,
+			"expires":             challenge.Expires,
+		},
+	}, nil
+}
+
 func (backend *E2eBackend) pathEnroleRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	entry, err := req.Storage.Get(ctx, req.Path)
 	if err != nil {
@@ -0,0 +1,93 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const e2eKeyringHelpDescription = `
+The backend keyring encrypts kv secrets (AES-256-GCM) before they are written
+to storage, as defence in depth on top of Vault's barrier.
+
+  keyring         read the key ids and the current key (never key material)
+  keyring/rotate  add a new current key, older keys remain for decryption
+  keyring/rewrap  re-encrypt all kv secrets not sealed with the current key
+`
+
+func pathKeyring(backend *E2eBackend) []*framework.Path {
+	paths := []*framework.Path{
+		&framework.Path{
+			Pattern:         "keyring",
+			HelpSynopsis:    "E2E KV Keyring",
+			HelpDescription: e2eKeyringHelpDescription,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: backend.pathKeyringRead,
+			},
+		},
+		&framework.Path{
+			Pattern:         "keyring/rotate",
+			HelpSynopsis:    "Rotate the E2E KV Keyring",
+			HelpDescription: e2eKeyringHelpDescription,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: backend.pathKeyringRotate,
+			},
+		},
+		&framework.Path{
+			Pattern:         "keyring/rewrap",
+			HelpSynopsis:    "Rewrap KV secrets with the current key",
+			HelpDescription: e2eKeyringHelpDescription,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: backend.pathKeyringRewrap,
+			},
+		},
+	}
+	return paths
+}
+
+func keyringResponse(keyring *E2eKeyringEntry) *logical.Response {
+	keys := map[string]interface{}{}
+	for _, k := range keyring.Keys {
+		keys[fmt.Sprintf("%d", k.ID)] = k.Created
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"current": keyring.Current,
+			"keys":    keys,
+		},
+	}
+}
+
+func (backend *E2eBackend) pathKeyringRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	keyring, err := backend.getKeyring(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return keyringResponse(keyring), nil
+}
+
+func (backend *E2eBackend) pathKeyringRotate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	keyring, err := backend.rotateKeyring(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return keyringResponse(keyring), nil
+}
+
+func (backend *E2eBackend) pathKeyringRewrap(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	count, err := backend.rewrapKV(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"rewrapped": count,
+		},
+	}, nil
+}
@@ -2,28 +2,65 @@ package e2e
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
 
+const e2eKVHelpDescription = `
+Read and write kv secrets, which payloads interpolate. Writes either store the
+data given, or generate random values into the secret, e.g.:
+
+  {
+    "generate": {
+      "password": "password:db",
+      "apikey": "bytes:32:base64",
+      "client_id": "uuid",
+      "tls": "rsa:4096",
+      "signing": "ed25519"
+    },
+    "metadata_only": true
+  }
+
+Key pairs are stored as nested maps with "private_key" and "public_key" PEM
+fields. Generated fields are merged into the existing secret, or into the
+data given with them. With metadata_only set, the generated values are not
+returned and can only be revealed to a recipient via an encrypted payload.
+`
+
 // refs:
-//    https://github.com/hashicorp/vault/blob/master/logical/plugin/mock/path_kv.go
-//    https://github.com/hashicorp/vault-plugin-secrets-kv/blob/master/path_data.go
+//
+//	https://github.com/hashicorp/vault/blob/master/logical/plugin/mock/path_kv.go
+//	https://github.com/hashicorp/vault-plugin-secrets-kv/blob/master/path_data.go
 func pathKV(backend *E2eBackend) []*framework.Path {
 	paths := []*framework.Path{
 		&framework.Path{
-			Pattern:      "kv/.*",
-			HelpSynopsis: "E2E Encrypted KV Request API",
-			//HelpDescription: e2eEnroleHelpDescription,
+			Pattern:         "kv/.*",
+			HelpSynopsis:    "E2E Encrypted KV Request API",
+			HelpDescription: e2eKVHelpDescription,
 			Fields: map[string]*framework.FieldSchema{
 				"data": {
 					Type:        framework.TypeMap,
 					Description: "The contents of the data map will be stored and returned on read.",
 				},
+				"generate": {
+					Type:        framework.TypeMap,
+					Description: "Map of secret field name to generator spec (password[:policy], bytes[:n[:hex|base64]], uuid, rsa[:bits], ed25519) to generate into the secret",
+				},
+				"merge": {
+					Type:        framework.TypeBool,
+					Default:     true,
+					Description: "Merge generated fields into the existing secret rather than replacing it, when no data is given",
+				},
+				"metadata_only": {
+					Type:        framework.TypeBool,
+					Default:     false,
+					Description: "Only return metadata about the generated values, never the values themselves",
+				},
 			},
 			ExistenceCheck: backend.kvExistenceCheck,
 			Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -50,19 +87,22 @@ func (backend *E2eBackend) kvExistenceCheck(ctx context.Context, req *logical.Re
 func (backend *E2eBackend) pathKVWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	key := req.Path
 
-	var marshaledData []byte
+	if _, ok := data.GetOk("generate"); ok {
+		return backend.pathKVGenerate(ctx, req, data)
+	}
+
 	dataRaw, ok := data.GetOk("data")
 	if !ok {
 		return logical.ErrorResponse("no data provided"), logical.ErrInvalidRequest
 	}
-	marshaledData, err := json.Marshal(dataRaw.(map[string]interface{}))
+	resp, err := validateKVSchema(ctx, req.Storage, key, dataRaw)
 	if err != nil {
 		return nil, err
 	}
-	err = req.Storage.Put(ctx, &logical.StorageEntry{
-		Key:   key,
-		Value: marshaledData,
-	})
+	if resp != nil {
+		return resp, logical.ErrInvalidRequest
+	}
+	err = backend.putKV(ctx, req.Storage, key, dataRaw.(map[string]interface{}))
 	if err != nil {
 		return nil, err
 	}
@@ -74,28 +114,113 @@ func (backend *E2eBackend) pathKVWrite(ctx context.Context, req *logical.Request
 	}, nil
 }
 
+// pathKVGenerate generates the fields of a kv write's generate map into the
+// secret, recording their generator specs so one-time secrets can be rotated
+func (backend *E2eBackend) pathKVGenerate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	key := req.Path
+
+	fields := data.Get("generate").(map[string]interface{})
+	if len(fields) == 0 {
+		return logical.ErrorResponse("no fields to generate provided"), logical.ErrInvalidRequest
+	}
+
+	// generate into the data given, otherwise the existing secret
+	secret := map[string]interface{}{}
+	specs := map[string]string{}
+	dataRaw, replace := data.GetOk("data")
+	if replace {
+		for field, value := range dataRaw.(map[string]interface{}) {
+			secret[field] = value
+		}
+	} else if data.Get("merge").(bool) {
+		existing, err := backend.getKV(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			secret = existing
+		}
+		specs, err = getGeneratorSpecs(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	values := map[string]interface{}{}
+	metadata := map[string]interface{}{}
+	for field, rawSpec := range fields {
+		spec, ok := rawSpec.(string)
+		if !ok {
+			return logical.ErrorResponse(fmt.Sprintf("generator spec for %q must be a string", field)), logical.ErrInvalidRequest
+		}
+
+		value, meta, err := generateSecret(ctx, req.Storage, spec)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("field %q: %s", field, err)), logical.ErrInvalidRequest
+		}
+		secret[field] = value
+		values[field] = value
+		specs[field] = spec
+		metadata[field] = meta
+	}
+
+	resp, err := validateKVSchema(ctx, req.Storage, key, secret)
+	if err != nil {
+		return nil, err
+	}
+	if resp != nil {
+		return resp, logical.ErrInvalidRequest
+	}
+
+	err = backend.putKV(ctx, req.Storage, key, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// record how each field was generated, so one-time secrets can be rotated
+	err = putJSON(ctx, req.Storage, "generators/"+key, specs)
+	if err != nil {
+		return nil, err
+	}
+
+	timeText, err := time.Now().MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	resp = &logical.Response{
+		Data: map[string]interface{}{
+			"key":       key,
+			"generated": string(timeText),
+			"metadata":  metadata,
+		},
+	}
+	if !data.Get("metadata_only").(bool) {
+		resp.Data["value"] = values
+	}
+	return resp, nil
+}
+
 func (backend *E2eBackend) pathKVDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	if err := req.Storage.Delete(ctx, req.Path); err != nil {
 		return nil, err
 	}
+	if err := req.Storage.Delete(ctx, "generators/"+req.Path); err != nil {
+		return nil, err
+	}
 
 	return nil, nil
 }
 
 func (backend *E2eBackend) pathKVRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	entry, err := req.Storage.Get(ctx, req.Path)
+	vData, err := backend.getKV(ctx, req.Storage, req.Path)
 	if err != nil {
 		return nil, err
 	}
-	if entry == nil {
+	if vData == nil {
 		return nil, errors.New("could not find e2e path")
 	}
 
-	vData := map[string]interface{}{}
-	if err := json.Unmarshal(entry.Value, &vData); err != nil {
-		return nil, err
-	}
-
 	return &logical.Response{
 		Data: vData,
 	}, nil
@@ -0,0 +1,157 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// schema for named password policies used by the kv secret generators
+var createE2ePasswordPolicySchema = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "The name of the password policy",
+	},
+	"length": {
+		Type:        framework.TypeInt,
+		Default:     defaultPasswordPolicy.Length,
+		Description: "Length of generated passwords",
+	},
+	"charset": {
+		Type:        framework.TypeString,
+		Default:     defaultPasswordPolicy.Charset,
+		Description: "Characters to generate passwords from",
+	},
+	"required": {
+		Type:        framework.TypeCommaStringSlice,
+		Default:     strings.Join(defaultPasswordPolicy.Required, ","),
+		Description: "Character classes (lower, upper, digit, symbol) that must appear at least once",
+	},
+}
+
+const e2ePasswordPolicyHelpDescription = `
+Named password policies for the kv secret generators, e.g.:
+
+  vault write e2e/passwordpolicy/db length=32 required=lower,upper,digit
+
+then generate a secret with it:
+
+  vault write e2e/kv/app/db generate='{"password": "password:db"}'
+`
+
+func pathPasswordPolicy(backend *E2eBackend) []*framework.Path {
+	paths := []*framework.Path{
+		&framework.Path{
+			Pattern:         fmt.Sprintf("passwordpolicy/"),
+			HelpSynopsis:    "E2E KV Password Policies",
+			HelpDescription: e2ePasswordPolicyHelpDescription,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: backend.pathPasswordPolicyList,
+			},
+		},
+		&framework.Path{
+			Pattern:         fmt.Sprintf("passwordpolicy/%s", framework.GenericNameRegex("name")),
+			HelpSynopsis:    "E2E KV Password Policies",
+			HelpDescription: e2ePasswordPolicyHelpDescription,
+			Fields:          createE2ePasswordPolicySchema,
+			ExistenceCheck:  backend.pathExistenceCheck,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.CreateOperation: backend.pathPasswordPolicyWrite,
+				logical.UpdateOperation: backend.pathPasswordPolicyWrite,
+				logical.DeleteOperation: backend.pathPasswordPolicyDelete,
+				logical.ReadOperation:   backend.pathPasswordPolicyRead,
+			},
+		},
+	}
+	return paths
+}
+
+func (backend *E2eBackend) pathPasswordPolicyWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	policy := E2ePasswordPolicyEntry{
+		Name:     data.Get("name").(string),
+		Length:   data.Get("length").(int),
+		Charset:  data.Get("charset").(string),
+		Required: data.Get("required").([]string),
+	}
+
+	if err := policy.Validate(); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid password policy: %s", err)), logical.ErrInvalidRequest
+	}
+
+	dataJSON, err := json.Marshal(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = req.Storage.Put(ctx, &logical.StorageEntry{
+		Key:   req.Path,
+		Value: dataJSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (backend *E2eBackend) pathPasswordPolicyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	policy, err := getPasswordPolicy(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":     policy.Name,
+			"length":   policy.Length,
+			"charset":  policy.Charset,
+			"required": policy.Required,
+		},
+	}, nil
+}
+
+func (backend *E2eBackend) pathPasswordPolicyDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := req.Storage.Delete(ctx, req.Path); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (backend *E2eBackend) pathPasswordPolicyList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+// getPasswordPolicy loads a named password policy, falling back to the
+// built in default policy when "default" has not been overridden
+func getPasswordPolicy(ctx context.Context, s logical.Storage, name string) (*E2ePasswordPolicyEntry, error) {
+	entry, err := s.Get(ctx, "passwordpolicy/"+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		if name == defaultPasswordPolicy.Name {
+			policy := defaultPasswordPolicy
+			return &policy, nil
+		}
+		return nil, nil
+	}
+
+	var policy E2ePasswordPolicyEntry
+	if err := json.Unmarshal(entry.Value, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
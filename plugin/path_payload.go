@@ -3,28 +3,21 @@ package e2e
 import (
 	"bytes"
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
-	"crypto/x509"
-	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
-	"encoding/pem"
-	"errors"
 	"fmt"
-	"html/template"
-	"io"
 	"log"
 	"reflect"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/fatih/structs"
+	uuid "github.com/hashicorp/go-uuid"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
 )
 
 // basic schema for the submission of payload encryption requests,
@@ -34,6 +27,37 @@ var createE2ePayloadSchema = map[string]*framework.FieldSchema{
 		Type:        framework.TypeMap,
 		Description: "Payload structure (JSON encoded) to request secret interpolation and encrypting for target endpoint",
 	},
+	"schema": {
+		Type:        framework.TypeString,
+		Description: "Name of a registered payload schema to validate the interpolated payload against (defaults to the payload name)",
+	},
+	"ttl": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Return the payload with a lease of this duration, revoking or expiring the lease publishes the payload id as revoked",
+	},
+	"format": {
+		Type:        framework.TypeString,
+		Description: "Output format: armour (E2E ENCRYPTED PAYLOAD), binary (base64 encoded binary container), jwe (JWE compact serialisation), jwe-json (JWE flattened JSON serialisation), age (armoured age file), age-binary (base64 encoded age file) or pgp (PGP MESSAGE). Defaults to pgp for OpenPGP recipients, age for age recipients and armour otherwise",
+	},
+	"stream": {
+		Type:        framework.TypeBool,
+		Default:     false,
+		Description: "Encrypt the payload as STREAM chunks, so large payloads can be decrypted incrementally",
+	},
+	"one_time": {
+		Type:        framework.TypeBool,
+		Default:     false,
+		Description: "Secrets delivered in this leased payload are one-time, and are rotated when the lease is revoked or expires",
+	},
+	"sign": {
+		Type:        framework.TypeBool,
+		Default:     false,
+		Description: "Sign the payload with the plugin's signing key (published on signingkey), so recipients can tell it from a payload encrypted to their public key by anyone else. Supported by the armour, binary and jwe-json formats",
+	},
+	"expires_in": {
+		Type:        framework.TypeDurationSecond,
+		Description: "The payload carries an EXPIRES time this long after it is created, recipients refuse it after that. Not supported by the age and pgp formats",
+	},
 }
 
 const e2ePayloadHelpDescription = `
@@ -80,7 +104,8 @@ func (backend *E2eBackend) dummyNotExists(ctx context.Context, req *logical.Requ
 func (backend *E2eBackend) pathPayloadCreate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	// payload to populate from kv and encrypt with public key
 	payload := data.Get("payload").(map[string]interface{})
-	name := "enrole/" + strings.TrimLeft(req.Path, "payload/")
+	payloadName := strings.TrimPrefix(req.Path, "payload/")
+	name := "enrole/" + payloadName
 
 	entry, err := req.Storage.Get(ctx, name)
 	if err != nil {
@@ -98,114 +123,140 @@ func (backend *E2eBackend) pathPayloadCreate(ctx context.Context, req *logical.R
 		return nil, err
 	}
 
-	// decode PEM public key
-	// https://golang.org/pkg/encoding/pem/#Decode
-	pblock, _ := pem.Decode([]byte(enrole.PubKey))
-	if pblock == nil || pblock.Type != "PUBLIC KEY" {
-		return nil, errors.New("failed to decode PEM block containing public key")
+	if resp, err := checkEnrolementValid(ctx, req.Storage, &enrole); resp != nil || err != nil {
+		return resp, err
 	}
 
-	pub, err := x509.ParsePKIXPublicKey(pblock.Bytes)
+	// decode PEM public key
+	pub, keyType, _, err := envelope.ParsePublicKey(enrole.PubKey)
 	if err != nil {
 		return nil, err
 	}
 
+	ttl := time.Duration(data.Get("ttl").(int)) * time.Second
+	oneTime := data.Get("one_time").(bool)
+	if oneTime && ttl == 0 {
+		return logical.ErrorResponse("one_time requires the payload to be leased with a ttl"), logical.ErrInvalidRequest
+	}
+
 	// populate payload with nested kv secrets
 	errors := []string{}
 	errorCount := 0
-	err = populate(ctx, req, payload, &errorCount, &errors)
+	delivered := map[string]map[string]bool{}
+	err = backend.populate(ctx, req, payload, &errorCount, &errors, delivered)
 	if err != nil {
 		return nil, err
 	}
 
-	// Generate random key
-	key, err := generateRandomBytes(32)
+	// validate the interpolated payload against its schema, if one is registered
+	schemaName := data.Get("schema").(string)
+	if schemaName == "" {
+		schemaName = payloadName
+	}
+	schemaEntry, err := getSchema(ctx, req.Storage, "schema/payload/"+schemaName)
 	if err != nil {
 		return nil, err
 	}
-
-	// Stringify payload
-	sPayload, err := json.Marshal(payload)
+	if schemaEntry == nil && data.Get("schema").(string) != "" {
+		return logical.ErrorResponse(fmt.Sprintf("payload schema %q not found", schemaName)), logical.ErrInvalidRequest
+	}
+	resp, err := validateAgainstSchema(schemaEntry, payload)
 	if err != nil {
 		return nil, err
 	}
+	if resp != nil {
+		return resp, logical.ErrInvalidRequest
+	}
 
-	// see https://golang.org/pkg/crypto/cipher/#example_NewGCM_encrypt
-	// AES encrypt payload using key
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
+	opts := &envelope.EncryptOptions{
+		Format:      data.Get("format").(string),
+		Cipher:      enrole.Cipher,
+		Compression: enrole.Compression,
+		Stream:      data.Get("stream").(bool),
+		KeyID:       enrole.Fingerprint,
+	}
+	// payloads are only signed on request, so unsigned RSA payloads stay
+	// PAYLOAD_VERSION 2.0 for recipients that predate signing
+	if data.Get("sign").(bool) {
+		if opts.SigningKey, err = backend.getSigningKey(ctx, req.Storage); err != nil {
+			return nil, err
+		}
+	}
+	if expiresIn := time.Duration(data.Get("expires_in").(int)) * time.Second; expiresIn > 0 {
+		opts.Expires = time.Now().Add(expiresIn)
 	}
-	aesgcm, err := cipher.NewGCM(block)
+	if opts.Format == "" {
+		opts.Format = envelope.DefaultFormat(keyType)
+	}
+	if err := envelope.CheckFormat(opts.Format, keyType, opts); err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	// Leased payloads carry an id, so the recipient can check it against the
+	// published revocation list
+	var payloadID string
+	if ttl > 0 {
+		payloadID, err = uuid.GenerateUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.PayloadID = payloadID
+	}
+
+	// Stringify payload
+	sPayload, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
 	}
 
-	// Generate nonce/iv
-	// Never use more than 2^32 random nonces with a given key because of the risk of a repeat.
-	nonce := make([]byte, 12)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+	encrypted, err := envelope.Encrypt(pub, keyType, sPayload, opts)
+	if err != nil {
 		return nil, err
 	}
 
-	// Encrypt AESGCM and Seal
-	ciphertext := aesgcm.Seal(nil, nonce, sPayload, nil)
-
-	// encrypt the above key using RSA public key
-	// https://golang.org/pkg/crypto/rsa/#EncryptOAEP
-	label := []byte("Vault E2E Payload")
-	rng := rand.Reader
-	keyNonce := key
-	keyNonce = append(keyNonce, nonce...)
+	// Return the Encrypted payload
+	respData := map[string]interface{}{
+		"payload":    encrypted,
+		"errorcount": errorCount,
+		"errors":     errors,
+	}
+	if ttl == 0 {
+		return &logical.Response{Data: respData}, nil
+	}
 
-	RSACiphertext, err := rsa.EncryptOAEP(sha256.New(), rng, pub.(*rsa.PublicKey), []byte(keyNonce), label)
+	// record the leased payload so the lease's revocation can be published
+	timeText, err := time.Now().MarshalText()
 	if err != nil {
 		return nil, err
 	}
-
-	// Since encryption is a randomized function, ciphertext will be
-	// different each time.
-
-	// combine RSA with AEs cipher bytes
-	combined := make([]byte, 2)
-	binary.LittleEndian.PutUint16(combined, uint16(len(RSACiphertext)))
-	combined = append(combined, RSACiphertext...)
-	combined = append(combined, ciphertext...)
-
-	// Wrap in Armor
-	armourLines := []string{
-		"-----BEGIN E2E ENCRYPTED PAYLOAD-----",
-		"PAYLOAD_VERSION: 2.0",
-		"",
+	payloadEntry := E2ePayloadEntry{
+		ID:      payloadID,
+		Name:    payloadName,
+		Created: string(timeText),
 	}
-	armourLines = append(
-		armourLines,
-		splitB64(
-			string(
-				base64.StdEncoding.EncodeToString(combined)),
-			76,
-		)...,
-	)
-	armourLines = append(
-		armourLines,
-		"-----END E2E ENCRYPTED PAYLOAD-----",
-	)
-
-	// Return the Encrypted payload
-	resp := &logical.Response{
-		Data: map[string]interface{}{
-			"payload":    strings.Join(armourLines, "\n"),
-			"errorcount": errorCount,
-			"errors":     errors,
-		},
+	if !opts.Expires.IsZero() {
+		payloadEntry.Expires = opts.Expires.UTC().Format(time.RFC3339)
+	}
+	if oneTime {
+		payloadEntry.OneTime = deliveredFields(delivered)
+	}
+	if err := putJSON(ctx, req.Storage, "payloads/"+payloadID, payloadEntry); err != nil {
+		return nil, err
 	}
+
+	respData["payload_id"] = payloadID
+	resp = backend.Secret(secretPayloadType).Response(respData, map[string]interface{}{
+		"payload_id": payloadID,
+	})
+	resp.Secret.TTL = ttl
 	return resp, nil
 }
 
 // Populate kv references back into payload structure
 // (note needs to walk nested maps/arrays, see
 // https://stackoverflow.com/questions/29366038/looping-iterate-over-the-second-level-nested-json-in-go-lang)
-func populate(ctx context.Context, req *logical.Request, payload interface{}, errorCount *int, errors *[]string) error {
+// delivered collects the kv paths and top level fields that were interpolated.
+func (backend *E2eBackend) populate(ctx context.Context, req *logical.Request, payload interface{}, errorCount *int, errors *[]string, delivered map[string]map[string]bool) error {
 	reflectPayload := reflect.ValueOf(payload)
 	p := reflectPayload
 	if reflectPayload.Type().Kind() == reflect.Struct {
@@ -217,7 +268,7 @@ func populate(ctx context.Context, req *logical.Request, payload interface{}, er
 
 		tv := reflect.ValueOf(v).Kind()
 		if tv == reflect.Map || tv == reflect.Struct {
-			err := populate(ctx, req, v, errorCount, errors)
+			err := backend.populate(ctx, req, v, errorCount, errors, delivered)
 			if err != nil {
 				return err
 			}
@@ -237,18 +288,20 @@ func populate(ctx context.Context, req *logical.Request, payload interface{}, er
 					continue
 				}
 
-				s, err := req.Storage.Get(ctx, secParts[0])
+				// only kv secrets may be interpolated, never backend state such as the keyring
+				if !strings.HasPrefix(secParts[0], "kv/") {
+					payloadError(payload, k, "Error: only kv/ secrets can be interpolated", errorCount, errors)
+					continue
+				}
+
+				vData, err := backend.getKV(ctx, req.Storage, secParts[0])
 				if err != nil {
 					payloadError(payload, k, fmt.Sprintf("Error: in storage get request: %s", err), errorCount, errors)
 					continue
 				}
-				if s != nil {
-					vData := map[string]interface{}{}
-					if err := json.Unmarshal(s.Value, &vData); err != nil {
-						payloadError(payload, k, fmt.Sprintf("Error: unmarshalling json: %s", err), errorCount, errors)
-						continue
-					}
-
+				if vData != nil {
+					// text/template, as secrets are interpolated into JSON,
+					// not HTML, so must not be escaped
 					accessor := fmt.Sprintf(".%s", secParts[1])
 					tmpl, err := template.New("eval").Parse(fmt.Sprintf("{{%s}}", accessor))
 					if err != nil {
@@ -269,6 +322,11 @@ func populate(ctx context.Context, req *logical.Request, payload interface{}, er
 
 					payload.(map[string]interface{})[fieldName] = b.String()
 					delete(payload.(map[string]interface{}), k)
+
+					if delivered[secParts[0]] == nil {
+						delivered[secParts[0]] = map[string]bool{}
+					}
+					delivered[secParts[0]][strings.SplitN(secParts[1], ".", 2)[0]] = true
 				} else {
 					payloadError(payload, k, "Error: path not found", errorCount, errors)
 				}
@@ -291,18 +349,6 @@ func generateRandomBytes(n int) ([]byte, error) {
 	return b, nil
 }
 
-// https://stackoverflow.com/questions/45412089/split-a-base64-line-into-chunks
-func splitB64(s string, size int) []string {
-	ss := make([]string, 0, len(s)/size+1)
-	for len(s) > 0 {
-		if len(s) < size {
-			size = len(s)
-		}
-		ss, s = append(ss, s[:size]), s[size:]
-	}
-	return ss
-}
-
 func payloadError(payload interface{}, key string, errmsg string, errorCount *int, errors *[]string) {
 	log.Println(errmsg)
 	payload.(map[string]interface{})[key] = errmsg
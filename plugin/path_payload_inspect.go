@@ -0,0 +1,138 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"gitlab.com/gbevan/vault-e2e-plugin/client"
+)
+
+const e2ePayloadInspectHelpDescription = `
+Reports what can be learnt of an encrypted payload without its private key, to
+triage payload files: the headers (version, key agreement, cipher, compression,
+stream, payload id), the RSA block length, the recipients' key ids and any
+OpenPGP signatures. Recipients identified by their key id are matched to their
+enrolements, and leased payloads to the recipient and time they were issued,
+and whether they have been revoked. None of it is authenticated until the
+payload is decrypted.
+
+  vault write e2e/payload/inspect payload=@payload.txt
+`
+
+func pathPayloadInspect(backend *E2eBackend) []*framework.Path {
+	paths := []*framework.Path{
+		&framework.Path{
+			// ahead of payload/<name>, so an enrolement called inspect cannot
+			// be sent payloads
+			Pattern:         "payload/inspect",
+			HelpSynopsis:    "E2E Encrypted Payload Inspection",
+			HelpDescription: e2ePayloadInspectHelpDescription,
+			Fields: map[string]*framework.FieldSchema{
+				"payload": {
+					Type:        framework.TypeString,
+					Description: "Encrypted payload, in any output format",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: backend.pathPayloadInspect,
+			},
+		},
+	}
+	return paths
+}
+
+func (backend *E2eBackend) pathPayloadInspect(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	payload := data.Get("payload").(string)
+	if payload == "" {
+		return logical.ErrorResponse("no payload provided"), logical.ErrInvalidRequest
+	}
+	inspection, err := client.Inspect(strings.NewReader(payload))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+
+	enrolements, err := matchEnrolements(ctx, req.Storage, inspection.Recipients)
+	if err != nil {
+		return nil, err
+	}
+	respData := map[string]interface{}{
+		"inspection":  inspection,
+		"enrolements": enrolements,
+	}
+
+	// leased payloads are recorded with their recipient and issue time until
+	// their lease ends, then only their revocation is
+	if inspection.PayloadID != "" {
+		payloadEntry, err := getPayloadEntry(ctx, req.Storage, inspection.PayloadID)
+		if err != nil {
+			return nil, err
+		}
+		if payloadEntry != nil {
+			respData["leased_payload"] = map[string]interface{}{
+				"id":      payloadEntry.ID,
+				"name":    payloadEntry.Name,
+				"created": payloadEntry.Created,
+				"revoked": payloadEntry.Revoked,
+			}
+		} else {
+			revocation, err := getRevocation(ctx, req.Storage, inspection.PayloadID)
+			if err != nil {
+				return nil, err
+			}
+			if revocation != nil {
+				respData["leased_payload"] = map[string]interface{}{
+					"id":      inspection.PayloadID,
+					"revoked": revocation.Revoked,
+				}
+			}
+		}
+	}
+
+	return &logical.Response{Data: respData}, nil
+}
+
+// matchEnrolements returns the names of the enrolements whose keys the
+// recipients' key ids identify: the fingerprint (JWE kid), the OpenPGP key id,
+// or the start of the fingerprint (age SSH recipient tag)
+func matchEnrolements(ctx context.Context, s logical.Storage, recipients []client.Recipient) ([]string, error) {
+	matched := []string{}
+	keyIDs := []client.Recipient{}
+	for _, recipient := range recipients {
+		if recipient.KeyID != "" {
+			keyIDs = append(keyIDs, recipient)
+		}
+	}
+	if len(keyIDs) == 0 {
+		return matched, nil
+	}
+
+	names, err := s.List(ctx, "enrole/")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		entry, err := s.Get(ctx, "enrole/"+name)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		var enrole E2eEnrolementEntry
+		if err := json.Unmarshal(entry.Value, &enrole); err != nil {
+			return nil, err
+		}
+
+		for _, recipient := range keyIDs {
+			if recipient.KeyID == enrole.Fingerprint || recipient.KeyID == enrole.KeyID ||
+				(strings.HasPrefix(recipient.Type, "ssh-") && strings.HasPrefix(enrole.Fingerprint, recipient.KeyID)) {
+				matched = append(matched, name)
+				break
+			}
+		}
+	}
+	return matched, nil
+}
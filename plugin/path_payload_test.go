@@ -0,0 +1,294 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"gitlab.com/gbevan/vault-e2e-plugin/client"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+func TestPopulateInterpolatesSecretsUnescaped(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	backend := Backend(ctx, &logical.BackendConfig{StorageView: storage, System: logical.TestSystemView()})
+
+	tests := []string{
+		"a&b",
+		"<script>",
+		`"quoted" 'single'`,
+		"base64+/w==",
+		"https://example.com/?a=1&b=2",
+	}
+	for _, value := range tests {
+		err := backend.putKV(ctx, storage, "kv/app/db", map[string]interface{}{
+			"password": value,
+			"nested":   map[string]interface{}{"token": value},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payload := map[string]interface{}{
+			"password@/e2e/kv/app/db.password":  true,
+			"token@/e2e/kv/app/db.nested.token": true,
+			"plain":                             value,
+		}
+		errorCount := 0
+		errors := []string{}
+		err = backend.populate(ctx, &logical.Request{Storage: storage}, payload, &errorCount, &errors, map[string]map[string]bool{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errorCount != 0 {
+			t.Fatalf("%q: %v", value, errors)
+		}
+		for _, field := range []string{"password", "token", "plain"} {
+			if payload[field] != value {
+				t.Errorf("%s interpolated as %q, want %q", field, payload[field], value)
+			}
+		}
+	}
+}
+
+// armouredOpenPGPKey returns an OpenPGP key pair, ASCII armoured
+func armouredOpenPGPKey(t *testing.T) (string, []byte) {
+	t.Helper()
+	entity, err := openpgp.NewEntity("e2e test", "", "e2e@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pub, private bytes.Buffer
+	for _, out := range []struct {
+		buf       *bytes.Buffer
+		blockType string
+		serialise func(w *bytes.Buffer) error
+	}{
+		{&pub, openpgp.PublicKeyType, func(w *bytes.Buffer) error { return entity.Serialize(w) }},
+		{&private, openpgp.PrivateKeyType, func(w *bytes.Buffer) error { return entity.SerializePrivate(w, nil) }},
+	} {
+		w, err := armor.Encode(out.buf, out.blockType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var raw bytes.Buffer
+		if err := out.serialise(&raw); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(raw.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pub.String(), private.Bytes()
+}
+
+// TestPayloadMatchesOfflineEncryption checks the payload endpoint and offline
+// encryption (client.Encrypt, as the encrypt tool uses) give payloads of the
+// same format and headers, for every key type and the formats it allows
+func TestPayloadMatchesOfflineEncryption(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	backend := Backend(ctx, &logical.BackendConfig{StorageView: storage, System: logical.TestSystemView()})
+	signingKey, err := backend.getSigningKey(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	type recipient struct {
+		name    string
+		pubKey  string
+		private []byte
+		formats []string
+	}
+	e2eFormats := []string{"", envelope.FormatArmour, envelope.FormatBinary}
+	jweFormats := []string{envelope.FormatJWE, envelope.FormatJWEJSON}
+	var recipients []recipient
+	for _, keyType := range []string{client.KeyTypeRSA, client.KeyTypeP256, client.KeyTypeP384, client.KeyTypeX25519, client.KeyTypeMLKEM768X25519} {
+		privateKey, pubKey, err := client.GenerateKey(keyType, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formats := e2eFormats
+		if keyType != client.KeyTypeMLKEM768X25519 {
+			formats = append(formats, jweFormats...)
+		}
+		recipients = append(recipients, recipient{keyType, string(pem.EncodeToMemory(pubKey)), pem.EncodeToMemory(privateKey), formats})
+	}
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients = append(recipients, recipient{"age", identity.Recipient().String(), []byte(identity.String() + "\n"), []string{"", envelope.FormatAge, envelope.FormatAgeBinary}})
+	pgpPub, pgpPrivate := armouredOpenPGPKey(t)
+	recipients = append(recipients, recipient{"pgp", pgpPub, pgpPrivate, []string{"", envelope.FormatPGP}})
+
+	for _, r := range recipients {
+		resp, err := backend.pathEnroleCreate(ctx, &logical.Request{Storage: storage, Path: "enrole/" + r.name}, &framework.FieldData{
+			Raw:    map[string]interface{}{"name": r.name, "pubkey": r.pubKey},
+			Schema: createE2eEncroleSchema,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("%s: enrole: %v %v", r.name, resp, err)
+		}
+		var enrole E2eEnrolementEntry
+		entry, err := storage.Get(ctx, "enrole/"+r.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(entry.Value, &enrole); err != nil {
+			t.Fatal(err)
+		}
+		keySource := &client.KeySource{PrivateKeyFile: filepath.Join(dir, r.name)}
+		if err := ioutil.WriteFile(keySource.PrivateKeyFile, r.private, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, format := range r.formats {
+			for _, sign := range []bool{false, true} {
+				name := fmt.Sprintf("%s %q sign=%v", r.name, format, sign)
+				resp, err := backend.pathPayloadCreate(ctx, &logical.Request{Storage: storage, Path: "payload/" + r.name}, &framework.FieldData{
+					Raw:    map[string]interface{}{"format": format, "sign": sign, "payload": map[string]interface{}{"hello": "world"}},
+					Schema: createE2ePayloadSchema,
+				})
+				if resp == nil {
+					t.Fatalf("%s: payload: %v", name, err)
+				}
+				// only the armour, binary and jwe-json formats carry a signature
+				signable := format == "" && envelope.DefaultFormat(enrole.KeyType) == envelope.FormatArmour ||
+					format == envelope.FormatArmour || format == envelope.FormatBinary || format == envelope.FormatJWEJSON
+				if sign && !signable {
+					if !resp.IsError() {
+						t.Errorf("%s: signing accepted", name)
+					}
+					continue
+				}
+				if resp.IsError() {
+					t.Fatalf("%s: payload: %v", name, resp)
+				}
+				var offlineSigningKey *envelope.SigningKey
+				if sign {
+					offlineSigningKey = signingKey
+				}
+				checkPayload(t, name, resp, r.pubKey, keySource, &envelope.EncryptOptions{
+					Format:      format,
+					Cipher:      enrole.Cipher,
+					Compression: enrole.Compression,
+					KeyID:       enrole.Fingerprint,
+					SigningKey:  offlineSigningKey,
+				})
+			}
+		}
+	}
+}
+
+// checkPayload checks a payload from the plugin decrypts, and matches one
+// encrypted offline with opts
+func checkPayload(t *testing.T, name string, resp *logical.Response, pubKey string, keySource *client.KeySource, opts *envelope.EncryptOptions) {
+	t.Helper()
+	plaintext := `{"hello":"world"}`
+	format := opts.Format
+	fromPlugin, ok := resp.Data["payload"].(string)
+	if !ok {
+		encoded, err := json.Marshal(resp.Data["payload"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromPlugin = string(encoded)
+	}
+
+	offline, err := client.Encrypt([]byte(pubKey), []byte(plaintext), opts)
+	if err != nil {
+		t.Fatalf("%s: offline: %s", name, err)
+	}
+
+	// the signatures differ with the content key
+	var inspections []*client.Inspection
+	for _, payload := range []string{fromPlugin, string(offline)} {
+		// binary age files are base64 encoded, to be decoded by the recipient
+		if format == envelope.FormatAgeBinary {
+			decoded, err := base64.StdEncoding.DecodeString(payload)
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			payload = string(decoded)
+		}
+		inspection, err := client.Inspect(strings.NewReader(payload))
+		if err != nil {
+			t.Fatalf("%s: inspect: %s", name, err)
+		}
+		if (inspection.Signature != "") != (opts.SigningKey != nil) {
+			t.Errorf("%s: signature %q", name, inspection.Signature)
+		}
+		inspection.Signature = ""
+		inspections = append(inspections, inspection)
+
+		decrypted, _, err := (&client.Client{}).NewReaderFromSource(strings.NewReader(payload), keySource)
+		if err != nil {
+			t.Fatalf("%s: decrypt: %s", name, err)
+		}
+		if got, err := ioutil.ReadAll(decrypted); err != nil || string(got) != plaintext {
+			t.Errorf("%s: decrypted %q %v", name, got, err)
+		}
+	}
+	if !reflect.DeepEqual(inspections[0], inspections[1]) {
+		t.Errorf("%s: plugin payload %+v, offline %+v", name, inspections[0], inspections[1])
+	}
+}
+
+func TestUnsignedRSAPayloadIsVersion20(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	backend := Backend(ctx, &logical.BackendConfig{StorageView: storage, System: logical.TestSystemView()})
+	_, pubKey, err := client.GenerateKey(client.KeyTypeRSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := backend.pathEnroleCreate(ctx, &logical.Request{Storage: storage, Path: "enrole/rsa"}, &framework.FieldData{
+		Raw:    map[string]interface{}{"name": "rsa", "pubkey": string(pem.EncodeToMemory(pubKey))},
+		Schema: createE2eEncroleSchema,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("enrole: %v %v", resp, err)
+	}
+
+	// payloads are unsigned unless asked, so a plain RSA payload has only the
+	// version header, as recipients that predate headers expect
+	for _, sign := range []bool{false, true} {
+		resp, err = backend.pathPayloadCreate(ctx, &logical.Request{Storage: storage, Path: "payload/rsa"}, &framework.FieldData{
+			Raw:    map[string]interface{}{"sign": sign, "payload": map[string]interface{}{"hello": "world"}},
+			Schema: createE2ePayloadSchema,
+		})
+		if err != nil || resp.IsError() {
+			t.Fatalf("payload: %v %v", resp, err)
+		}
+		h, _, err := envelope.DecodeArmour(strings.NewReader(resp.Data["payload"].(string)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"PAYLOAD_VERSION: " + envelope.Version20}
+		if sign {
+			want = []string{"PAYLOAD_VERSION: " + envelope.Version21, "SIGNING_KEY: " + h.SigningKey, "SIGNATURE: " + h.Signature}
+		}
+		if !reflect.DeepEqual(h.Lines(), want) || (sign && h.Signature == "") {
+			t.Errorf("sign=%v headers %q", sign, h.Lines())
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const e2eRevocationsHelpDescription = `
+The published list of revoked payload ids, with the time they were revoked.
+Payloads are revoked when their lease is revoked or expires. This path does
+not require a token, so recipients can check a payload before trusting it:
+
+  decrypt -privkey key.pem -revocations https://vault:8200/v1/e2e/revocations < payload.txt
+
+A revocation is dropped from the list once the payload's EXPIRES has passed,
+as recipients refuse it anyway. Revocations of payloads without an EXPIRES
+are kept.
+`
+
+// E2eRevocationEntry structure recording a revoked payload id
+type E2eRevocationEntry struct { // nolint
+	Revoked string `json:"revoked" structs:"revoked" mapstructure:"revoked"`
+
+	// the revoked payload's EXPIRES (RFC 3339), after which the revocation
+	// can be dropped, empty if it has none
+	Expires string `json:"expires,omitempty" structs:"expires" mapstructure:"expires"`
+}
+
+// Expired reports whether the revoked payload has expired by now, so its
+// revocation need no longer be published
+func (revocation *E2eRevocationEntry) Expired(now time.Time) (bool, error) {
+	if revocation.Expires == "" {
+		return false, nil
+	}
+	expires, err := time.Parse(time.RFC3339, revocation.Expires)
+	if err != nil {
+		return false, err
+	}
+	return now.After(expires), nil
+}
+
+func pathRevocations(backend *E2eBackend) []*framework.Path {
+	paths := []*framework.Path{
+		&framework.Path{
+			Pattern:         "revocations",
+			HelpSynopsis:    "E2E Payload Revocation List",
+			HelpDescription: e2eRevocationsHelpDescription,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: backend.pathRevocationsRead,
+			},
+		},
+	}
+	return paths
+}
+
+func (backend *E2eBackend) pathRevocationsRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ids, err := req.Storage.List(ctx, "revocations/")
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := map[string]interface{}{}
+	for _, id := range ids {
+		revocation, err := getRevocation(ctx, req.Storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if revocation != nil {
+			revoked[id] = revocation.Revoked
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"revoked": revoked,
+		},
+	}, nil
+}
+
+// getRevocation loads a payload revocation, returning nil if there is none.
+// Revocations written before payload expiry was recorded are the bare
+// revocation time.
+func getRevocation(ctx context.Context, s logical.Storage, id string) (*E2eRevocationEntry, error) {
+	entry, err := s.Get(ctx, "revocations/"+id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var revocation E2eRevocationEntry
+	if len(entry.Value) == 0 || entry.Value[0] != '{' {
+		revocation.Revoked = string(entry.Value)
+		return &revocation, nil
+	}
+	if err := json.Unmarshal(entry.Value, &revocation); err != nil {
+		return nil, err
+	}
+	return &revocation, nil
+}
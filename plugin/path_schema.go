@@ -0,0 +1,230 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+var createE2eSchemaSchema = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "The payload name the schema applies to",
+	},
+	"schema": {
+		Type:        framework.TypeMap,
+		Description: "JSON Schema document",
+	},
+}
+
+const e2eSchemaHelpDescription = `
+JSON Schemas validating kv secrets and payload forms.
+
+schema/kv/<path> applies to the kv secret at <path> and every secret beneath
+it, the most specific registered path wins. It is checked on kv writes and
+secret generation.
+
+schema/payload/<name> applies to payload/<name> requests (or to any request
+naming it with the "schema" field) and is checked against the interpolated
+payload before it is encrypted.
+
+Supported keywords: type, enum, const, properties, required,
+additionalProperties, items, minItems, maxItems, minLength, maxLength,
+pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum, allOf, anyOf,
+oneOf and not.
+`
+
+func pathSchema(backend *E2eBackend) []*framework.Path {
+	paths := []*framework.Path{
+		&framework.Path{
+			Pattern:         "schema/kv/.*",
+			HelpSynopsis:    "E2E KV Secret Schemas",
+			HelpDescription: e2eSchemaHelpDescription,
+			Fields:          createE2eSchemaSchema,
+			ExistenceCheck:  backend.pathExistenceCheck,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.CreateOperation: backend.pathSchemaWrite,
+				logical.UpdateOperation: backend.pathSchemaWrite,
+				logical.DeleteOperation: backend.pathSchemaDelete,
+				logical.ReadOperation:   backend.pathSchemaRead,
+				logical.ListOperation:   backend.pathSchemaList,
+			},
+		},
+		&framework.Path{
+			Pattern:         fmt.Sprintf("schema/payload/"),
+			HelpSynopsis:    "E2E Payload Schemas",
+			HelpDescription: e2eSchemaHelpDescription,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: backend.pathSchemaList,
+			},
+		},
+		&framework.Path{
+			Pattern:         fmt.Sprintf("schema/payload/%s", framework.GenericNameRegex("name")),
+			HelpSynopsis:    "E2E Payload Schemas",
+			HelpDescription: e2eSchemaHelpDescription,
+			Fields:          createE2eSchemaSchema,
+			ExistenceCheck:  backend.pathExistenceCheck,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.CreateOperation: backend.pathSchemaWrite,
+				logical.UpdateOperation: backend.pathSchemaWrite,
+				logical.DeleteOperation: backend.pathSchemaDelete,
+				logical.ReadOperation:   backend.pathSchemaRead,
+			},
+		},
+	}
+	return paths
+}
+
+func (backend *E2eBackend) pathSchemaWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if strings.HasSuffix(req.Path, "/") {
+		return logical.ErrorResponse("a path is required to register a schema"), logical.ErrInvalidRequest
+	}
+
+	doc, ok := data.GetOk("schema")
+	if !ok {
+		return logical.ErrorResponse("no schema provided"), logical.ErrInvalidRequest
+	}
+	if _, err := compileSchema(doc); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid schema: %s", err)), logical.ErrInvalidRequest
+	}
+
+	timeText, err := time.Now().MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	schemaEntry := E2eSchemaEntry{
+		Path:    strings.TrimPrefix(req.Path, "schema/"),
+		Schema:  doc.(map[string]interface{}),
+		Created: string(timeText),
+	}
+
+	dataJSON, err := json.Marshal(schemaEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = req.Storage.Put(ctx, &logical.StorageEntry{
+		Key:   req.Path,
+		Value: dataJSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (backend *E2eBackend) pathSchemaRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	schemaEntry, err := getSchema(ctx, req.Storage, req.Path)
+	if err != nil {
+		return nil, err
+	}
+	if schemaEntry == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"path":    schemaEntry.Path,
+			"schema":  schemaEntry.Schema,
+			"created": schemaEntry.Created,
+		},
+	}, nil
+}
+
+func (backend *E2eBackend) pathSchemaDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := req.Storage.Delete(ctx, req.Path); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (backend *E2eBackend) pathSchemaList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, req.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+func getSchema(ctx context.Context, s logical.Storage, key string) (*E2eSchemaEntry, error) {
+	entry, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var schemaEntry E2eSchemaEntry
+	if err := json.Unmarshal(entry.Value, &schemaEntry); err != nil {
+		return nil, err
+	}
+	return &schemaEntry, nil
+}
+
+// kvSchemaFor finds the most specific schema registered for a kv key, e.g.
+// for kv/Customer1/Actor1/secret-form it tries schema/kv/Customer1/Actor1/secret-form,
+// then schema/kv/Customer1/Actor1 and finally schema/kv/Customer1
+func kvSchemaFor(ctx context.Context, s logical.Storage, key string) (*E2eSchemaEntry, error) {
+	path := strings.Trim(strings.TrimPrefix(key, "kv/"), "/")
+	for path != "" {
+		schemaEntry, err := getSchema(ctx, s, "schema/kv/"+path)
+		if err != nil {
+			return nil, err
+		}
+		if schemaEntry != nil {
+			return schemaEntry, nil
+		}
+
+		i := strings.LastIndex(path, "/")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return nil, nil
+}
+
+// validateAgainstSchema validates value against a registered schema, returning
+// a user facing error response listing every validation failure, or nil if valid
+func validateAgainstSchema(schemaEntry *E2eSchemaEntry, value interface{}) (*logical.Response, error) {
+	if schemaEntry == nil {
+		return nil, nil
+	}
+
+	schema, err := compileSchema(schemaEntry.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("stored schema %q is invalid: %s", schemaEntry.Path, err)
+	}
+
+	errs, err := schema.Validate(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) == 0 {
+		return nil, nil
+	}
+
+	for i := range errs {
+		errs[i] = fmt.Sprintf("%3d) %s", i+1, errs[i])
+	}
+	return logical.ErrorResponse(fmt.Sprintf("validation against schema %q failed:\n%s", schemaEntry.Path, strings.Join(errs, "\n"))), nil
+}
+
+// validateKVSchema validates a kv secret against the schema for its path
+func validateKVSchema(ctx context.Context, s logical.Storage, key string, value interface{}) (*logical.Response, error) {
+	schemaEntry, err := kvSchemaFor(ctx, s, key)
+	if err != nil {
+		return nil, err
+	}
+	return validateAgainstSchema(schemaEntry, value)
+}
@@ -0,0 +1,49 @@
+package e2e
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const e2eSigningKeyHelpDescription = `
+The public key of the Ed25519 key the plugin signs payloads with, and its key
+id (the payloads' SIGNING_KEY). The key is generated on first use and seal
+wrapped. This path does not require a token, so recipients can verify a
+payload was produced by the plugin:
+
+  decrypt -privkey key.pem -signing-key https://vault:8200/v1/e2e/signingkey < payload.txt
+`
+
+func pathSigningKey(backend *E2eBackend) []*framework.Path {
+	paths := []*framework.Path{
+		&framework.Path{
+			Pattern:         "signingkey",
+			HelpSynopsis:    "E2E Payload Signing Key",
+			HelpDescription: e2eSigningKeyHelpDescription,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: backend.pathSigningKeyRead,
+			},
+		},
+	}
+	return paths
+}
+
+func (backend *E2eBackend) pathSigningKeyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	key, err := backend.getSigningKey(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	pubPem, err := key.PublicKeyPEM()
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": pubPem,
+			"key_id":     key.ID,
+		},
+	}, nil
+}
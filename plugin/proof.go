@@ -0,0 +1,136 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+// proofs of possession an enrolement can give
+const (
+	proofCSR        = "csr"
+	proofChallenge  = "challenge"
+	proofDecryption = "decryption"
+)
+
+// E2eChallengeEntry structure representing a pending enrolement's challenge,
+// to be signed by its private key, or decrypted with it where the challenge
+// was encrypted to its public key
+type E2eChallengeEntry struct { // nolint
+	Challenge string `json:"challenge" structs:"challenge" mapstructure:"challenge"`
+
+	Expires time.Time `json:"expires" structs:"expires" mapstructure:"expires"`
+
+	// fingerprint of the public key the challenge was encrypted to, empty if
+	// it was not
+	Fingerprint string `json:"fingerprint" structs:"fingerprint" mapstructure:"fingerprint"`
+}
+
+// newChallenge issues a single use challenge for an enrolement, replacing
+// any it was issued before. fingerprint is that of the public key the
+// challenge is encrypted to, empty if it is not.
+func newChallenge(ctx context.Context, s logical.Storage, name string, fingerprint string, ttl time.Duration) (*E2eChallengeEntry, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	challenge := &E2eChallengeEntry{
+		Challenge:   fmt.Sprintf("vault-e2e-enrole:%s:%s", name, base64.RawURLEncoding.EncodeToString(nonce)),
+		Expires:     time.Now().Add(ttl),
+		Fingerprint: fingerprint,
+	}
+	if err := putJSON(ctx, s, "challenges/"+name, challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// getChallenge loads an enrolement's unexpired challenge
+func getChallenge(ctx context.Context, s logical.Storage, name string) (*E2eChallengeEntry, error) {
+	entry, err := s.Get(ctx, "challenges/"+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var challenge E2eChallengeEntry
+	if err := json.Unmarshal(entry.Value, &challenge); err != nil {
+		return nil, err
+	}
+	if time.Now().After(challenge.Expires) {
+		return nil, nil
+	}
+	return &challenge, nil
+}
+
+// checkProof verifies an enrolement's proof of possession of its private
+// key, a CSR (already verified and checked to name the enrolement, csrPubKey
+// is its public key), a
+// signature over its challenge, or the response to its challenge decrypted
+// (for keys that cannot sign), returning which was given, "" if none was and
+// none is required, or an error response
+func checkProof(ctx context.Context, s logical.Storage, name string, pub crypto.PublicKey, keyType string, der []byte, csrPubKey string, signature string, response string) (string, *logical.Response, error) {
+	if csrPubKey != "" {
+		_, _, csrDer, err := envelope.ParsePublicKey(csrPubKey)
+		if err != nil {
+			return "", nil, err
+		}
+		if !bytes.Equal(csrDer, der) {
+			return "", logical.ErrorResponse("csr is not for pubkey"), logical.ErrInvalidRequest
+		}
+		return proofCSR, nil, nil
+	}
+
+	if signature != "" {
+		challenge, err := getChallenge(ctx, s, name)
+		if err != nil {
+			return "", nil, err
+		}
+		if challenge == nil {
+			return "", logical.ErrorResponse(fmt.Sprintf("no current challenge, fetch one from enrole/%s/challenge", name)), logical.ErrInvalidRequest
+		}
+		if err := envelope.VerifyProof(pub, keyType, der, []byte(challenge.Challenge), signature); err != nil {
+			return "", logical.ErrorResponse(fmt.Sprintf("invalid signature: %s", err)), logical.ErrInvalidRequest
+		}
+		return proofChallenge, nil, nil
+	}
+
+	if response != "" {
+		challenge, err := getChallenge(ctx, s, name)
+		if err != nil {
+			return "", nil, err
+		}
+		if challenge == nil || challenge.Fingerprint == "" {
+			return "", logical.ErrorResponse(fmt.Sprintf("no current encrypted challenge, fetch one from enrole/%s/challenge with the pubkey", name)), logical.ErrInvalidRequest
+		}
+		if challenge.Fingerprint != envelope.Fingerprint(der) {
+			return "", logical.ErrorResponse("challenge was encrypted to another pubkey"), logical.ErrInvalidRequest
+		}
+		// decrypt and vault write response=@file leave a trailing newline
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(response)), []byte(challenge.Challenge)) != 1 {
+			return "", logical.ErrorResponse("response is not the decrypted challenge"), logical.ErrInvalidRequest
+		}
+		return proofDecryption, nil, nil
+	}
+
+	config, err := getConfig(ctx, s)
+	if err != nil {
+		return "", nil, err
+	}
+	if config.RequireProof {
+		return "", logical.ErrorResponse("proof of possession required: a csr, or a signature over (or the decrypted response to) the challenge from enrole/" + name + "/challenge"), logical.ErrInvalidRequest
+	}
+	return "", nil, nil
+}
@@ -0,0 +1,155 @@
+package e2e
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"gitlab.com/gbevan/vault-e2e-plugin/client"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+// writeKey writes a private key for client.DecryptChallenge, returning its
+// KeySource
+func writeKey(t *testing.T, privateKey []byte) *client.KeySource {
+	t.Helper()
+	privateFile := filepath.Join(t.TempDir(), "key")
+	if err := ioutil.WriteFile(privateFile, privateKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &client.KeySource{PrivateKeyFile: privateFile}
+}
+
+func TestDecryptionProof(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	backend := Backend(ctx, &logical.BackendConfig{StorageView: storage, System: logical.TestSystemView()})
+	challengeFields := pathEnrole(backend)[0].Fields
+
+	type testKey struct {
+		name      string
+		pubKey    string
+		keySource *client.KeySource
+	}
+	var keys []testKey
+	for _, keyType := range []string{client.KeyTypeX25519, client.KeyTypeMLKEM768X25519} {
+		privateKey, pubKey, err := client.GenerateKey(keyType, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, testKey{keyType, string(pem.EncodeToMemory(pubKey)), writeKey(t, pem.EncodeToMemory(privateKey))})
+	}
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys = append(keys, testKey{"age", identity.Recipient().String(), writeKey(t, []byte(identity.String()+"\n"))})
+
+	for i, key := range keys {
+		resp, err := backend.pathEnroleChallenge(ctx, &logical.Request{Storage: storage}, &framework.FieldData{
+			Raw:    map[string]interface{}{"name": key.name, "pubkey": key.pubKey},
+			Schema: challengeFields,
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", key.name, err)
+		}
+		if _, ok := resp.Data["challenge"]; ok {
+			t.Errorf("%s: challenge returned in the clear", key.name)
+		}
+		encrypted, _ := resp.Data["encrypted_challenge"].(string)
+		response, err := client.DecryptChallenge(encrypted, key.keySource)
+		if err != nil {
+			t.Fatalf("%s: %s", key.name, err)
+		}
+
+		pub, keyType, der, err := envelope.ParsePublicKey(key.pubKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, resp, _ := checkProof(ctx, storage, key.name, pub, keyType, der, "", "", response+"x"); resp == nil {
+			t.Errorf("%s: wrong response accepted", key.name)
+		}
+
+		// the challenge only proves possession of the key it was encrypted to
+		other := keys[(i+1)%len(keys)]
+		otherPub, otherType, otherDer, err := envelope.ParsePublicKey(other.pubKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, resp, _ := checkProof(ctx, storage, key.name, otherPub, otherType, otherDer, "", "", response); resp == nil {
+			t.Errorf("%s: response accepted for %s", key.name, other.name)
+		}
+
+		proof, resp, err := checkProof(ctx, storage, key.name, pub, keyType, der, "", "", response)
+		if resp != nil || err != nil {
+			t.Fatalf("%s: %v %v", key.name, resp, err)
+		}
+		if proof != proofDecryption {
+			t.Errorf("%s: proof %q, want %q", key.name, proof, proofDecryption)
+		}
+	}
+
+	// a challenge issued without a pubkey cannot be answered by decryption
+	if _, err := newChallenge(ctx, storage, "plain", "", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	pub, keyType, der, err := envelope.ParsePublicKey(keys[0].pubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, resp, _ := checkProof(ctx, storage, "plain", pub, keyType, der, "", "", "anything"); resp == nil {
+		t.Error("response accepted for an unencrypted challenge")
+	}
+}
+
+func TestCSRProofNamesEnrolement(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	backend := Backend(ctx, &logical.BackendConfig{StorageView: storage, System: logical.TestSystemView()})
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr := func(template *x509.CertificateRequest) string {
+		der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	}
+	byCN := csr(&x509.CertificateRequest{Subject: pkix.Name{CommonName: "host-cn"}})
+	bySAN := csr(&x509.CertificateRequest{Subject: pkix.Name{CommonName: "other"}, DNSNames: []string{"host-san"}})
+
+	tests := []struct {
+		name string
+		csr  string
+		ok   bool
+	}{
+		{"host-cn", byCN, true},
+		{"host-san", bySAN, true},
+		// a request replayed for another enrolement
+		{"replayed", byCN, false},
+		{"other-san", bySAN, false},
+	}
+	for _, test := range tests {
+		resp, _ := backend.pathEnroleCreate(ctx, &logical.Request{Storage: storage}, &framework.FieldData{
+			Raw:    map[string]interface{}{"name": test.name, "csr": test.csr},
+			Schema: createE2eEncroleSchema,
+		})
+		if failed := resp != nil && resp.IsError(); failed == test.ok {
+			t.Errorf("%s: %v", test.name, resp)
+		}
+	}
+}
@@ -0,0 +1,499 @@
+package e2e
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// E2eSchemaEntry structure representing a JSON Schema registered against a kv
+// path prefix or a payload name
+type E2eSchemaEntry struct { // nolint
+	Path string `json:"path" structs:"path" mapstructure:"path"`
+
+	Schema map[string]interface{} `json:"schema" structs:"schema" mapstructure:"schema"`
+
+	Created string `json:"created" structs:"created" mapstructure:"created"`
+}
+
+// jsonSchema is a compiled subset of JSON Schema (draft 7) covering the
+// keywords useful for describing kv secrets and payload forms:
+//
+//	type, enum, const, properties, required, additionalProperties, items,
+//	minItems, maxItems, minLength, maxLength, pattern, minimum, maximum,
+//	exclusiveMinimum, exclusiveMaximum, allOf, anyOf, oneOf, not, $ref
+//
+// $ref is resolved within the document (e.g. "#/definitions/port"), and as
+// in draft 7 its sibling keywords are ignored. Other keywords (title,
+// description, format, $schema...) are ignored.
+type jsonSchema struct {
+	Types                []string
+	Enum                 []interface{}
+	Const                interface{}
+	HasConst             bool
+	Properties           map[string]*jsonSchema
+	Required             []string
+	AdditionalProperties *jsonSchema
+	NoAdditional         bool
+	Items                *jsonSchema
+	MinItems             *int
+	MaxItems             *int
+	MinLength            *int
+	MaxLength            *int
+	Pattern              *regexp.Regexp
+	Minimum              *float64
+	Maximum              *float64
+	ExclusiveMinimum     *float64
+	ExclusiveMaximum     *float64
+	AllOf                []*jsonSchema
+	AnyOf                []*jsonSchema
+	OneOf                []*jsonSchema
+	Not                  *jsonSchema
+}
+
+var jsonSchemaTypes = map[string]bool{
+	"object":  true,
+	"array":   true,
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"null":    true,
+}
+
+// schemaCompiler compiles a JSON Schema document, resolving its $refs once
+// each (so recursive schemas compile to cycles)
+type schemaCompiler struct {
+	root interface{}
+	refs map[string]*jsonSchema
+}
+
+// compileSchema parses a JSON Schema document, rejecting malformed keywords
+// and unresolvable references
+func compileSchema(doc interface{}) (*jsonSchema, error) {
+	normalised, err := normaliseJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	c := &schemaCompiler{root: normalised, refs: map[string]*jsonSchema{}}
+	return c.compileAt(normalised, "#")
+}
+
+// compileRef compiles the schema a $ref points to within the document
+func (c *schemaCompiler) compileRef(ref string, at string) (*jsonSchema, error) {
+	if schema, ok := c.refs[ref]; ok {
+		return schema, nil
+	}
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("%s/$ref: only references within the schema (#...) are supported, got %q", at, ref)
+	}
+	pointer, err := url.PathUnescape(ref[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%s/$ref: %s", at, err)
+	}
+
+	// follow the JSON pointer from the document root
+	doc := c.root
+	if pointer != "" {
+		if !strings.HasPrefix(pointer, "/") {
+			return nil, fmt.Errorf("%s/$ref: unresolvable reference %q", at, ref)
+		}
+		for _, token := range strings.Split(pointer[1:], "/") {
+			token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+			switch d := doc.(type) {
+			case map[string]interface{}:
+				doc = d[token]
+			case []interface{}:
+				i, err := strconv.Atoi(token)
+				if err != nil || i < 0 || i >= len(d) {
+					return nil, fmt.Errorf("%s/$ref: unresolvable reference %q", at, ref)
+				}
+				doc = d[i]
+			default:
+				doc = nil
+			}
+			if doc == nil {
+				return nil, fmt.Errorf("%s/$ref: unresolvable reference %q", at, ref)
+			}
+		}
+	}
+
+	// the placeholder is filled in once compiled, so references back to it
+	// (recursive schemas) share it
+	schema := &jsonSchema{}
+	c.refs[ref] = schema
+	compiled, err := c.compileAt(doc, ref)
+	if err != nil {
+		return nil, err
+	}
+	*schema = *compiled
+	return schema, nil
+}
+
+func (c *schemaCompiler) compileAt(doc interface{}, at string) (*jsonSchema, error) {
+	if b, ok := doc.(bool); ok {
+		// boolean schemas: true accepts anything, false accepts nothing
+		if b {
+			return &jsonSchema{}, nil
+		}
+		return &jsonSchema{Not: &jsonSchema{}}, nil
+	}
+
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or boolean", at)
+	}
+
+	if r, ok := m["$ref"]; ok {
+		ref, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/$ref: must be a string", at)
+		}
+		return c.compileRef(ref, at)
+	}
+
+	schema := &jsonSchema{}
+	var err error
+
+	if t, ok := m["type"]; ok {
+		switch tv := t.(type) {
+		case string:
+			schema.Types = []string{tv}
+		case []interface{}:
+			for _, v := range tv {
+				s, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s/type: must be a string or array of strings", at)
+				}
+				schema.Types = append(schema.Types, s)
+			}
+		default:
+			return nil, fmt.Errorf("%s/type: must be a string or array of strings", at)
+		}
+		for _, t := range schema.Types {
+			if !jsonSchemaTypes[t] {
+				return nil, fmt.Errorf("%s/type: unknown type %q", at, t)
+			}
+		}
+	}
+
+	if e, ok := m["enum"]; ok {
+		enum, ok := e.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/enum: must be an array", at)
+		}
+		schema.Enum = enum
+	}
+
+	if c, ok := m["const"]; ok {
+		schema.Const = c
+		schema.HasConst = true
+	}
+
+	if p, ok := m["properties"]; ok {
+		props, ok := p.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/properties: must be an object", at)
+		}
+		schema.Properties = map[string]*jsonSchema{}
+		for name, sub := range props {
+			if schema.Properties[name], err = c.compileAt(sub, at+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if r, ok := m["required"]; ok {
+		req, ok := r.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/required: must be an array of strings", at)
+		}
+		for _, v := range req {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/required: must be an array of strings", at)
+			}
+			schema.Required = append(schema.Required, s)
+		}
+	}
+
+	if a, ok := m["additionalProperties"]; ok {
+		if b, isBool := a.(bool); isBool {
+			schema.NoAdditional = !b
+		} else if schema.AdditionalProperties, err = c.compileAt(a, at+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+
+	if i, ok := m["items"]; ok {
+		if schema.Items, err = c.compileAt(i, at+"/items"); err != nil {
+			return nil, err
+		}
+	}
+
+	for keyword, dest := range map[string]**int{
+		"minItems":  &schema.MinItems,
+		"maxItems":  &schema.MaxItems,
+		"minLength": &schema.MinLength,
+		"maxLength": &schema.MaxLength,
+	} {
+		if v, ok := m[keyword]; ok {
+			f, ok := v.(float64)
+			if !ok || f < 0 || f != math.Trunc(f) {
+				return nil, fmt.Errorf("%s/%s: must be a non-negative integer", at, keyword)
+			}
+			n := int(f)
+			*dest = &n
+		}
+	}
+
+	for keyword, dest := range map[string]**float64{
+		"minimum":          &schema.Minimum,
+		"maximum":          &schema.Maximum,
+		"exclusiveMinimum": &schema.ExclusiveMinimum,
+		"exclusiveMaximum": &schema.ExclusiveMaximum,
+	} {
+		if v, ok := m[keyword]; ok {
+			f, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("%s/%s: must be a number", at, keyword)
+			}
+			*dest = &f
+		}
+	}
+
+	if p, ok := m["pattern"]; ok {
+		s, ok := p.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/pattern: must be a string", at)
+		}
+		if schema.Pattern, err = regexp.Compile(s); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %s", at, err)
+		}
+	}
+
+	for keyword, dest := range map[string]*[]*jsonSchema{
+		"allOf": &schema.AllOf,
+		"anyOf": &schema.AnyOf,
+		"oneOf": &schema.OneOf,
+	} {
+		if v, ok := m[keyword]; ok {
+			subs, ok := v.([]interface{})
+			if !ok || len(subs) == 0 {
+				return nil, fmt.Errorf("%s/%s: must be a non-empty array of schemas", at, keyword)
+			}
+			for i, sub := range subs {
+				compiled, err := c.compileAt(sub, fmt.Sprintf("%s/%s/%d", at, keyword, i))
+				if err != nil {
+					return nil, err
+				}
+				*dest = append(*dest, compiled)
+			}
+		}
+	}
+
+	if n, ok := m["not"]; ok {
+		if schema.Not, err = c.compileAt(n, at+"/not"); err != nil {
+			return nil, err
+		}
+	}
+
+	return schema, nil
+}
+
+// Validate a JSON value against the schema, returning path qualified errors
+// (e.g. "`nested.secret1`: expected string, got number"), or none if valid
+func (schema *jsonSchema) Validate(value interface{}) ([]string, error) {
+	normalised, err := normaliseJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	errs := []string{}
+	schema.validate(normalised, "", &errs)
+	return errs, nil
+}
+
+func (schema *jsonSchema) validate(value interface{}, path string, errs *[]string) {
+	fail := func(format string, args ...interface{}) {
+		p := path
+		if p == "" {
+			p = "(root)"
+		}
+		*errs = append(*errs, fmt.Sprintf("`%s`: %s", p, fmt.Sprintf(format, args...)))
+	}
+
+	if len(schema.Types) > 0 {
+		actual := jsonType(value)
+		matched := false
+		for _, t := range schema.Types {
+			if t == actual || (t == "number" && actual == "integer") {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("expected %s, got %s", strings.Join(schema.Types, " or "), actual)
+			return
+		}
+	}
+
+	if schema.Enum != nil {
+		found := false
+		for _, e := range schema.Enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value is not one of the allowed values")
+		}
+	}
+
+	if schema.HasConst && !reflect.DeepEqual(schema.Const, value) {
+		fail("value does not match the required constant")
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range schema.Required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if sub, ok := schema.Properties[k]; ok {
+				sub.validate(v[k], joinSchemaPath(path, k), errs)
+			} else if schema.NoAdditional {
+				fail("additional property %q is not allowed", k)
+			} else if schema.AdditionalProperties != nil {
+				schema.AdditionalProperties.validate(v[k], joinSchemaPath(path, k), errs)
+			}
+		}
+
+	case []interface{}:
+		if schema.MinItems != nil && len(v) < *schema.MinItems {
+			fail("expected at least %d items, got %d", *schema.MinItems, len(v))
+		}
+		if schema.MaxItems != nil && len(v) > *schema.MaxItems {
+			fail("expected at most %d items, got %d", *schema.MaxItems, len(v))
+		}
+		if schema.Items != nil {
+			for i, item := range v {
+				schema.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+
+	case string:
+		length := utf8.RuneCountInString(v)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			fail("expected a length of at least %d, got %d", *schema.MinLength, length)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			fail("expected a length of at most %d, got %d", *schema.MaxLength, length)
+		}
+		if schema.Pattern != nil && !schema.Pattern.MatchString(v) {
+			fail("does not match pattern %q", schema.Pattern.String())
+		}
+
+	case float64:
+		if schema.Minimum != nil && v < *schema.Minimum {
+			fail("must be >= %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && v > *schema.Maximum {
+			fail("must be <= %v", *schema.Maximum)
+		}
+		if schema.ExclusiveMinimum != nil && v <= *schema.ExclusiveMinimum {
+			fail("must be > %v", *schema.ExclusiveMinimum)
+		}
+		if schema.ExclusiveMaximum != nil && v >= *schema.ExclusiveMaximum {
+			fail("must be < %v", *schema.ExclusiveMaximum)
+		}
+	}
+
+	for _, sub := range schema.AllOf {
+		sub.validate(value, path, errs)
+	}
+
+	if len(schema.AnyOf) > 0 && schema.countMatches(schema.AnyOf, value, path) == 0 {
+		fail("does not match any of the allowed schemas")
+	}
+
+	if len(schema.OneOf) > 0 {
+		if n := schema.countMatches(schema.OneOf, value, path); n != 1 {
+			fail("must match exactly one schema, matched %d", n)
+		}
+	}
+
+	if schema.Not != nil && schema.countMatches([]*jsonSchema{schema.Not}, value, path) == 1 {
+		fail("must not match the schema")
+	}
+}
+
+func (schema *jsonSchema) countMatches(subs []*jsonSchema, value interface{}, path string) int {
+	n := 0
+	for _, sub := range subs {
+		subErrs := []string{}
+		sub.validate(value, path, &subErrs)
+		if len(subErrs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func joinSchemaPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return reflect.TypeOf(value).String()
+}
+
+// normaliseJSON round trips a value through encoding/json so that numbers
+// (json.Number from requests, ints from Go code) all become float64 and
+// structs/typed maps become generic maps
+func normaliseJSON(value interface{}) (interface{}, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, errors.New("unable to normalise json value: " + err.Error())
+	}
+	return out, nil
+}
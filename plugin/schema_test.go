@@ -0,0 +1,193 @@
+package e2e
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseJSON parses a JSON test document
+func parseJSON(t *testing.T, doc string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatalf("%s: %s", doc, err)
+	}
+	return v
+}
+
+func TestSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		errs   []string
+	}{
+		{"type string", `{"type": "string"}`, `"a"`, nil},
+		{"type mismatch", `{"type": "string"}`, `1`, []string{"`(root)`: expected string, got integer"}},
+		{"type list", `{"type": ["string", "null"]}`, `null`, nil},
+		{"type list mismatch", `{"type": ["string", "null"]}`, `true`, []string{"`(root)`: expected string or null, got boolean"}},
+		{"boolean schema true", `true`, `{"a": 1}`, nil},
+		{"boolean schema false", `false`, `1`, []string{"`(root)`: must not match the schema"}},
+
+		// numbers
+		{"integer", `{"type": "integer"}`, `3`, nil},
+		{"integer with a zero fraction", `{"type": "integer"}`, `3.0`, nil},
+		{"integer exponent", `{"type": "integer"}`, `1e3`, nil},
+		{"integer fraction", `{"type": "integer"}`, `3.5`, []string{"`(root)`: expected integer, got number"}},
+		{"number accepts integers", `{"type": "number"}`, `3`, nil},
+		{"negative zero", `{"type": "integer", "minimum": 0}`, `-0`, nil},
+		{"minimum inclusive", `{"minimum": 1.5}`, `1.5`, nil},
+		{"minimum", `{"minimum": 1.5}`, `1.25`, []string{"`(root)`: must be >= 1.5"}},
+		{"maximum inclusive", `{"maximum": 10}`, `10`, nil},
+		{"maximum", `{"maximum": 10}`, `10.001`, []string{"`(root)`: must be <= 10"}},
+		{"exclusiveMinimum boundary", `{"exclusiveMinimum": 0}`, `0`, []string{"`(root)`: must be > 0"}},
+		{"exclusiveMinimum", `{"exclusiveMinimum": 0}`, `1e-9`, nil},
+		{"exclusiveMaximum boundary", `{"exclusiveMaximum": 65536}`, `65536`, []string{"`(root)`: must be < 65536"}},
+		{"exclusiveMaximum", `{"exclusiveMaximum": 65536}`, `65535`, nil},
+		{"large numbers", `{"maximum": 1e308}`, `1.7e308`, []string{"`(root)`: must be <= 1e+308"}},
+		{"number keywords ignore strings", `{"minimum": 5}`, `"1"`, nil},
+
+		{"enum", `{"enum": ["a", 1, null]}`, `1`, nil},
+		{"enum mismatch", `{"enum": ["a", 1, null]}`, `"b"`, []string{"`(root)`: value is not one of the allowed values"}},
+		{"const object", `{"const": {"a": [1, 2]}}`, `{"a": [1, 2]}`, nil},
+		{"const mismatch", `{"const": {"a": [1, 2]}}`, `{"a": [2, 1]}`, []string{"`(root)`: value does not match the required constant"}},
+
+		// strings
+		{"minLength counts characters", `{"minLength": 2}`, `"é"`, []string{"`(root)`: expected a length of at least 2, got 1"}},
+		{"maxLength", `{"maxLength": 2}`, `"abc"`, []string{"`(root)`: expected a length of at most 2, got 3"}},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"abc"`, nil},
+		{"pattern mismatch", `{"pattern": "^[a-z]+$"}`, `"ab1"`, []string{"`(root)`: does not match pattern \"^[a-z]+$\""}},
+
+		// objects, with path qualified errors
+		{"required", `{"required": ["secret1", "secret2"]}`, `{"secret2": 1}`, []string{"`(root)`: missing required property \"secret1\""}},
+		{"nested properties", `{"properties": {"nested": {"properties": {"n": {"type": "integer"}}}}}`, `{"nested": {"n": 1.5}}`, []string{"`nested.n`: expected integer, got number"}},
+		{"errors sorted by property", `{"properties": {"a": {"type": "string"}, "b": {"type": "string"}}}`, `{"b": 2, "a": 1}`, []string{"`a`: expected string, got integer", "`b`: expected string, got integer"}},
+		{"additionalProperties false", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "b": 2}`, []string{"`(root)`: additional property \"b\" is not allowed"}},
+		{"additionalProperties schema", `{"additionalProperties": {"type": "string"}}`, `{"x": {"y": 1}}`, []string{"`x`: expected string, got object"}},
+
+		// arrays
+		{"items", `{"items": {"type": "string"}}`, `["a", 1]`, []string{"`[1]`: expected string, got integer"}},
+		{"nested items", `{"properties": {"hosts": {"items": {"properties": {"port": {"type": "integer"}}}}}}`, `{"hosts": [{"port": 1}, {"port": "x"}]}`, []string{"`hosts[1].port`: expected integer, got string"}},
+		{"minItems", `{"minItems": 1}`, `[]`, []string{"`(root)`: expected at least 1 items, got 0"}},
+		{"maxItems", `{"maxItems": 1}`, `[1, 2]`, []string{"`(root)`: expected at most 1 items, got 2"}},
+
+		// combinators
+		{"allOf", `{"allOf": [{"type": "string"}, {"minLength": 3}]}`, `"ab"`, []string{"`(root)`: expected a length of at least 3, got 2"}},
+		{"anyOf", `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `1`, nil},
+		{"anyOf mismatch", `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `true`, []string{"`(root)`: does not match any of the allowed schemas"}},
+		{"oneOf", `{"oneOf": [{"type": "integer"}, {"minimum": 5}]}`, `1`, nil},
+		{"oneOf both", `{"oneOf": [{"type": "integer"}, {"minimum": 5}]}`, `6`, []string{"`(root)`: must match exactly one schema, matched 2"}},
+		{"not", `{"not": {"type": "null"}}`, `null`, []string{"`(root)`: must not match the schema"}},
+
+		// $ref
+		{"ref definitions", `{"definitions": {"port": {"type": "integer", "maximum": 65535}}, "properties": {"port": {"$ref": "#/definitions/port"}}}`, `{"port": 70000}`, []string{"`port`: must be <= 65535"}},
+		{"ref $defs", `{"$defs": {"name": {"type": "string"}}, "items": {"$ref": "#/$defs/name"}}`, `["a", 1]`, []string{"`[1]`: expected string, got integer"}},
+		{"ref siblings ignored", `{"definitions": {"s": {"type": "string"}}, "properties": {"a": {"$ref": "#/definitions/s", "type": "integer"}}}`, `{"a": "x"}`, nil},
+		{"ref escaped pointer", `{"definitions": {"a/b": {"type": "string"}, "c~d": {"type": "integer"}}, "properties": {"x": {"$ref": "#/definitions/a~1b"}, "y": {"$ref": "#/definitions/c~0d"}}}`, `{"x": 1, "y": 1}`, []string{"`x`: expected string, got integer"}},
+		{"ref percent encoded", `{"definitions": {"a b": {"type": "string"}}, "properties": {"x": {"$ref": "#/definitions/a%20b"}}}`, `{"x": 1}`, []string{"`x`: expected string, got integer"}},
+		{"ref array index", `{"allOf": [{"type": "object"}], "properties": {"x": {"$ref": "#/allOf/0"}}}`, `{"x": 1}`, []string{"`x`: expected object, got integer"}},
+		{"recursive ref", `{"type": "object", "properties": {"name": {"type": "string"}, "children": {"items": {"$ref": "#"}}}}`, `{"name": "a", "children": [{"name": "b", "children": [{"name": 3}]}]}`, []string{"`children[0].children[0].name`: expected string, got integer"}},
+	}
+	for _, test := range tests {
+		schema, err := compileSchema(parseJSON(t, test.schema))
+		if err != nil {
+			t.Errorf("%s: compile: %s", test.name, err)
+			continue
+		}
+		errs, err := schema.Validate(parseJSON(t, test.value))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if len(errs) == 0 {
+			errs = nil
+		}
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%s: got %q, want %q", test.name, errs, test.errs)
+		}
+	}
+}
+
+func TestSchemaValidateGoValues(t *testing.T) {
+	schema, err := compileSchema(map[string]interface{}{
+		"properties": map[string]interface{}{
+			"n": map[string]interface{}{"type": "integer", "minimum": 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// numbers from Go code and requests (json.Number) are normalised
+	for _, n := range []interface{}{1, int64(2), float32(3), json.Number("4")} {
+		errs, err := schema.Validate(map[string]interface{}{"n": n})
+		if err != nil || len(errs) != 0 {
+			t.Errorf("%T %v: %q %v", n, n, errs, err)
+		}
+	}
+	errs, err := schema.Validate(map[string]interface{}{"n": 0})
+	if err != nil || len(errs) != 1 || errs[0] != "`n`: must be >= 1" {
+		t.Errorf("0: %q %v", errs, err)
+	}
+}
+
+func TestCompileSchemaErrors(t *testing.T) {
+	tests := []struct {
+		schema string
+		err    string
+	}{
+		{`1`, "#: schema must be an object or boolean"},
+		{`{"type": "str"}`, `#/type: unknown type "str"`},
+		{`{"type": 1}`, "#/type: must be a string or array of strings"},
+		{`{"enum": "a"}`, "#/enum: must be an array"},
+		{`{"properties": []}`, "#/properties: must be an object"},
+		{`{"properties": {"a": {"type": "x"}}}`, `#/properties/a/type: unknown type "x"`},
+		{`{"required": [1]}`, "#/required: must be an array of strings"},
+		{`{"additionalProperties": 1}`, "#/additionalProperties: schema must be an object or boolean"},
+		{`{"items": "a"}`, "#/items: schema must be an object or boolean"},
+		{`{"minItems": -1}`, "#/minItems: must be a non-negative integer"},
+		{`{"maxLength": 1.5}`, "#/maxLength: must be a non-negative integer"},
+		{`{"minimum": "1"}`, "#/minimum: must be a number"},
+		{`{"exclusiveMinimum": true}`, "#/exclusiveMinimum: must be a number"},
+		{`{"pattern": "("}`, "#/pattern: error parsing regexp"},
+		{`{"anyOf": []}`, "#/anyOf: must be a non-empty array of schemas"},
+		{`{"oneOf": [{}, {"type": "x"}]}`, `#/oneOf/1/type: unknown type "x"`},
+		{`{"not": 1}`, "#/not: schema must be an object or boolean"},
+		{`{"$ref": 1}`, "#/$ref: must be a string"},
+		{`{"$ref": "other.json#/a"}`, "#/$ref: only references within the schema"},
+		{`{"properties": {"a": {"$ref": "#/definitions/missing"}}}`, `#/properties/a/$ref: unresolvable reference "#/definitions/missing"`},
+		{`{"$ref": "#/allOf/1", "allOf": [{}]}`, `#/$ref: unresolvable reference "#/allOf/1"`},
+		{`{"$ref": "#definitions"}`, `#/$ref: unresolvable reference "#definitions"`},
+		{`{"definitions": {"a": {"type": "x"}}, "$ref": "#/definitions/a"}`, `#/definitions/a/type: unknown type "x"`},
+	}
+	for _, test := range tests {
+		_, err := compileSchema(parseJSON(t, test.schema))
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: got %v, want %s", test.schema, err, test.err)
+		}
+	}
+}
+
+func TestValidateAgainstSchema(t *testing.T) {
+	entry := &E2eSchemaEntry{
+		Path:   "kv/Customer1",
+		Schema: parseJSON(t, `{"required": ["secret1"], "properties": {"secret1": {"type": "string"}, "nested": {"properties": {"n": {"type": "integer"}}}}}`).(map[string]interface{}),
+	}
+	resp, err := validateAgainstSchema(entry, parseJSON(t, `{"secret1": 1, "nested": {"n": 1.5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "validation against schema \"kv/Customer1\" failed:\n  1) `nested.n`: expected integer, got number\n  2) `secret1`: expected string, got integer"
+	if resp == nil || resp.Data["error"] != want {
+		t.Errorf("got %v, want %q", resp, want)
+	}
+
+	if resp, err := validateAgainstSchema(entry, parseJSON(t, `{"secret1": "a"}`)); resp != nil || err != nil {
+		t.Errorf("valid value: %v %v", resp, err)
+	}
+	if resp, err := validateAgainstSchema(nil, parseJSON(t, `1`)); resp != nil || err != nil {
+		t.Errorf("no schema: %v %v", resp, err)
+	}
+}
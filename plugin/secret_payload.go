@@ -0,0 +1,194 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// lease secret type returned with leased payloads
+const secretPayloadType = "payload"
+
+// E2ePayloadEntry structure recording a leased payload, so that it can be
+// revoked and the revocation published to recipients
+type E2ePayloadEntry struct { // nolint
+	ID string `json:"id" structs:"id" mapstructure:"id"`
+
+	Name string `json:"name" structs:"name" mapstructure:"name"`
+
+	Created string `json:"created" structs:"created" mapstructure:"created"`
+
+	Revoked string `json:"revoked,omitempty" structs:"revoked" mapstructure:"revoked"`
+
+	// the payload's EXPIRES (RFC 3339), empty if it has none
+	Expires string `json:"expires,omitempty" structs:"expires" mapstructure:"expires"`
+
+	// kv path -> top level fields of one-time secrets delivered in the payload
+	OneTime map[string][]string `json:"one_time,omitempty" structs:"one_time" mapstructure:"one_time"`
+}
+
+func secretPayload(backend *E2eBackend) *framework.Secret {
+	return &framework.Secret{
+		Type: secretPayloadType,
+		Fields: map[string]*framework.FieldSchema{
+			"payload_id": {
+				Type:        framework.TypeString,
+				Description: "ID of the leased payload",
+			},
+		},
+		Renew:  backend.secretPayloadRenew,
+		Revoke: backend.secretPayloadRevoke,
+	}
+}
+
+func (backend *E2eBackend) secretPayloadRenew(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id, ok := req.Secret.InternalData["payload_id"].(string)
+	if !ok {
+		return nil, errors.New("payload lease is missing the payload id")
+	}
+
+	payloadEntry, err := getPayloadEntry(ctx, req.Storage, id)
+	if err != nil {
+		return nil, err
+	}
+	if payloadEntry == nil || payloadEntry.Revoked != "" {
+		return logical.ErrorResponse(fmt.Sprintf("payload %q has been revoked", id)), nil
+	}
+
+	return &logical.Response{Secret: req.Secret}, nil
+}
+
+// secretPayloadRevoke is called by Vault when a payload lease is revoked or
+// expires, it publishes the payload id as revoked and rotates any one-time
+// secrets that were delivered in it
+func (backend *E2eBackend) secretPayloadRevoke(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id, ok := req.Secret.InternalData["payload_id"].(string)
+	if !ok {
+		return nil, errors.New("payload lease is missing the payload id")
+	}
+
+	payloadEntry, err := getPayloadEntry(ctx, req.Storage, id)
+	if err != nil {
+		return nil, err
+	}
+	if payloadEntry == nil {
+		return nil, nil
+	}
+	if payloadEntry.Revoked != "" {
+		// already revoked
+		return nil, nil
+	}
+
+	timeText, err := time.Now().MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	payloadEntry.Revoked = string(timeText)
+
+	if err := putJSON(ctx, req.Storage, "payloads/"+id, payloadEntry); err != nil {
+		return nil, err
+	}
+	if err := putJSON(ctx, req.Storage, "revocations/"+id, E2eRevocationEntry{
+		Revoked: payloadEntry.Revoked,
+		Expires: payloadEntry.Expires,
+	}); err != nil {
+		return nil, err
+	}
+
+	for path, fields := range payloadEntry.OneTime {
+		if err := backend.rotateSecret(ctx, req.Storage, path, fields); err != nil {
+			log.Printf("unable to rotate one-time secret %s delivered in payload %s: %s", path, id, err)
+		}
+	}
+
+	return nil, nil
+}
+
+// rotateSecret is the rotation hook for delivered one-time secrets, the
+// fields are regenerated using the generator specs recorded when the secret
+// was generated. Fields that were not generated can not be rotated.
+func (backend *E2eBackend) rotateSecret(ctx context.Context, s logical.Storage, path string, fields []string) error {
+	specs, err := getGeneratorSpecs(ctx, s, path)
+	if err != nil {
+		return err
+	}
+
+	secret, err := backend.getKV(ctx, s, path)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return nil
+	}
+
+	rotated := []string{}
+	for _, field := range fields {
+		spec, ok := specs[field]
+		if !ok {
+			log.Printf("one-time secret %s.%s was not generated, it must be rotated manually", path, field)
+			continue
+		}
+		value, _, err := generateSecret(ctx, s, spec)
+		if err != nil {
+			return err
+		}
+		secret[field] = value
+		rotated = append(rotated, field)
+	}
+	if len(rotated) == 0 {
+		return nil
+	}
+
+	if err := backend.putKV(ctx, s, path, secret); err != nil {
+		return err
+	}
+	log.Printf("rotated one-time secret %s fields: %s", path, strings.Join(rotated, ", "))
+	return nil
+}
+
+func getPayloadEntry(ctx context.Context, s logical.Storage, id string) (*E2ePayloadEntry, error) {
+	entry, err := s.Get(ctx, "payloads/"+id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var payloadEntry E2ePayloadEntry
+	if err := json.Unmarshal(entry.Value, &payloadEntry); err != nil {
+		return nil, err
+	}
+	return &payloadEntry, nil
+}
+
+func putJSON(ctx context.Context, s logical.Storage, key string, value interface{}) error {
+	dataJSON, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, &logical.StorageEntry{
+		Key:   key,
+		Value: dataJSON,
+	})
+}
+
+// deliveredFields flattens the one-time secrets delivered in a payload
+func deliveredFields(delivered map[string]map[string]bool) map[string][]string {
+	oneTime := map[string][]string{}
+	for path, fields := range delivered {
+		for field := range fields {
+			oneTime[path] = append(oneTime[path], field)
+		}
+		sort.Strings(oneTime[path])
+	}
+	return oneTime
+}
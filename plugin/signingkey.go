@@ -0,0 +1,76 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"gitlab.com/gbevan/vault-e2e-plugin/envelope"
+)
+
+// storage key of the backend's payload signing key
+const signingKeyStorageKey = "signingkey"
+
+// E2eSigningKeyEntry structure representing the backend's Ed25519 payload
+// signing key
+type E2eSigningKeyEntry struct { // nolint
+	PrivateKey string `json:"private_key" structs:"private_key" mapstructure:"private_key"`
+
+	Created string `json:"created" structs:"created" mapstructure:"created"`
+}
+
+// getSigningKey loads the signing key, generating it on first use
+func (backend *E2eBackend) getSigningKey(ctx context.Context, s logical.Storage) (*envelope.SigningKey, error) {
+	backend.signingKeyLock.RLock()
+	key, err := loadSigningKey(ctx, s)
+	backend.signingKeyLock.RUnlock()
+	if err != nil || key != nil {
+		return key, err
+	}
+
+	backend.signingKeyLock.Lock()
+	defer backend.signingKeyLock.Unlock()
+
+	// check again now we hold the write lock
+	key, err = loadSigningKey(ctx, s)
+	if err != nil || key != nil {
+		return key, err
+	}
+
+	key, err = envelope.NewSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	keyPem, err := envelope.MarshalSigningKey(key)
+	if err != nil {
+		return nil, err
+	}
+	timeText, err := time.Now().MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	if err := putJSON(ctx, s, signingKeyStorageKey, E2eSigningKeyEntry{
+		PrivateKey: keyPem,
+		Created:    string(timeText),
+	}); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func loadSigningKey(ctx context.Context, s logical.Storage) (*envelope.SigningKey, error) {
+	entry, err := s.Get(ctx, signingKeyStorageKey)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var signingKey E2eSigningKeyEntry
+	if err := json.Unmarshal(entry.Value, &signingKey); err != nil {
+		return nil, err
+	}
+	return envelope.ParseSigningKey(signingKey.PrivateKey)
+}
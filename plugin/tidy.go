@@ -0,0 +1,186 @@
+package e2e
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+)
+
+// periodicTidy is the backend's PeriodicFunc. It revokes enrolements that
+// have expired or whose certificate the CRL revokes, so stale recipient keys
+// stop receiving secrets, purges enrolements revoked for longer than the
+// config's enrolement_retention, and deletes expired challenges. It deletes
+// the records of leased payloads whose lease has ended, and drops
+// revocations of payloads past their EXPIRES. An entry that cannot be tidied
+// is logged and skipped, so it does not hold up the rest.
+func (backend *E2eBackend) periodicTidy(ctx context.Context, req *logical.Request) error {
+	// enrolements are replicated, so are only tidied on the primary
+	if backend.System().ReplicationState().HasState(consts.ReplicationPerformanceSecondary) {
+		return nil
+	}
+
+	config, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	// the CRL is parsed once per run, without it enrolements are still
+	// revoked when they expire. A stale CRL only holds back payloads, it
+	// does not revoke the enrolements it covers.
+	crl, err := loadCRL(config)
+	if err == nil && crl != nil {
+		err = checkCRLCurrent(config, crl, now)
+	}
+	if err != nil {
+		log.Printf("tidy: not checking enrolement certificates against the crl: %s", err)
+		crl = nil
+	}
+
+	names, err := req.Storage.List(ctx, "enrole/")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := tidyEnrolement(ctx, req.Storage, config, crl, name, now); err != nil {
+			log.Printf("tidy: enrolement %s: %s", name, err)
+		}
+	}
+
+	names, err = req.Storage.List(ctx, "challenges/")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		challenge, err := getChallenge(ctx, req.Storage, name)
+		if err != nil {
+			log.Printf("tidy: challenge %s: %s", name, err)
+			continue
+		}
+		if challenge == nil {
+			if err := req.Storage.Delete(ctx, "challenges/"+name); err != nil {
+				log.Printf("tidy: challenge %s: %s", name, err)
+			}
+		}
+	}
+
+	ids, err := req.Storage.List(ctx, "payloads/")
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := tidyPayload(ctx, req.Storage, id); err != nil {
+			log.Printf("tidy: payload %s: %s", id, err)
+		}
+	}
+
+	ids, err = req.Storage.List(ctx, "revocations/")
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := tidyRevocation(ctx, req.Storage, id, now); err != nil {
+			log.Printf("tidy: revocation %s: %s", id, err)
+		}
+	}
+	return nil
+}
+
+// tidyPayload deletes a leased payload's record once its lease has ended.
+// The lease's revocation (on expiry or revoke) publishes the payload's
+// revocation and rotates its one-time secrets, so the record is kept until
+// then.
+func tidyPayload(ctx context.Context, s logical.Storage, id string) error {
+	payloadEntry, err := getPayloadEntry(ctx, s, id)
+	if err != nil {
+		return err
+	}
+	if payloadEntry == nil || payloadEntry.Revoked == "" {
+		return nil
+	}
+	return s.Delete(ctx, "payloads/"+id)
+}
+
+// tidyEnrolement revokes or purges an enrolement, checking its certificate
+// against crl (nil for none)
+func tidyEnrolement(ctx context.Context, s logical.Storage, config *E2eConfigEntry, crl *x509.RevocationList, name string, now time.Time) error {
+	key := "enrole/" + name
+	entry, err := s.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return nil
+	}
+	var enrole E2eEnrolementEntry
+	if err := json.Unmarshal(entry.Value, &enrole); err != nil {
+		return err
+	}
+
+	if enrole.Revoked != "" {
+		if config.EnrolementRetention == 0 {
+			return nil
+		}
+		var revoked time.Time
+		if err := revoked.UnmarshalText([]byte(enrole.Revoked)); err != nil {
+			return err
+		}
+		if now.Sub(revoked) < time.Duration(config.EnrolementRetention)*time.Second {
+			return nil
+		}
+		if err := s.Delete(ctx, key); err != nil {
+			return err
+		}
+		log.Printf("purged enrolement %s, revoked at %s", name, enrole.Revoked)
+		return nil
+	}
+
+	reason := ""
+	expired, err := enrole.Expired(now)
+	if err != nil {
+		return err
+	}
+	if expired {
+		reason = "expired at " + enrole.ExpiresAt
+	} else if enrole.Certificate != "" {
+		certs, err := parseCertificates(enrole.Certificate)
+		if err != nil {
+			return err
+		}
+		if err := checkCertificateRevoked(config, crl, certs[0], now); err != nil {
+			reason = err.Error()
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+
+	timeText, err := now.MarshalText()
+	if err != nil {
+		return err
+	}
+	enrole.Revoked = string(timeText)
+	if err := putJSON(ctx, s, key, enrole); err != nil {
+		return err
+	}
+	log.Printf("revoked enrolement %s: %s", name, reason)
+	return nil
+}
+
+// tidyRevocation drops a payload's revocation once the payload has expired
+func tidyRevocation(ctx context.Context, s logical.Storage, id string, now time.Time) error {
+	revocation, err := getRevocation(ctx, s, id)
+	if err != nil || revocation == nil {
+		return err
+	}
+	expired, err := revocation.Expired(now)
+	if err != nil || !expired {
+		return err
+	}
+	return s.Delete(ctx, "revocations/"+id)
+}
@@ -0,0 +1,133 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTidySkipsBrokenEnrolements(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	config := &logical.BackendConfig{StorageView: storage, System: logical.TestSystemView()}
+	backend := Backend(ctx, config)
+	if err := backend.Setup(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+
+	past, err := time.Now().Add(-time.Hour).MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// enrolements are tidied in name order, the broken ones first
+	if err := storage.Put(ctx, &logical.StorageEntry{Key: "enrole/a-corrupt", Value: []byte("{")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := putJSON(ctx, storage, "enrole/b-badcert", E2eEnrolementEntry{Name: "b-badcert", Certificate: "not a certificate"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := putJSON(ctx, storage, "enrole/c-expired", E2eEnrolementEntry{Name: "c-expired", ExpiresAt: string(past)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, &logical.StorageEntry{Key: "challenges/a-corrupt", Value: []byte("{")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := putJSON(ctx, storage, "challenges/b-expired", E2eChallengeEntry{Challenge: "x", Expires: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.periodicTidy(ctx, &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := storage.Get(ctx, "enrole/c-expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var enrole E2eEnrolementEntry
+	if err := json.Unmarshal(entry.Value, &enrole); err != nil {
+		t.Fatal(err)
+	}
+	if enrole.Revoked == "" {
+		t.Error("expired enrolement after broken ones not revoked")
+	}
+	if entry, err := storage.Get(ctx, "challenges/b-expired"); err != nil || entry != nil {
+		t.Errorf("expired challenge after a broken one not deleted: %v %v", entry, err)
+	}
+}
+
+func TestTidyPrunesPayloadsAndRevocations(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	config := &logical.BackendConfig{StorageView: storage, System: logical.TestSystemView()}
+	backend := Backend(ctx, config)
+	if err := backend.Setup(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for _, payloadEntry := range []E2ePayloadEntry{
+		{ID: "leased", Expires: past},
+		{ID: "ended-expired", Expires: past},
+		{ID: "ended-current", Expires: future},
+		{ID: "ended-unexpiring"},
+	} {
+		if err := putJSON(ctx, storage, "payloads/"+payloadEntry.ID, payloadEntry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the leases of all but the first end
+	for _, id := range []string{"ended-expired", "ended-current", "ended-unexpiring"} {
+		if _, err := backend.secretPayloadRevoke(ctx, &logical.Request{
+			Storage: storage,
+			Secret:  &logical.Secret{InternalData: map[string]interface{}{"payload_id": id}},
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// written before payload expiry was recorded
+	if err := storage.Put(ctx, &logical.StorageEntry{Key: "revocations/legacy", Value: []byte(past)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backend.periodicTidy(ctx, &logical.Request{Storage: storage}); err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(key string) bool {
+		entry, err := storage.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entry != nil
+	}
+	for key, want := range map[string]bool{
+		// a leased payload's record is kept until its lease ends, whatever its
+		// EXPIRES
+		"payloads/leased":              true,
+		"payloads/ended-expired":       false,
+		"payloads/ended-current":       false,
+		"payloads/ended-unexpiring":    false,
+		"revocations/ended-expired":    false,
+		"revocations/ended-current":    true,
+		"revocations/ended-unexpiring": true,
+		"revocations/legacy":           true,
+	} {
+		if got := exists(key); got != want {
+			t.Errorf("%s exists %v after tidy, want %v", key, got, want)
+		}
+	}
+
+	resp, err := backend.pathRevocationsRead(ctx, &logical.Request{Storage: storage}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked := resp.Data["revoked"].(map[string]interface{})
+	if len(revoked) != 3 || revoked["legacy"] != past {
+		t.Errorf("published revocations %v", revoked)
+	}
+}
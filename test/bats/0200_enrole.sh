@@ -3,7 +3,7 @@
 @test "can generate rsa key pair" {
   pwd
   ls -la /vault/plugins/genrsapair
-  /vault/plugins/genrsapair -prefix ../bats
+  /vault/plugins/genrsapair -force -prefix ../bats
 }
 
 @test "rsa priv key exist in test folder" {
@@ -0,0 +1,51 @@
+#!/usr/bin/env bats
+
+@test "can create a password policy" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/passwordpolicy/bats -X POST \
+    --data '{"length": 32, "required": "lower,upper,digit"}'
+
+  LENGTH=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request GET $VURL/e2e/passwordpolicy/bats | jq -r .data.length)
+
+  [ "$LENGTH" = "32" ]
+}
+
+@test "can generate secrets returning only metadata" {
+  RESP=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/kv/generated -X POST \
+    --data '{"generate": {"password": "password:bats", "apikey": "bytes:16:hex", "id": "uuid", "signing": "ed25519"}, "metadata_only": true}')
+  echo "$RESP"
+
+  [ "$(echo "$RESP" | jq -r .data.value)" = "null" ]
+  [ "$(echo "$RESP" | jq -r .data.metadata.password.policy)" = "bats" ]
+}
+
+@test "generated secrets are stored in kv" {
+  SECRET=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request GET $VURL/e2e/kv/generated)
+  echo "$SECRET"
+
+  [ "$(echo "$SECRET" | jq -r '.data.password | length')" = "32" ]
+  [ "$(echo "$SECRET" | jq -r '.data.apikey | length')" = "32" ]
+  [ "$(echo "$SECRET" | jq -r .data.signing.public_key)" != "null" ]
+}
+
+@test "generates into the data given with it" {
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/kv/generated-with-data -X POST \
+    --data '{"data": {"user": "app"}, "generate": {"password": "password:bats"}}'
+
+  SECRET=$(curl -s --header "X-Vault-Token: root" $VURL/e2e/kv/generated-with-data)
+  [ "$(echo "$SECRET" | jq -r .data.user)" = "app" ]
+  [ "$(echo "$SECRET" | jq -r '.data.password | length')" = "32" ]
+}
@@ -0,0 +1,37 @@
+#!/usr/bin/env bats
+
+@test "can register a kv schema" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/schema/kv/schematest -X POST \
+    --data '{"schema": {"type": "object", "required": ["secret1"], "properties": {"secret1": {"type": "string"}}}}'
+
+  REQUIRED=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request GET $VURL/e2e/schema/kv/schematest | jq -r '.data.schema.required[0]')
+
+  [ "$REQUIRED" = "secret1" ]
+}
+
+@test "kv write matching the schema is accepted" {
+  STATUS=$(curl -s -o /dev/null -w "%{http_code}" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/kv/schematest/good -X POST \
+    --data '{"data": {"secret1": "a string"}}')
+
+  [ "$STATUS" = "200" ]
+}
+
+@test "kv write not matching the schema is rejected with path qualified errors" {
+  RESP=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/kv/schematest/bad -X POST \
+    --data '{"data": {"secret2": 42}}')
+  echo "$RESP"
+
+  echo "$RESP" | jq -r '.errors[0]' | grep 'missing required property "secret1"'
+}
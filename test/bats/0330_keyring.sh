@@ -0,0 +1,33 @@
+#!/usr/bin/env bats
+
+@test "can rotate the kv keyring" {
+  CURRENT=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request GET $VURL/e2e/keyring | jq -r .data.current)
+
+  ROTATED=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request POST $VURL/e2e/keyring/rotate | jq -r .data.current)
+
+  [ "$ROTATED" = "$((CURRENT + 1))" ]
+}
+
+@test "can rewrap kv secrets with the current key" {
+  REWRAPPED=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request POST $VURL/e2e/keyring/rewrap | jq -r .data.rewrapped)
+
+  [ "$REWRAPPED" -gt 0 ]
+}
+
+@test "secrets are still readable after rewrap" {
+  SECRET=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request GET $VURL/e2e/kv/my-secret | jq -r .data.mydata)
+
+  [ "$SECRET" = "This is a secret!" ]
+}
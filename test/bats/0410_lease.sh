@@ -0,0 +1,42 @@
+#!/usr/bin/env bats
+
+@test "can request a leased payload" {
+  RESP=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS1 -X POST \
+    --data '{"ttl": 300, "one_time": true, "payload": {"password@/e2e/kv/generated.password": true}}')
+  echo "$RESP"
+
+  echo "$RESP" | jq -r .data.payload > ../leased_payload.txt
+  echo "$RESP" | jq -r .lease_id > ../leased_payload.lease
+  echo "$RESP" | jq -r .data.payload_id > ../leased_payload.id
+
+  [ "$(cat ../leased_payload.lease)" != "" ]
+  grep "PAYLOAD_ID: $(cat ../leased_payload.id)" ../leased_payload.txt
+}
+
+@test "can decrypt the leased payload before it is revoked" {
+  /vault/plugins/decrypt -privkey ../bats_rsa.pem \
+    -revocations $VURL/e2e/revocations < ../leased_payload.txt
+}
+
+@test "revoking the lease publishes the payload id and rotates one-time secrets" {
+  BEFORE=$(curl -s --header "X-Vault-Token: root" $VURL/e2e/kv/generated | jq -r .data.password)
+
+  vault lease revoke "$(cat ../leased_payload.lease)"
+  sleep 1
+
+  REVOKED=$(curl -s $VURL/e2e/revocations | jq -r ".data.revoked[\"$(cat ../leased_payload.id)\"]")
+  AFTER=$(curl -s --header "X-Vault-Token: root" $VURL/e2e/kv/generated | jq -r .data.password)
+
+  [ "$REVOKED" != "null" ]
+  [ "$BEFORE" != "$AFTER" ]
+}
+
+@test "decrypt refuses a revoked payload" {
+  run /vault/plugins/decrypt -privkey ../bats_rsa.pem \
+    -revocations $VURL/e2e/revocations < ../leased_payload.txt
+
+  [ "$status" -eq 2 ]
+}
@@ -0,0 +1,50 @@
+#!/usr/bin/env bats
+
+@test "can generate p256 and x25519 key pairs" {
+  /vault/plugins/genrsapair -force -prefix ../bats -type p256
+  /vault/plugins/genrsapair -force -prefix ../bats -type x25519
+
+  [ -e ../bats_p256.pem ]
+  [ -e ../bats_x25519_pub.pem ]
+}
+
+@test "can enrole elliptic curve public keys" {
+  for TYPE in p256 x25519; do
+    PUBKEY=$(jq -Rsc . < ../bats_${TYPE}_pub.pem)
+    curl -s -H "Accept: application/json" \
+      -H "Content-type: application/json" \
+      --header "X-Vault-Token: root" \
+      --request POST $VURL/e2e/enrole/BATS_$TYPE \
+      --data "{\"name\": \"BATS_$TYPE\", \"pubkey\":$PUBKEY}"
+  done
+
+  KEYTYPE=$(curl -s --header "X-Vault-Token: root" $VURL/e2e/enrole/BATS_x25519 | jq -r .data.enrole.keytype)
+  [ "$KEYTYPE" = "X25519" ]
+}
+
+@test "enrolement rejects an invalid public key" {
+  RESP=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request POST $VURL/e2e/enrole/BATS_BAD \
+    --data '{"name": "BATS_BAD", "pubkey": "not a key"}')
+  echo "$RESP"
+
+  echo "$RESP" | jq -r '.errors[0]' | grep "invalid pubkey"
+}
+
+@test "can encrypt and decrypt payloads for elliptic curve recipients" {
+  for TYPE in p256 x25519; do
+    PAYLOAD=$(curl -s -H "Accept: application/json" \
+      -H "Content-type: application/json" \
+      --header "X-Vault-Token: root" \
+      $VURL/e2e/payload/BATS_$TYPE -X POST \
+      --data '{"payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload)
+    echo "$PAYLOAD"
+
+    echo "$PAYLOAD" | grep "PAYLOAD_VERSION: 3.0"
+    SECRET=$(echo "$PAYLOAD" | /vault/plugins/decrypt -privkey ../bats_$TYPE.pem | jq -r .secretData)
+    [ "$SECRET" != "" ]
+    [ "$SECRET" != "null" ]
+  done
+}
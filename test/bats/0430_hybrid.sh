@@ -0,0 +1,34 @@
+#!/usr/bin/env bats
+
+@test "can generate an ML-KEM-768+X25519 hybrid key pair" {
+  /vault/plugins/genrsapair -force -prefix ../bats -type mlkem768x25519
+
+  [ -e ../bats_mlkem768x25519.pem ]
+  grep "BEGIN MLKEM768X25519 PUBLIC KEY" ../bats_mlkem768x25519_pub.pem
+}
+
+@test "can enrole a hybrid public key" {
+  PUBKEY=$(jq -Rsc . < ../bats_mlkem768x25519_pub.pem)
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request POST $VURL/e2e/enrole/BATS_PQ \
+    --data "{\"name\": \"BATS_PQ\", \"pubkey\":$PUBKEY}"
+
+  KEYTYPE=$(curl -s --header "X-Vault-Token: root" $VURL/e2e/enrole/BATS_PQ | jq -r .data.enrole.keytype)
+  [ "$KEYTYPE" = "ML-KEM-768+X25519" ]
+}
+
+@test "can encrypt and decrypt a payload for a hybrid recipient" {
+  PAYLOAD=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_PQ -X POST \
+    --data '{"payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload)
+  echo "$PAYLOAD"
+
+  echo "$PAYLOAD" | grep "PAYLOAD_VERSION: 4.0"
+  SECRET=$(echo "$PAYLOAD" | /vault/plugins/decrypt -privkey ../bats_mlkem768x25519.pem | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
@@ -0,0 +1,43 @@
+#!/usr/bin/env bats
+
+@test "can enrole recipients with a chosen content cipher" {
+  PUBKEY=$(jq -Rsc . < ../bats_x25519_pub.pem)
+  for CIPHER in chacha20-poly1305 xchacha20-poly1305; do
+    curl -s -H "Accept: application/json" \
+      -H "Content-type: application/json" \
+      --header "X-Vault-Token: root" \
+      --request POST $VURL/e2e/enrole/BATS_$CIPHER \
+      --data "{\"name\": \"BATS_$CIPHER\", \"pubkey\":$PUBKEY, \"cipher\": \"$CIPHER\"}"
+  done
+
+  ENROLED=$(curl -s --header "X-Vault-Token: root" $VURL/e2e/enrole/BATS_xchacha20-poly1305 | jq -r .data.enrole.cipher)
+  [ "$ENROLED" = "XCHACHA20-POLY1305" ]
+}
+
+@test "enrolement rejects an unsupported cipher" {
+  PUBKEY=$(jq -Rsc . < ../bats_x25519_pub.pem)
+  RESP=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request POST $VURL/e2e/enrole/BATS_DES \
+    --data "{\"name\": \"BATS_DES\", \"pubkey\":$PUBKEY, \"cipher\": \"des\"}")
+  echo "$RESP"
+
+  echo "$RESP" | jq -r '.errors[0]' | grep "unsupported cipher"
+}
+
+@test "can decrypt payloads encrypted with the enroled cipher" {
+  for CIPHER in chacha20-poly1305 xchacha20-poly1305; do
+    PAYLOAD=$(curl -s -H "Accept: application/json" \
+      -H "Content-type: application/json" \
+      --header "X-Vault-Token: root" \
+      $VURL/e2e/payload/BATS_$CIPHER -X POST \
+      --data '{"payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload)
+    echo "$PAYLOAD"
+
+    echo "$PAYLOAD" | grep -i "CIPHER: $CIPHER"
+    SECRET=$(echo "$PAYLOAD" | /vault/plugins/decrypt -privkey ../bats_x25519.pem | jq -r .secretData)
+    [ "$SECRET" != "" ]
+    [ "$SECRET" != "null" ]
+  done
+}
@@ -0,0 +1,36 @@
+#!/usr/bin/env bats
+
+@test "can store a large kv secret" {
+  DUMP=$(head -c 300000 /dev/urandom | base64 -w0)
+  echo "{\"data\": {\"dump\": \"$DUMP\"}}" > ../large_secret.json
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/kv/large -X POST \
+    --data @../large_secret.json
+}
+
+@test "can request a STREAM chunked payload" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS1 -X POST \
+    --data '{"stream": true, "payload": {"dump@/e2e/kv/large.dump": true}}' | jq -r .data.payload > ../stream_payload.txt
+
+  grep "PAYLOAD_VERSION: 2.1" ../stream_payload.txt
+  grep "STREAM: 65536" ../stream_payload.txt
+}
+
+@test "can decrypt a STREAM chunked payload" {
+  DUMP=$(/vault/plugins/decrypt -privkey ../bats_rsa.pem < ../stream_payload.txt | jq -r .dump)
+
+  [ "$DUMP" = "$(jq -r .data.dump ../large_secret.json)" ]
+}
+
+@test "decrypt rejects a truncated STREAM payload" {
+  LINES=$(wc -l < ../stream_payload.txt)
+  (head -n $((LINES - 100)) ../stream_payload.txt; tail -n 1 ../stream_payload.txt) > ../stream_truncated.txt
+
+  run /vault/plugins/decrypt -privkey ../bats_rsa.pem < ../stream_truncated.txt
+  [ "$status" -ne 0 ]
+}
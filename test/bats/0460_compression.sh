@@ -0,0 +1,42 @@
+#!/usr/bin/env bats
+
+@test "can enrole a recipient with zstd compression" {
+  PUBKEY=$(jq -Rsc . < ../bats_x25519_pub.pem)
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request POST $VURL/e2e/enrole/BATS_ZSTD \
+    --data "{\"name\": \"BATS_ZSTD\", \"pubkey\":$PUBKEY, \"compression\": \"zstd\"}"
+
+  COMPRESSION=$(curl -s --header "X-Vault-Token: root" $VURL/e2e/enrole/BATS_ZSTD | jq -r .data.enrole.compression)
+  [ "$COMPRESSION" = "ZSTD" ]
+}
+
+@test "payloads are not compressed by default" {
+  PAYLOAD=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_x25519 -X POST \
+    --data '{"payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload)
+  echo "$PAYLOAD"
+
+  ! echo "$PAYLOAD" | grep "COMPRESSION:"
+}
+
+@test "can decrypt and decompress a compressed payload" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_ZSTD -X POST \
+    --data '{"payload": {"hello": "world", "secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload > ../compressed_payload.txt
+  cat ../compressed_payload.txt
+
+  grep "COMPRESSION: ZSTD" ../compressed_payload.txt
+  HELLO=$(/vault/plugins/decrypt -privkey ../bats_x25519.pem < ../compressed_payload.txt | jq -r .hello)
+  [ "$HELLO" = "world" ]
+}
+
+@test "decrypt refuses to decompress beyond -maxsize" {
+  run /vault/plugins/decrypt -privkey ../bats_x25519.pem -maxsize 8 < ../compressed_payload.txt
+  [ "$status" -ne 0 ]
+}
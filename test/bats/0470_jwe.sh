@@ -0,0 +1,54 @@
+#!/usr/bin/env bats
+
+@test "can request a compact JWE payload" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS1 -X POST \
+    --data '{"format": "jwe", "payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload > ../payload.jwe
+  cat ../payload.jwe
+
+  [ "$(tr -cd . < ../payload.jwe | wc -c)" -eq 4 ]
+}
+
+@test "compact JWE header names the algorithms and recipient key" {
+  HEADER=$(cut -d. -f1 ../payload.jwe | tr '_-' '/+')
+  while [ $(( ${#HEADER} % 4 )) -ne 0 ]; do HEADER="$HEADER="; done
+  echo "$HEADER" | base64 -d > ../payload.jwe.header
+  cat ../payload.jwe.header
+
+  FINGERPRINT=$(curl -s --header "X-Vault-Token: root" $VURL/e2e/enrole/BATS1 | jq -r .data.enrole.fingerprint)
+  [ "$(jq -r .alg ../payload.jwe.header)" = "RSA-OAEP-256" ]
+  [ "$(jq -r .enc ../payload.jwe.header)" = "A256GCM" ]
+  [ "$(jq -r .kid ../payload.jwe.header)" = "$FINGERPRINT" ]
+}
+
+@test "can decrypt a compact JWE payload" {
+  SECRET=$(/vault/plugins/decrypt -privkey ../bats_rsa.pem < ../payload.jwe | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
+
+@test "can request and decrypt a JSON serialised ECDH-ES JWE payload" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_p256 -X POST \
+    --data '{"format": "jwe-json", "payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -c .data.payload > ../payload.jwe.json
+  cat ../payload.jwe.json
+
+  SECRET=$(/vault/plugins/decrypt -privkey ../bats_p256.pem < ../payload.jwe.json | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
+
+@test "JWE is refused for hybrid recipients" {
+  RESP=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_PQ -X POST \
+    --data '{"format": "jwe", "payload": {"hello": "world"}}')
+  echo "$RESP"
+
+  echo "$RESP" | jq -r '.errors[0]' | grep "does not support"
+}
@@ -0,0 +1,77 @@
+#!/usr/bin/env bats
+
+@test "can enrole an ssh-ed25519 public key as an age recipient" {
+  rm -f ../bats_ssh_ed25519 ../bats_ssh_ed25519.pub
+  ssh-keygen -q -t ed25519 -N "" -f ../bats_ssh_ed25519
+  PUBKEY=$(jq -Rsc . < ../bats_ssh_ed25519.pub)
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request POST $VURL/e2e/enrole/BATS_AGE \
+    --data "{\"name\": \"BATS_AGE\", \"pubkey\":$PUBKEY}"
+
+  KEYTYPE=$(curl -s --header "X-Vault-Token: root" $VURL/e2e/enrole/BATS_AGE | jq -r .data.enrole.keytype)
+  [ "$KEYTYPE" = "ssh-ed25519" ]
+}
+
+@test "can request and decrypt an armoured age payload" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_AGE -X POST \
+    --data '{"format": "age", "payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload > ../payload.age.txt
+  cat ../payload.age.txt
+
+  head -1 ../payload.age.txt | grep -- "-----BEGIN AGE ENCRYPTED FILE-----"
+  SECRET=$(/vault/plugins/decrypt -privkey ../bats_ssh_ed25519 < ../payload.age.txt | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
+
+@test "can request and decrypt a binary age payload" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_AGE -X POST \
+    --data '{"format": "age-binary", "payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload | base64 -d > ../payload.age
+
+  head -1 ../payload.age | grep "age-encryption.org/v1"
+  SECRET=$(/vault/plugins/decrypt -privkey ../bats_ssh_ed25519 < ../payload.age | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
+
+@test "age recipients default to the armoured age format" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_AGE -X POST \
+    --data '{"payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload > ../payload.age.default.txt
+
+  head -1 ../payload.age.default.txt | grep -- "-----BEGIN AGE ENCRYPTED FILE-----"
+  SECRET=$(/vault/plugins/decrypt -privkey ../bats_ssh_ed25519 < ../payload.age.default.txt | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
+
+@test "age recipients are refused other formats" {
+  RESP=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_AGE -X POST \
+    --data '{"format": "armour", "payload": {"hello": "world"}}')
+  echo "$RESP"
+
+  echo "$RESP" | jq -r '.errors[0]' | grep "require the age or age-binary format"
+}
+
+@test "age format is refused for PEM recipients" {
+  RESP=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS1 -X POST \
+    --data '{"format": "age", "payload": {"hello": "world"}}')
+  echo "$RESP"
+
+  echo "$RESP" | jq -r '.errors[0]' | grep "requires an age or SSH recipient"
+}
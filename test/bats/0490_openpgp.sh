@@ -0,0 +1,59 @@
+#!/usr/bin/env bats
+
+export GNUPGHOME=$(cd .. && pwd)/bats_gnupg
+
+@test "can enrole an OpenPGP public key" {
+  rm -rf $GNUPGHOME
+  mkdir -m 700 $GNUPGHOME
+  gpg --batch --passphrase '' --quick-gen-key "BATS <bats@example.com>" ed25519 default never
+  FPR=$(gpg --list-keys --with-colons bats@example.com | awk -F: '/^fpr/ {print $10; exit}')
+  gpg --batch --passphrase '' --quick-add-key $FPR cv25519 encr never
+  gpg --armor --export bats@example.com > ../bats_pgp_pub.asc
+  gpg --batch --pinentry-mode loopback --passphrase '' --armor --export-secret-keys bats@example.com > ../bats_pgp.asc
+
+  PUBKEY=$(jq -Rsc . < ../bats_pgp_pub.asc)
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request POST $VURL/e2e/enrole/BATS_PGP \
+    --data "{\"name\": \"BATS_PGP\", \"pubkey\":$PUBKEY}"
+
+  ENROLE=$(curl -s --header "X-Vault-Token: root" $VURL/e2e/enrole/BATS_PGP)
+  echo "$ENROLE"
+  [ "$(echo "$ENROLE" | jq -r .data.enrole.keytype)" = "OpenPGP" ]
+  [ "$(echo "$ENROLE" | jq -r .data.enrole.fingerprint)" = "$FPR" ]
+  [ "$(echo "$ENROLE" | jq -r .data.enrole.keyid)" != "" ]
+}
+
+@test "OpenPGP recipients get a PGP MESSAGE that GnuPG can decrypt" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_PGP -X POST \
+    --data '{"payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload > ../payload.pgp
+  cat ../payload.pgp
+
+  head -1 ../payload.pgp | grep -- "-----BEGIN PGP MESSAGE-----"
+  SECRET=$(gpg --batch --decrypt ../payload.pgp | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
+
+@test "can decrypt a PGP MESSAGE payload" {
+  SECRET=$(/vault/plugins/decrypt -privkey ../bats_pgp.asc < ../payload.pgp | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
+
+@test "enrolement rejects an OpenPGP key without an encryption key" {
+  gpg --batch --passphrase '' --quick-gen-key "BATS SIGN <bats-sign@example.com>" ed25519 sign never
+  PUBKEY=$(gpg --armor --export bats-sign@example.com | jq -Rsc .)
+  RESP=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request POST $VURL/e2e/enrole/BATS_PGP_SIGN \
+    --data "{\"name\": \"BATS_PGP_SIGN\", \"pubkey\":$PUBKEY}")
+  echo "$RESP"
+
+  echo "$RESP" | jq -r '.errors[0]' | grep "no valid encryption key"
+}
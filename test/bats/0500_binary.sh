@@ -0,0 +1,37 @@
+#!/usr/bin/env bats
+
+@test "can request a binary container payload" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS1 -X POST \
+    --data '{"format": "binary", "ttl": 60, "payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload > ../payload.b64
+  cat ../payload.b64
+
+  base64 -d < ../payload.b64 > ../payload.bin
+  [ "$(head -c 4 ../payload.bin)" = "E2EP" ]
+}
+
+@test "can decrypt a base64 encoded binary container payload" {
+  SECRET=$(/vault/plugins/decrypt -privkey ../bats_rsa.pem < ../payload.b64 | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
+
+@test "can decrypt a binary container payload" {
+  SECRET=$(/vault/plugins/decrypt -privkey ../bats_rsa.pem < ../payload.bin | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
+
+@test "can decrypt a streamed ECDH binary container payload" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_x25519 -X POST \
+    --data '{"format": "binary", "stream": true, "payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload | base64 -d > ../payload.stream.bin
+
+  SECRET=$(/vault/plugins/decrypt -privkey ../bats_x25519.pem < ../payload.stream.bin | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
@@ -0,0 +1,22 @@
+#!/usr/bin/env bats
+
+@test "decrypt rejects a payload with no END line" {
+  PAYLOAD=$(grep -v "END E2E ENCRYPTED PAYLOAD" ../payload.txt)
+  run bash -c "echo \"$PAYLOAD\" | /vault/plugins/decrypt -privkey ../bats_rsa.pem"
+  echo "$output"
+  [ "$status" -ne 0 ]
+  echo "$output" | grep "has no END line"
+}
+
+@test "decrypt rejects a payload with an unknown header" {
+  PAYLOAD=$(sed 's/^PAYLOAD_VERSION: .*$/&\nX_UNKNOWN: 1/' ../payload.txt)
+  run bash -c "echo \"$PAYLOAD\" | /vault/plugins/decrypt -privkey ../bats_rsa.pem"
+  echo "$output"
+  [ "$status" -ne 0 ]
+  echo "$output" | grep "unknown header"
+}
+
+@test "decrypt rejects a truncated payload" {
+  run bash -c "head -c 60 ../payload.txt | /vault/plugins/decrypt -privkey ../bats_rsa.pem"
+  [ "$status" -ne 0 ]
+}
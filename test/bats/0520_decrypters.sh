@@ -0,0 +1,36 @@
+#!/usr/bin/env bats
+
+export SOFTHSM2_CONF=$(cd .. && pwd)/bats_softhsm2.conf
+PKCS11_URI="pkcs11:token=bats;object=bats?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234"
+
+@test "decrypt can decrypt with an external command" {
+  PAYLOAD=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS1 -X POST \
+    --data '{"payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload)
+
+  COMMAND='openssl pkeyutl -decrypt -inkey ../bats_rsa.pem -pkeyopt rsa_padding_mode:oaep -pkeyopt rsa_oaep_md:sha256 ${E2E_OAEP_LABEL:+-pkeyopt rsa_oaep_label:$E2E_OAEP_LABEL}'
+  SECRET=$(echo "$PAYLOAD" | /vault/plugins/decrypt -command "$COMMAND" -pubkey ../bats_rsa_pub.pem | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
+
+@test "decrypt can decrypt with a key in a PKCS#11 token (SoftHSM)" {
+  rm -rf ../bats_softhsm
+  mkdir ../bats_softhsm
+  echo "directories.tokendir = $(cd .. && pwd)/bats_softhsm" > $SOFTHSM2_CONF
+  softhsm2-util --init-token --free --label bats --pin 1234 --so-pin 5678
+  openssl pkcs8 -topk8 -nocrypt -in ../bats_rsa.pem -out ../bats_rsa_pkcs8.pem
+  softhsm2-util --import ../bats_rsa_pkcs8.pem --token bats --label bats --id 01 --pin 1234
+
+  FORM=$(/vault/plugins/decrypt-pkcs11 -pkcs11 "$PKCS11_URI" < ../payload.txt)
+  [ "$FORM" = "$(/vault/plugins/decrypt -privkey ../bats_rsa.pem < ../payload.txt)" ]
+  [ "$(echo "$FORM" | jq -r .level1.fromdeep)" != "null" ]
+}
+
+@test "decrypt without PKCS#11 support refuses -pkcs11" {
+  run /vault/plugins/decrypt -pkcs11 "$PKCS11_URI" < ../payload.txt
+  [ "$status" -ne 0 ]
+  echo "$output" | grep "built without pkcs11 support"
+}
@@ -0,0 +1,45 @@
+#!/usr/bin/env bats
+
+@test "decrypt accepts a PKCS#8 private key" {
+  openssl pkcs8 -topk8 -nocrypt -in ../bats_rsa.pem -out ../bats_rsa_p8.pem
+  FORM=$(/vault/plugins/decrypt -privkey ../bats_rsa_p8.pem < ../payload.txt)
+  [ "$FORM" = "$(/vault/plugins/decrypt -privkey ../bats_rsa.pem < ../payload.txt)" ]
+}
+
+@test "decrypt accepts an encrypted PKCS#8 private key, with the passphrase from the environment or a file" {
+  openssl pkcs8 -topk8 -v2 aes-256-cbc -passout pass:bats-secret -in ../bats_rsa.pem -out ../bats_rsa_p8_enc.pem
+  head -1 ../bats_rsa_p8_enc.pem | grep "ENCRYPTED PRIVATE KEY"
+
+  FORM=$(E2E_PASSPHRASE=bats-secret /vault/plugins/decrypt -privkey ../bats_rsa_p8_enc.pem < ../payload.txt)
+  [ "$FORM" = "$(/vault/plugins/decrypt -privkey ../bats_rsa.pem < ../payload.txt)" ]
+
+  echo bats-secret > ../bats_passphrase.txt
+  FORM=$(/vault/plugins/decrypt -privkey ../bats_rsa_p8_enc.pem -passphrase-file ../bats_passphrase.txt < ../payload.txt)
+  [ "$FORM" = "$(/vault/plugins/decrypt -privkey ../bats_rsa.pem < ../payload.txt)" ]
+}
+
+@test "decrypt refuses an incorrect passphrase" {
+  run bash -c "E2E_PASSPHRASE=wrong /vault/plugins/decrypt -privkey ../bats_rsa_p8_enc.pem < ../payload.txt"
+  [ "$status" -ne 0 ]
+  echo "$output" | grep "incorrect private key passphrase"
+}
+
+@test "decrypt accepts an encrypted OpenSSH private key" {
+  rm -f ../bats_ssh_rsa ../bats_ssh_rsa.pub
+  ssh-keygen -q -t rsa -b 2048 -N bats-secret -C bats -f ../bats_ssh_rsa
+  PUBKEY=$(ssh-keygen -e -m PKCS8 -f ../bats_ssh_rsa.pub | jq -Rsc .)
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request POST $VURL/e2e/enrole/BATS_SSH_RSA \
+    --data "{\"name\": \"BATS_SSH_RSA\", \"pubkey\":$PUBKEY}"
+
+  SECRET=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_SSH_RSA -X POST \
+    --data '{"payload": {"secretData@/e2e/kv/my-secret.mydata": true}}' | jq -r .data.payload | \
+    E2E_PASSPHRASE=bats-secret /vault/plugins/decrypt -privkey ../bats_ssh_rsa | jq -r .secretData)
+  [ "$SECRET" != "" ]
+  [ "$SECRET" != "null" ]
+}
@@ -0,0 +1,73 @@
+#!/usr/bin/env bats
+
+setup() {
+  export VAULT_ADDR=${VURL%/v1} VAULT_TOKEN=root
+}
+
+@test "e2ectl keygen generates PKCS#8 key pairs and will not overwrite them" {
+  rm -f ../bats_ctl_p256.pem ../bats_ctl_p256_pub.pem
+  /vault/plugins/e2ectl keygen -type p256 -prefix ../bats_ctl
+  head -1 ../bats_ctl_p256.pem | grep "BEGIN PRIVATE KEY"
+  head -1 ../bats_ctl_p256_pub.pem | grep "BEGIN PUBLIC KEY"
+
+  run /vault/plugins/e2ectl keygen -type p256 -prefix ../bats_ctl
+  [ "$status" -eq 1 ]
+}
+
+@test "e2ectl keygen takes genrsapair's flags" {
+  echo bats-secret > ../bats_ctl_passphrase.txt
+  /vault/plugins/e2ectl keygen -force -type p256 -prefix ../bats_ctlgen -encrypt -passphrase-file ../bats_ctl_passphrase.txt -jwk -enrole BATS_CTLGEN
+  head -1 ../bats_ctlgen_p256.pem | grep "ENCRYPTED PRIVATE KEY"
+  [ "$(jq -r .crv ../bats_ctlgen_p256_pub.jwk)" = "P-256" ]
+  [ "$(jq -r .name ../bats_ctlgen_p256_enrole.json)" = "BATS_CTLGEN" ]
+}
+
+@test "e2ectl enrols a public key" {
+  ENROLE=$(/vault/plugins/e2ectl enrol -pubkey ../bats_ctl_p256_pub.pem -compression GZIP BATS_CTL)
+  [ "$(echo "$ENROLE" | jq -r .keytype)" = "P-256" ]
+  [ "$(echo "$ENROLE" | jq -r .compression)" = "GZIP" ]
+}
+
+@test "e2ectl puts and gets kv secrets" {
+  /vault/plugins/e2ectl kv put bats-ctl user=bats password=ctl-secret
+  [ "$(/vault/plugins/e2ectl kv get -field password bats-ctl)" = "ctl-secret" ]
+
+  echo '{"token":"from-stdin"}' | /vault/plugins/e2ectl kv put bats-ctl2
+  [ "$(/vault/plugins/e2ectl kv get bats-ctl2 | jq -r .token)" = "from-stdin" ]
+}
+
+@test "e2ectl decrypts, inspects and verifies a payload from the plugin" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_CTL -X POST \
+    --data '{"sign": true, "payload": {"password@/e2e/kv/bats-ctl.password": true}}' \
+    | jq -r .data.payload > ../payload_ctl.txt
+
+  [ "$(/vault/plugins/e2ectl decrypt -privkey ../bats_ctl_p256.pem < ../payload_ctl.txt | jq -r .password)" = "ctl-secret" ]
+
+  HEADERS=$(/vault/plugins/e2ectl inspect < ../payload_ctl.txt)
+  [ "$(echo "$HEADERS" | jq -r .key_agreement)" = "P-256" ]
+  [ "$(echo "$HEADERS" | jq -r .compression)" = "GZIP" ]
+
+  /vault/plugins/e2ectl verify -privkey ../bats_ctl_p256.pem -signing-key $VURL/e2e/signingkey < ../payload_ctl.txt
+}
+
+@test "e2ectl encrypts offline to a local public key" {
+  echo '{"offline": "yes"}' | /vault/plugins/e2ectl encrypt -pubkey ../bats_ctl_p256_pub.pem -format binary -stream > ../payload_ctl_offline.txt
+  [ "$(/vault/plugins/e2ectl decrypt -privkey ../bats_ctl_p256.pem < ../payload_ctl_offline.txt | jq -r .offline)" = "yes" ]
+  [ "$(/vault/plugins/e2ectl inspect < ../payload_ctl_offline.txt | jq -r .format)" = "container-base64" ]
+}
+
+@test "e2ectl verify refuses a payload for another key" {
+  run bash -c "/vault/plugins/e2ectl verify -privkey ../bats_rsa.pem -signing-key $VURL/e2e/signingkey < ../payload_ctl.txt"
+  [ "$status" -eq 1 ]
+}
+
+@test "e2ectl verify fetches the signing key and refuses unsigned payloads" {
+  /vault/plugins/e2ectl verify -privkey ../bats_ctl_p256.pem < ../payload_ctl.txt
+
+  run bash -c "/vault/plugins/e2ectl verify -privkey ../bats_ctl_p256.pem < ../payload_ctl_offline.txt"
+  echo "$output"
+  [ "$status" -eq 1 ]
+}
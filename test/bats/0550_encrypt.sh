@@ -0,0 +1,31 @@
+#!/usr/bin/env bats
+
+@test "encrypt produces payloads decrypt reads, in the plugin's formats" {
+  for FORMAT in armour binary jwe jwe-json; do
+    echo '{"fixture": "offline"}' | /vault/plugins/encrypt -pubkey ../bats_rsa_pub.pem -format $FORMAT -compression GZIP > ../payload_offline.txt
+    [ "$(/vault/plugins/decrypt -privkey ../bats_rsa.pem < ../payload_offline.txt | jq -r .fixture)" = "offline" ]
+  done
+}
+
+@test "encrypt output has the same headers as the plugin's" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    --request POST $VURL/e2e/enrole/BATS_OFFLINE \
+    --data "{\"name\": \"BATS_OFFLINE\", \"pubkey\":$(jq -Rsc . < ../bats_rsa_pub.pem), \"cipher\": \"CHACHA20-POLY1305\", \"compression\": \"ZSTD\"}"
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_OFFLINE -X POST \
+    --data '{"payload": {"fixture": "vault"}, "stream": true}' \
+    | jq -r .data.payload > ../payload_vault.txt
+
+  echo '{"fixture": "offline"}' | /vault/plugins/encrypt -pubkey ../bats_rsa_pub.pem -cipher CHACHA20-POLY1305 -compression ZSTD -stream > ../payload_offline.txt
+  [ "$(sed -n '1,/^$/p' ../payload_offline.txt)" = "$(sed -n '1,/^$/p' ../payload_vault.txt)" ]
+}
+
+@test "encrypt refuses a format the recipient cannot decrypt" {
+  run bash -c "echo '{}' | /vault/plugins/encrypt -pubkey ../bats_rsa_pub.pem -format age"
+  [ "$status" -ne 0 ]
+  echo "$output" | grep "age format requires an age or SSH recipient"
+}
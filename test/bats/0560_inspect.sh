@@ -0,0 +1,51 @@
+#!/usr/bin/env bats
+
+@test "decrypt -inspect reports an RSA payload's headers without a key" {
+  INSPECT=$(/vault/plugins/decrypt -inspect < ../payload.txt)
+  [ "$(echo "$INSPECT" | jq -r .format)" = "armour" ]
+  [ "$(echo "$INSPECT" | jq -r .rsa_block_length)" = "256" ]
+  [ "$(echo "$INSPECT" | jq -r '.recipients[0].type')" = "rsa-2048" ]
+}
+
+@test "payload/inspect reports a leased payload's recipient" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_OFFLINE -X POST \
+    --data '{"payload": {"fixture": "vault"}, "ttl": 60}' \
+    | jq -r .data.payload > ../payload_inspect.txt
+
+  INSPECT=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/inspect -X POST \
+    --data "{\"payload\": $(jq -Rsc . < ../payload_inspect.txt)}")
+  [ "$(echo "$INSPECT" | jq -r .data.inspection.cipher)" = "CHACHA20-POLY1305" ]
+  [ "$(echo "$INSPECT" | jq -r .data.leased_payload.name)" = "BATS_OFFLINE" ]
+  [ "$(echo "$INSPECT" | jq -r .data.leased_payload.revoked)" = "" ]
+}
+
+@test "payload/inspect matches a JWE's kid to its enrolement" {
+  curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_CTL -X POST \
+    --data '{"payload": {"fixture": "vault"}, "format": "jwe"}' \
+    | jq -r .data.payload > ../payload_inspect_jwe.txt
+
+  INSPECT=$(curl -s -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/inspect -X POST \
+    --data "{\"payload\": $(jq -Rsc . < ../payload_inspect_jwe.txt)}")
+  [ "$(echo "$INSPECT" | jq -r '.data.enrolements[0]')" = "BATS_CTL" ]
+}
+
+@test "payload/inspect rejects what is not a payload" {
+  run curl -s -f -H "Accept: application/json" \
+    -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/inspect -X POST \
+    --data '{"payload": "not a payload"}'
+  [ "$status" -ne 0 ]
+}
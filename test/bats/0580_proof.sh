@@ -0,0 +1,104 @@
+#!/usr/bin/env bats
+
+setup() {
+  export VAULT_ADDR=${VURL%/v1} VAULT_TOKEN=root
+}
+
+@test "config requires proof of possession" {
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/config -X POST \
+    --data '{"require_proof": true, "challenge_ttl": "5m"}'
+  [ "$(curl -s --header "X-Vault-Token: root" $VURL/e2e/config | jq -r .data.require_proof)" = "true" ]
+
+  rm -f ../bats_proof_p256.pem ../bats_proof_p256_pub.pem
+  /vault/plugins/e2ectl keygen -type p256 -prefix ../bats_proof
+  run /vault/plugins/e2ectl enrol -pubkey ../bats_proof_p256_pub.pem BATS_PROOF_NONE
+  [ "$status" -eq 1 ]
+}
+
+@test "enrolement accepts a signed challenge" {
+  curl -s -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/enrole/BATS_PROOF_SIG/challenge -X POST \
+    | jq -r -j .data.challenge > ../bats_proof_challenge.txt
+  SIG=$(openssl dgst -sha256 -sign ../bats_proof_p256.pem ../bats_proof_challenge.txt | base64 -w0)
+
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/enrole/BATS_PROOF_SIG -X POST \
+    --data "{\"pubkey\": $(jq -Rsc . < ../bats_proof_p256_pub.pem), \"signature\": \"$SIG\"}"
+  [ "$(curl -s --header "X-Vault-Token: root" $VURL/e2e/enrole/BATS_PROOF_SIG | jq -r .data.enrole.proof)" = "challenge" ]
+}
+
+@test "enrolement rejects a signature by another key" {
+  curl -s -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/enrole/BATS_PROOF_BAD/challenge -X POST \
+    | jq -r -j .data.challenge > ../bats_proof_challenge.txt
+  SIG=$(openssl dgst -sha256 -sign ../bats_ctl_p256.pem ../bats_proof_challenge.txt | base64 -w0)
+
+  run curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/enrole/BATS_PROOF_BAD -X POST \
+    --data "{\"pubkey\": $(jq -Rsc . < ../bats_proof_p256_pub.pem), \"signature\": \"$SIG\"}"
+  [ "$status" -ne 0 ]
+}
+
+@test "enrolement accepts a certificate request" {
+  openssl req -new -key ../bats_proof_p256.pem -subj /CN=BATS_PROOF_CSR -out ../bats_proof.csr
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/enrole/BATS_PROOF_CSR -X POST \
+    --data "{\"csr\": $(jq -Rsc . < ../bats_proof.csr)}"
+  [ "$(curl -s --header "X-Vault-Token: root" $VURL/e2e/enrole/BATS_PROOF_CSR | jq -r .data.enrole.keytype)" = "P-256" ]
+}
+
+@test "enrolement rejects a certificate request for another name" {
+  run curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/enrole/BATS_PROOF_CSR_REPLAY -X POST \
+    --data "{\"csr\": $(jq -Rsc . < ../bats_proof.csr)}"
+  [ "$status" -ne 0 ]
+}
+
+@test "e2ectl enrol signs the challenge" {
+  ENROLE=$(/vault/plugins/e2ectl enrol -pubkey ../bats_proof_p256_pub.pem -privkey ../bats_proof_p256.pem BATS_PROOF_CTL)
+  [ "$(echo "$ENROLE" | jq -r .proof)" = "challenge" ]
+}
+
+@test "e2ectl enrol decrypts the challenge for keys that cannot sign" {
+  /vault/plugins/e2ectl keygen -force -type x25519 -prefix ../bats_proof
+  ENROLE=$(/vault/plugins/e2ectl enrol -pubkey ../bats_proof_x25519_pub.pem -privkey ../bats_proof_x25519.pem BATS_PROOF_X25519)
+  [ "$(echo "$ENROLE" | jq -r .proof)" = "decryption" ]
+}
+
+@test "enrolement accepts a decrypted challenge and rejects a wrong one" {
+  /vault/plugins/e2ectl keygen -force -type mlkem768x25519 -prefix ../bats_proof
+  curl -s -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/enrole/BATS_PROOF_HYBRID/challenge -X POST \
+    --data "{\"pubkey\": $(jq -Rsc . < ../bats_proof_mlkem768x25519_pub.pem)}" > ../bats_proof_challenge.json
+  [ "$(jq -r .data.challenge ../bats_proof_challenge.json)" = "null" ]
+  jq -r .data.encrypted_challenge ../bats_proof_challenge.json \
+    | /vault/plugins/decrypt -privkey ../bats_proof_mlkem768x25519.pem > ../bats_proof_response.txt
+
+  run curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/enrole/BATS_PROOF_HYBRID -X POST \
+    --data "{\"pubkey\": $(jq -Rsc . < ../bats_proof_mlkem768x25519_pub.pem), \"response\": \"wrong\"}"
+  [ "$status" -ne 0 ]
+
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/enrole/BATS_PROOF_HYBRID -X POST \
+    --data "{\"pubkey\": $(jq -Rsc . < ../bats_proof_mlkem768x25519_pub.pem), \"response\": $(jq -Rsc . < ../bats_proof_response.txt)}"
+  [ "$(curl -s --header "X-Vault-Token: root" $VURL/e2e/enrole/BATS_PROOF_HYBRID | jq -r .data.enrole.proof)" = "decryption" ]
+}
+
+@test "config no longer requires proof" {
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/config -X POST \
+    --data '{"require_proof": false}'
+}
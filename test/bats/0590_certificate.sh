@@ -0,0 +1,89 @@
+#!/usr/bin/env bats
+
+@test "create a test CA, recipient certificate and CRL" {
+  cd ..
+  rm -rf bats_pki && mkdir bats_pki && cd bats_pki
+  openssl req -x509 -newkey rsa:2048 -nodes -keyout ca.key -subj /CN=bats-ca -days 30 -out ca.pem \
+    -addext basicConstraints=critical,CA:TRUE -addext keyUsage=critical,keyCertSign,cRLSign
+  printf "[leaf]\nbasicConstraints=CA:FALSE\nkeyUsage=critical,keyAgreement\n" > ext.cnf
+  for leaf in bats_cert bats_cert_revoked; do
+    openssl req -new -key ../bats_proof_p256.pem -subj /CN=$leaf -out $leaf.csr
+    openssl x509 -req -in $leaf.csr -CA ca.pem -CAkey ca.key -CAcreateserial -days 10 \
+      -extfile ext.cnf -extensions leaf -out $leaf.pem
+  done
+  touch index.txt && echo 01 > crlnumber
+  printf "[ca]\ndefault_ca=d\n[d]\ndatabase=index.txt\ncrlnumber=crlnumber\ndefault_md=sha256\ndefault_crl_days=30\n" > ca.cnf
+  openssl ca -config ca.cnf -revoke bats_cert_revoked.pem -keyfile ca.key -cert ca.pem
+  openssl ca -config ca.cnf -gencrl -keyfile ca.key -cert ca.pem -out ca.crl
+}
+
+@test "certificate enrolement needs a trusted CA" {
+  run curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/enrole/BATS_CERT -X POST \
+    --data "{\"certificate\": $(jq -Rsc . < ../bats_pki/bats_cert.pem)}"
+  [ "$status" -ne 0 ]
+}
+
+@test "enrol a certificate issued by the configured CA" {
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/config -X POST \
+    --data "{\"ca_certificates\": $(jq -Rsc . < ../bats_pki/ca.pem)}"
+
+  for leaf in BATS_CERT BATS_CERT_REVOKED; do
+    curl -s -f -H "Content-type: application/json" \
+      --header "X-Vault-Token: root" \
+      $VURL/e2e/enrole/$leaf -X POST \
+      --data "{\"certificate\": $(jq -Rsc . < ../bats_pki/$(echo $leaf | tr A-Z a-z).pem)}"
+  done
+  ENROLE=$(curl -s --header "X-Vault-Token: root" $VURL/e2e/enrole/BATS_CERT)
+  [ "$(echo "$ENROLE" | jq -r .data.enrole.keytype)" = "P-256" ]
+  [ "$(echo "$ENROLE" | jq -r .data.enrole.expires_at)" != "" ]
+}
+
+@test "payloads are refused for a certificate the CRL revokes" {
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/config -X POST \
+    --data "{\"crl\": $(jq -Rsc . < ../bats_pki/ca.crl)}"
+
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_CERT -X POST \
+    --data '{"payload": {"fixture": "vault"}}' \
+    | jq -r .data.payload > ../payload_cert.txt
+  [ "$(/vault/plugins/e2ectl decrypt -privkey ../bats_proof_p256.pem < ../payload_cert.txt | jq -r .fixture)" = "vault" ]
+
+  run curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_CERT_REVOKED -X POST \
+    --data '{"payload": {"fixture": "vault"}}'
+  [ "$status" -ne 0 ]
+}
+
+@test "a stale CRL is refused unless allow_stale_crl is set" {
+  (cd ../bats_pki && openssl ca -config ca.cnf -gencrl -keyfile ca.key -cert ca.pem \
+    -crl_lastupdate 200101000000Z -crl_nextupdate 200102000000Z -out stale.crl)
+
+  run curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/config -X POST \
+    --data "{\"crl\": $(jq -Rsc . < ../bats_pki/stale.crl)}"
+  [ "$status" -ne 0 ]
+
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/config -X POST \
+    --data "{\"crl\": $(jq -Rsc . < ../bats_pki/stale.crl), \"allow_stale_crl\": true}"
+  [ "$(curl -s --header "X-Vault-Token: root" $VURL/e2e/config | jq -r .data.allow_stale_crl)" = "true" ]
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_CERT -X POST \
+    --data '{"payload": {"fixture": "vault"}}'
+
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/config -X POST \
+    --data "{\"crl\": $(jq -Rsc . < ../bats_pki/ca.crl), \"allow_stale_crl\": false}"
+}
@@ -0,0 +1,34 @@
+#!/usr/bin/env bats
+
+@test "enrolement with a ttl records its expiry" {
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/enrole/BATS_EXPIRY -X POST \
+    --data "{\"pubkey\": $(jq -Rsc . < ../bats_proof_p256_pub.pem), \"ttl\": \"2s\"}"
+  [ "$(curl -s --header "X-Vault-Token: root" $VURL/e2e/enrole/BATS_EXPIRY | jq -r .data.enrole.expires_at)" != "" ]
+}
+
+@test "enrolement rejects an expires_at in the past" {
+  run curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/enrole/BATS_EXPIRED -X POST \
+    --data "{\"pubkey\": $(jq -Rsc . < ../bats_proof_p256_pub.pem), \"expires_at\": \"2020-01-01T00:00:00Z\"}"
+  [ "$status" -ne 0 ]
+}
+
+@test "payloads are refused once the enrolement expires" {
+  sleep 3
+  run curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS_EXPIRY -X POST \
+    --data '{"payload": {"fixture": "vault"}}'
+  [ "$status" -ne 0 ]
+}
+
+@test "config sets the revoked enrolement retention" {
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/config -X POST \
+    --data '{"enrolement_retention": "2160h"}'
+  [ "$(curl -s --header "X-Vault-Token: root" $VURL/e2e/config | jq -r .data.enrolement_retention)" = "7776000" ]
+}
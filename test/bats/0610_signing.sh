@@ -0,0 +1,66 @@
+#!/usr/bin/env bats
+
+@test "signing key is published without a token" {
+  curl -s -f $VURL/e2e/signingkey > ../signingkey.json
+  cat ../signingkey.json
+  jq -r .data.public_key ../signingkey.json | grep "BEGIN PUBLIC KEY"
+  [ "$(jq -r .data.key_id ../signingkey.json)" != "" ]
+}
+
+@test "payloads are unsigned version 2.0 payloads unless signing is requested" {
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS1 -X POST \
+    --data '{"payload": {"fixture": "unsigned"}}' | jq -r .data.payload > ../plain_payload.txt
+
+  [ "$(sed -n '2,/^$/p' ../plain_payload.txt)" = "PAYLOAD_VERSION: 2.0" ]
+  /vault/plugins/decrypt -privkey ../bats_rsa.pem < ../plain_payload.txt | grep unsigned
+}
+
+@test "payloads requested with sign are signed by the signing key" {
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS1 -X POST \
+    --data '{"sign": true, "payload": {"fixture": "signed"}}' | jq -r .data.payload > ../signed_payload.txt
+
+  grep "SIGNING_KEY: $(jq -r .data.key_id ../signingkey.json)" ../signed_payload.txt
+  grep "^SIGNATURE: " ../signed_payload.txt
+  /vault/plugins/decrypt -privkey ../bats_rsa.pem -signing-key $VURL/e2e/signingkey < ../signed_payload.txt | grep signed
+}
+
+@test "decrypt refuses an unsigned payload with -signing-key" {
+  /vault/plugins/encrypt -pubkey ../bats_rsa_pub.pem <<< '{"fixture": "forged"}' > ../unsigned_payload.txt
+  run /vault/plugins/decrypt -privkey ../bats_rsa.pem -signing-key $VURL/e2e/signingkey < ../unsigned_payload.txt
+  echo "$output"
+  [ "$status" -ne 0 ]
+  /vault/plugins/decrypt -privkey ../bats_rsa.pem < ../unsigned_payload.txt | grep forged
+}
+
+@test "decrypt refuses a payload whose signed headers were changed" {
+  sed '/^SIGNATURE: /d' ../signed_payload.txt > ../stripped_payload.txt
+  run /vault/plugins/decrypt -privkey ../bats_rsa.pem -signing-key $VURL/e2e/signingkey < ../stripped_payload.txt
+  [ "$status" -ne 0 ]
+}
+
+@test "jwe-json payloads are signed" {
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS1 -X POST \
+    --data '{"format": "jwe-json", "sign": true, "payload": {"fixture": "signed"}}' | jq -c .data.payload > ../signed_jwe.json
+
+  [ "$(jq -r .unprotected.e2e_signature ../signed_jwe.json)" != "null" ]
+  /vault/plugins/decrypt -privkey ../bats_rsa.pem -signing-key $VURL/e2e/signingkey < ../signed_jwe.json | grep signed
+}
+
+@test "payloads with expires_in carry an EXPIRES header and are refused after it" {
+  curl -s -f -H "Content-type: application/json" \
+    --header "X-Vault-Token: root" \
+    $VURL/e2e/payload/BATS1 -X POST \
+    --data '{"expires_in": "2s", "payload": {"fixture": "expiring"}}' | jq -r .data.payload > ../expiring_payload.txt
+
+  grep "^EXPIRES: " ../expiring_payload.txt
+  /vault/plugins/decrypt -privkey ../bats_rsa.pem < ../expiring_payload.txt | grep expiring
+  sleep 3
+  run /vault/plugins/decrypt -privkey ../bats_rsa.pem < ../expiring_payload.txt
+  [ "$status" -eq 2 ]
+}